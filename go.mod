@@ -3,18 +3,19 @@ module github.com/Veraticus/clearingway
 go 1.22.1
 
 require (
+	github.com/PuerkitoBio/goquery v1.8.1
 	github.com/Vivino/go-autocomplete-trie v0.0.0-20230301121706-da951497d081
 	github.com/bwmarrin/discordgo v0.28.2-0.20240514123316-2c9c884e4fae
 	github.com/gocolly/colly v1.2.0
 	github.com/hasura/go-graphql-client v0.10.0
 	github.com/stretchr/testify v1.7.1
+	github.com/temoto/robotstxt v1.1.2
 	golang.org/x/oauth2 v0.15.0
 	golang.org/x/text v0.14.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
-	github.com/PuerkitoBio/goquery v1.8.1 // indirect
 	github.com/andybalholm/cascadia v1.3.2 // indirect
 	github.com/antchfx/htmlquery v1.3.0 // indirect
 	github.com/antchfx/xmlquery v1.3.18 // indirect
@@ -28,7 +29,6 @@ require (
 	github.com/kennygrant/sanitize v1.2.4 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/saintfish/chardet v0.0.0-20230101081208-5e3ef4b5456d // indirect
-	github.com/temoto/robotstxt v1.1.2 // indirect
 	golang.org/x/crypto v0.16.0 // indirect
 	golang.org/x/net v0.19.0 // indirect
 	golang.org/x/sys v0.15.0 // indirect
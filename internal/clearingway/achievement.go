@@ -1,5 +1,7 @@
 package clearingway
 
+import "fmt"
+
 type Achievement struct {
 	Title string `yaml:"name"`
 	Type  string
@@ -21,6 +23,29 @@ func (a *Achievements) Roles() *Roles {
 	return roles
 }
 
+// PendingRoles returns the roles for every achievement in the list of
+// achievements a character has earned.
+func (a *Achievements) PendingRoles(clearedAchievements []string) []*pendingRole {
+	cleared := map[string]bool{}
+	for _, clearedAchievement := range clearedAchievements {
+		cleared[clearedAchievement] = true
+	}
+
+	pendingRoles := []*pendingRole{}
+	for _, achievement := range a.Achievements {
+		if !cleared[achievement.Title] {
+			continue
+		}
+		for _, role := range achievement.Roles {
+			pendingRoles = append(pendingRoles, &pendingRole{
+				role:    role,
+				message: fmt.Sprintf("Earned the achievement `%s`", achievement.Title),
+			})
+		}
+	}
+	return pendingRoles
+}
+
 func (a *Achievement) Init(c *ConfigAchievement) {
 	a.Title = c.Title
 	a.Roles = map[RoleType]*Role{}
@@ -41,5 +66,8 @@ func (a *Achievement) Init(c *ConfigAchievement) {
 		if configRole.Color != 0 {
 			role.Color = configRole.Color
 		}
+		if len(configRole.Requires) != 0 {
+			role.Requires = configRole.Requires
+		}
 	}
 }
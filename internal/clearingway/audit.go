@@ -0,0 +1,26 @@
+package clearingway
+
+import (
+	"fmt"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Audit records something moderators may want to look back on, like a
+// character being renamed. It is always logged, and also posted to the
+// guild's audit channel if it has one.
+func (c *Clearingway) Audit(g *Guild, format string, args ...any) {
+	message := fmt.Sprintf(format, args...)
+	fmt.Printf("Audit in %s: %s\n", g.Name, message)
+	if len(g.AuditChannelId) == 0 {
+		return
+	}
+
+	_, err := c.Discord.Session.ChannelMessageSendComplex(g.AuditChannelId, &discordgo.MessageSend{
+		Content:         message,
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	})
+	if err != nil {
+		fmt.Printf("Error sending audit message to %s: %v\n", g.AuditChannelId, err)
+	}
+}
@@ -0,0 +1,51 @@
+package clearingway
+
+import (
+	"fmt"
+
+	"github.com/Veraticus/clearingway/internal/ffxiv"
+	"github.com/Veraticus/clearingway/internal/lodestone"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// CharacterEmbed shows a character's Lodestone portrait with what their
+// profile says about them.
+func CharacterEmbed(char *ffxiv.Character, profile *lodestone.CharacterProfile, region lodestone.Region) *discordgo.MessageEmbed {
+	embed := &discordgo.MessageEmbed{
+		Title:       fmt.Sprintf("%s (%s)", char.Name(), char.World),
+		URL:         region.CharacterURL(profile.LodestoneID),
+		Description: profile.Title,
+		Color:       0x11806a,
+	}
+	if len(profile.Portrait) != 0 {
+		embed.Image = &discordgo.MessageEmbedImage{URL: profile.Portrait}
+	}
+	if len(profile.ActiveJobIcon) != 0 {
+		embed.Thumbnail = &discordgo.MessageEmbedThumbnail{URL: profile.ActiveJobIcon}
+	}
+
+	if profile.ActiveJobLevel != 0 {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:   "Level",
+			Value:  fmt.Sprintf("%d", profile.ActiveJobLevel),
+			Inline: true,
+		})
+	}
+	if len(profile.GrandCompany) != 0 {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:   "Grand Company",
+			Value:  fmt.Sprintf("%s (%s)", profile.GrandCompany, profile.GrandCompanyRank),
+			Inline: true,
+		})
+	}
+	if len(profile.FreeCompanyName) != 0 {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:   "Free Company",
+			Value:  fmt.Sprintf("[%s](%s/freecompany/%s/)", profile.FreeCompanyName, region.URL(), profile.FreeCompanyID),
+			Inline: true,
+		})
+	}
+
+	return embed
+}
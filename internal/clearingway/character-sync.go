@@ -0,0 +1,53 @@
+package clearingway
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Veraticus/clearingway/internal/ffxiv"
+	"github.com/Veraticus/clearingway/internal/lodestone"
+)
+
+// SyncCharacter keys a character whose Lodestone ID is known by that ID and
+// refreshes its name and world from the Lodestone, so a member who renamed or
+// transferred keeps their verification and FF Logs is asked about the
+// character under its current name. It returns the character to use from now
+// on, which is the one already known if the Lodestone ID was seen before.
+func (c *Clearingway) SyncCharacter(g *Guild, char *ffxiv.Character) (*ffxiv.Character, error) {
+	if char.LodestoneID == 0 {
+		return char, nil
+	}
+	char = g.Characters.Identify(char)
+
+	profile, err := lodestone.CachedCharacterProfile(char, g.LodestoneRegionFor(char))
+	if err != nil {
+		return char, err
+	}
+	firstName, lastName, ok := strings.Cut(profile.Name, " ")
+	if !ok || len(profile.World) == 0 {
+		return char, fmt.Errorf("Could not read the name and world of Lodestone ID %d!", char.LodestoneID)
+	}
+	// Names are title cased here but not always on the Lodestone.
+	if strings.EqualFold(char.Name(), profile.Name) && strings.EqualFold(char.World, profile.World) {
+		return char, nil
+	}
+
+	oldName, oldWorld := char.Name(), char.World
+	g.Characters.Rename(char, firstName, lastName, profile.World)
+	discordUser := "no one yet"
+	if len(char.DiscordId) != 0 {
+		discordUser = fmt.Sprintf("<@%s>", char.DiscordId)
+	}
+	c.Audit(
+		g,
+		"`%s (%s)` is now `%s (%s)` (Lodestone ID %d, verified by %s).",
+		oldName,
+		oldWorld,
+		char.Name(),
+		char.World,
+		char.LodestoneID,
+		discordUser,
+	)
+
+	return char, nil
+}
@@ -0,0 +1,301 @@
+package clearingway
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Veraticus/clearingway/internal/discord"
+	"github.com/Veraticus/clearingway/internal/ffxiv"
+	"github.com/Veraticus/clearingway/internal/lodestone"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+var CharactersCommand = &discordgo.ApplicationCommand{
+	Name:        "characters",
+	Description: "Manage the characters linked to you. Your roles count clears from all of them.",
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "list",
+			Description: "List the characters linked to you.",
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "add",
+			Description: "Verify you own another character and link it to you.",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:         discordgo.ApplicationCommandOptionString,
+					Name:         "world",
+					Description:  "Your character's world",
+					Required:     true,
+					Autocomplete: true,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "first-name",
+					Description: "Your character's first name",
+					Required:    true,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "last-name",
+					Description: "Your character's last name",
+					Required:    true,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "lodestone",
+					Description: "Your Lodestone profile URL or ID, if others share your character's name",
+					Required:    false,
+				},
+			},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "remove",
+			Description: "Unlink one of your characters.",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:         discordgo.ApplicationCommandOptionString,
+					Name:         "character",
+					Description:  "The character to unlink",
+					Required:     true,
+					Autocomplete: true,
+				},
+			},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "set-primary",
+			Description: "Choose the character you are known by.",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:         discordgo.ApplicationCommandOptionString,
+					Name:         "character",
+					Description:  "Your primary character",
+					Required:     true,
+					Autocomplete: true,
+				},
+			},
+		},
+	},
+}
+
+func (c *Clearingway) Characters(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	g, ok := c.Guilds.Guilds[i.GuildID]
+	if !ok {
+		fmt.Printf("Interaction received from guild %s with no configuration!\n", i.GuildID)
+		return
+	}
+
+	// Ignore messages not on the correct channel
+	if i.ChannelID != g.ChannelId {
+		fmt.Printf("Ignoring message not in channel %s.\n", g.ChannelId)
+	}
+
+	options := i.ApplicationCommandData().Options
+	if len(options) == 0 {
+		fmt.Printf("Received /characters with no subcommand!\n")
+		return
+	}
+	subcommand := options[0]
+	optionMap := make(map[string]*discordgo.ApplicationCommandInteractionDataOption, len(subcommand.Options))
+	for _, opt := range subcommand.Options {
+		optionMap[opt.Name] = opt
+	}
+
+	err := discord.StartInteraction(s, i.Interaction, fmt.Sprintf("Received `/characters %s`...", subcommand.Name))
+	if err != nil {
+		fmt.Printf("Error sending Discord message: %v\n", err)
+		return
+	}
+
+	var message string
+	switch subcommand.Name {
+	case "list":
+		message = c.listCharacters(g, i.Member.User.ID)
+	case "add":
+		message = c.addCharacter(s, i, g, optionMap)
+	case "remove":
+		message = c.removeCharacter(g, i.Member.User.ID, optionMap)
+	case "set-primary":
+		message = c.setPrimaryCharacter(g, i.Member.User.ID, optionMap)
+	default:
+		fmt.Printf("Received unknown subcommand /characters %s!\n", subcommand.Name)
+		return
+	}
+	if len(message) == 0 {
+		return
+	}
+
+	err = discord.ContinueInteraction(s, i.Interaction, message)
+	if err != nil {
+		fmt.Printf("Error sending Discord message: %v\n", err)
+	}
+}
+
+func (c *Clearingway) listCharacters(g *Guild, discordId string) string {
+	chars := g.Characters.ForDiscordId(discordId)
+	if len(chars) == 0 {
+		return "You have not linked any characters yet! Use `/clears` or `/characters add` to link one."
+	}
+
+	lines := []string{"__Your characters:__"}
+	for _, char := range chars {
+		line := fmt.Sprintf("⮕ `%s (%s)` <%s>", char.Name(), char.World, g.LodestoneRegionFor(char).CharacterURL(char.LodestoneID))
+		if char.Primary {
+			line += " **(primary)**"
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// addCharacter links another character without analyzing its logs, so
+// members can link all their characters before running /clears once.
+func (c *Clearingway) addCharacter(
+	s *discordgo.Session,
+	i *discordgo.InteractionCreate,
+	g *Guild,
+	optionMap map[string]*discordgo.ApplicationCommandInteractionDataOption,
+) string {
+	var world, firstName, lastName string
+	if option, ok := optionMap["world"]; ok {
+		world = option.StringValue()
+	}
+	if option, ok := optionMap["first-name"]; ok {
+		firstName = option.StringValue()
+	}
+	if option, ok := optionMap["last-name"]; ok {
+		lastName = option.StringValue()
+	}
+	if len(world) == 0 || len(firstName) == 0 || len(lastName) == 0 {
+		return "`/characters add` command failed! Please input your world, first name, and last name."
+	}
+	lodestoneID := 0
+	if option, ok := optionMap["lodestone"]; ok {
+		var err error
+		lodestoneID, err = lodestone.ParseCharacterID(option.StringValue())
+		if err != nil {
+			return err.Error()
+		}
+	}
+
+	char, ok := c.LinkCharacter(s, i, g, world, firstName, lastName, lodestoneID)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("Linked `%s (%s)` to you. Run `/clears` to update your roles.", char.Name(), char.World)
+}
+
+func (c *Clearingway) removeCharacter(
+	g *Guild,
+	discordId string,
+	optionMap map[string]*discordgo.ApplicationCommandInteractionDataOption,
+) string {
+	char := linkedCharacter(g, discordId, optionMap)
+	if char == nil {
+		return "That is not one of your characters! Use `/characters list` to see them."
+	}
+
+	g.Characters.Unlink(char)
+	g.Ownership.Forget(&OwnershipClaim{Character: char, DiscordId: discordId, Region: g.LodestoneRegionFor(char)})
+	c.Audit(g, "<@%s> unlinked `%s (%s)` (Lodestone ID %d).", discordId, char.Name(), char.World, char.LodestoneID)
+
+	message := fmt.Sprintf("Unlinked `%s (%s)` from you.", char.Name(), char.World)
+	if chars := g.Characters.ForDiscordId(discordId); len(chars) != 0 {
+		message += fmt.Sprintf(
+			" Your primary character is `%s (%s)`. Run `/clears` to update your roles.",
+			chars[0].Name(),
+			chars[0].World,
+		)
+	}
+	return message
+}
+
+func (c *Clearingway) setPrimaryCharacter(
+	g *Guild,
+	discordId string,
+	optionMap map[string]*discordgo.ApplicationCommandInteractionDataOption,
+) string {
+	char := linkedCharacter(g, discordId, optionMap)
+	if char == nil {
+		return "That is not one of your characters! Use `/characters list` to see them."
+	}
+
+	g.Characters.SetPrimary(char)
+	return fmt.Sprintf("`%s (%s)` is now your primary character.", char.Name(), char.World)
+}
+
+// linkedCharacter returns the member's character chosen in the character
+// option, whose value is its Lodestone ID.
+func linkedCharacter(
+	g *Guild,
+	discordId string,
+	optionMap map[string]*discordgo.ApplicationCommandInteractionDataOption,
+) *ffxiv.Character {
+	option, ok := optionMap["character"]
+	if !ok {
+		return nil
+	}
+	for _, char := range g.Characters.ForDiscordId(discordId) {
+		if strconv.Itoa(char.LodestoneID) == option.StringValue() {
+			return char
+		}
+	}
+	return nil
+}
+
+// CharactersAutocomplete completes worlds for /characters add and the
+// member's own characters for the other subcommands.
+func (c *Clearingway) CharactersAutocomplete(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	g, ok := c.Guilds.Guilds[i.GuildID]
+	if !ok {
+		fmt.Printf("Interaction received from guild %s with no configuration!\n", i.GuildID)
+		return
+	}
+	options := i.ApplicationCommandData().Options
+	if len(options) == 0 {
+		return
+	}
+	if options[0].Name == "add" {
+		c.Autocomplete(s, i)
+		return
+	}
+
+	var search string
+	for _, opt := range options[0].Options {
+		if opt.Name == "character" {
+			search = strings.ToLower(opt.StringValue())
+		}
+	}
+
+	choices := []*discordgo.ApplicationCommandOptionChoice{}
+	for _, char := range g.Characters.ForDiscordId(i.Member.User.ID) {
+		name := fmt.Sprintf("%s (%s)", char.Name(), char.World)
+		if !strings.Contains(strings.ToLower(name), search) {
+			continue
+		}
+		// Discord shows as many choices as it does select menu options.
+		if len(choices) == MaxSelectOptions {
+			break
+		}
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+			Name:  name,
+			Value: strconv.Itoa(char.LodestoneID),
+		})
+	}
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+		Data: &discordgo.InteractionResponseData{
+			Choices: choices,
+		},
+	})
+	if err != nil {
+		fmt.Printf("Could not send Discord autocompletions: %+v\n", err)
+	}
+}
@@ -0,0 +1,266 @@
+package clearingway
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Veraticus/clearingway/internal/fflogs"
+)
+
+var clearWindowLayouts = []string{time.RFC3339, "2006-01-02"}
+
+// ClearWindow is a span of time in which clearing an encounter earns a role,
+// like "during week one" or "during patch 7.1". The window either has fixed
+// start and end dates or ends a number of days after the first kill of the
+// encounter was recorded on FF Logs.
+type ClearWindow struct {
+	Name               string
+	Start              time.Time
+	End                time.Time
+	DaysAfterFirstKill int
+	Role               *Role
+
+	mu sync.RWMutex
+}
+
+func (w *ClearWindow) Init(c *ConfigClearWindow, e *Encounter) error {
+	w.Name = c.Name
+	w.DaysAfterFirstKill = c.DaysAfterFirstKill
+
+	if len(w.Name) == 0 {
+		return fmt.Errorf("Clear windows must have a name")
+	}
+
+	var err error
+	if len(c.Start) != 0 {
+		w.Start, err = parseClearWindowTime(c.Start)
+		if err != nil {
+			return err
+		}
+	}
+	if len(c.End) != 0 {
+		if w.DaysAfterFirstKill != 0 {
+			return fmt.Errorf("Specify either an end or daysAfterFirstKill, not both")
+		}
+		w.End, err = parseClearWindowTime(c.End)
+		if err != nil {
+			return err
+		}
+	}
+	if w.Start.IsZero() && w.End.IsZero() && w.DaysAfterFirstKill == 0 {
+		return fmt.Errorf("Specify a start, an end, or daysAfterFirstKill")
+	}
+	if !w.Start.IsZero() && !w.End.IsZero() && !w.End.After(w.Start) {
+		return fmt.Errorf("End %v must be after start %v", c.End, c.Start)
+	}
+
+	w.Role = &Role{
+		Name:      e.Name + "-" + w.Name,
+		Color:     0x11806a,
+		Type:      WindowRole,
+		Encounter: e,
+	}
+	applyConfigRole(w.Role, c.ConfigRole)
+	if len(w.Role.Description) == 0 {
+		w.Role.Description = fmt.Sprintf("Cleared %s %s.", e.Name, w.Describe())
+	}
+
+	w.Role.ShouldApply = func(opts *ShouldApplyOpts) (bool, string) {
+		if w.WaitingForFirstKill() {
+			return false, fmt.Sprintf("The first kill of %v has not been recorded on FF Logs yet.", e.Name)
+		}
+
+		var earliestRank *fflogs.Rank
+		for _, id := range e.Ids {
+			ranking, ok := opts.Rankings.Rankings[id]
+			if !ok {
+				continue
+			}
+			for _, rank := range ranking.RanksByTime() {
+				if !w.Contains(rank.Time()) {
+					continue
+				}
+				if earliestRank == nil || rank.StartTime < earliestRank.StartTime {
+					earliestRank = rank
+				}
+				break
+			}
+		}
+
+		if earliestRank == nil {
+			return false, fmt.Sprintf("Did not clear %v %v.", e.Name, w.Describe())
+		}
+
+		return true, fmt.Sprintf(
+			"Cleared `%v` %v:\n     `%v` on <t:%v:F> (%v).",
+			e.Name,
+			w.Describe(),
+			earliestRank.Job.Abbreviation,
+			earliestRank.UnixTime(),
+			earliestRank.Report.Url(),
+		)
+	}
+
+	return nil
+}
+
+func parseClearWindowTime(s string) (time.Time, error) {
+	for _, layout := range clearWindowLayouts {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Could not parse %v as a date; use YYYY-MM-DD or RFC 3339", s)
+}
+
+// WaitingForFirstKill is true if the window ends relative to the first kill
+// and that kill has not been looked up yet.
+func (w *ClearWindow) WaitingForFirstKill() bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return w.DaysAfterFirstKill != 0 && w.End.IsZero()
+}
+
+// SetFirstKill ends the window the configured number of days after the
+// given first kill.
+func (w *ClearWindow) SetFirstKill(firstKill time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.End = firstKill.Add(time.Duration(w.DaysAfterFirstKill) * 24 * time.Hour)
+}
+
+// Contains reports whether t falls in the window. The start is inclusive and
+// the end exclusive.
+func (w *ClearWindow) Contains(t time.Time) bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if !w.Start.IsZero() && t.Before(w.Start) {
+		return false
+	}
+	if !w.End.IsZero() && !t.Before(w.End) {
+		return false
+	}
+	return true
+}
+
+func (w *ClearWindow) Describe() string {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.DaysAfterFirstKill != 0 && w.End.IsZero() {
+		return fmt.Sprintf("within %d days of the first kill", w.DaysAfterFirstKill)
+	}
+	if w.DaysAfterFirstKill != 0 {
+		return fmt.Sprintf("within %d days of the first kill (before <t:%d:D>)", w.DaysAfterFirstKill, w.End.Unix())
+	}
+	if w.Start.IsZero() {
+		return fmt.Sprintf("before <t:%d:D>", w.End.Unix())
+	}
+	if w.End.IsZero() {
+		return fmt.Sprintf("after <t:%d:D>", w.Start.Unix())
+	}
+	return fmt.Sprintf("between <t:%d:D> and <t:%d:D>", w.Start.Unix(), w.End.Unix())
+}
+
+// FirstKillRetry is how long to wait before looking up the first kill of an
+// encounter again when FF Logs did not have one.
+var FirstKillRetry = 10 * time.Minute
+
+// FirstKills are the first kills of a guild's encounters, looked up on FF
+// Logs once for every clear window that ends relative to them. Lookups that
+// fail are not retried until FirstKillRetry has passed. Once restored from a
+// store, first kills are saved to it when they are found.
+type FirstKills struct {
+	mu         sync.Mutex
+	kills      map[string]time.Time
+	retryAfter map[string]time.Time
+
+	store *Store
+	key   string
+}
+
+func NewFirstKills() *FirstKills {
+	return &FirstKills{kills: map[string]time.Time{}, retryAfter: map[string]time.Time{}}
+}
+
+// Restore loads the first kills saved in the store and saves them there from
+// now on.
+func (fk *FirstKills) Restore(store *Store, key string) error {
+	kills := map[string]time.Time{}
+	err := store.Load(key, &kills)
+	if err != nil {
+		return err
+	}
+
+	fk.mu.Lock()
+	defer fk.mu.Unlock()
+
+	fk.store = store
+	fk.key = key
+	for name, kill := range kills {
+		fk.kills[name] = kill
+	}
+	return nil
+}
+
+// Get returns the encounter's first kill, looking it up with lookup if it is
+// not known yet and was not looked up too recently.
+func (fk *FirstKills) Get(e *Encounter, lookup func() (time.Time, error)) (time.Time, error) {
+	fk.mu.Lock()
+	defer fk.mu.Unlock()
+
+	if kill, ok := fk.kills[e.Name]; ok {
+		return kill, nil
+	}
+	if retryAfter, ok := fk.retryAfter[e.Name]; ok && time.Now().Before(retryAfter) {
+		return time.Time{}, fmt.Errorf("No first kill for %s until <t:%d:t>", e.Name, retryAfter.Unix())
+	}
+
+	kill, err := lookup()
+	if err != nil {
+		fk.retryAfter[e.Name] = time.Now().Add(FirstKillRetry)
+		return time.Time{}, err
+	}
+	delete(fk.retryAfter, e.Name)
+	fk.kills[e.Name] = kill
+	if fk.store != nil {
+		fk.store.Save(fk.key, fk.kills)
+	}
+	return kill, nil
+}
+
+// ResolveClearWindows ends any clear windows in the guild that end relative
+// to the first kill of their encounter, once it is known.
+func (c *Clearingway) ResolveClearWindows(g *Guild) {
+	for _, e := range g.Encounters.Encounters {
+		waiting := []*ClearWindow{}
+		for _, w := range e.ClearWindows {
+			if w.WaitingForFirstKill() {
+				waiting = append(waiting, w)
+			}
+		}
+		if len(waiting) == 0 {
+			continue
+		}
+
+		firstKill, err := g.FirstKills.Get(e, func() (time.Time, error) {
+			firstKill, err := c.Fflogs.GetFirstKillTime(e.Ids, e.DifficultyInt())
+			if err == nil {
+				fmt.Printf("First kill for %s was at %v.\n", e.Name, firstKill)
+			}
+			return firstKill, err
+		})
+		if err != nil {
+			fmt.Printf("Could not find first kill for %s: %v\n", e.Name, err)
+			continue
+		}
+		for _, w := range waiting {
+			w.SetFirstKill(firstKill)
+		}
+	}
+}
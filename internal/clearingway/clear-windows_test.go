@@ -0,0 +1,119 @@
+package clearingway
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Veraticus/clearingway/internal/fflogs"
+	"github.com/Veraticus/clearingway/internal/ffxiv"
+	"github.com/stretchr/testify/assert"
+)
+
+func day(d int) time.Time {
+	return time.Date(2024, 1, d, 12, 0, 0, 0, time.UTC)
+}
+
+func windowRank(code string, t time.Time) *fflogs.Rank {
+	return &fflogs.Rank{StartTime: int(t.UnixMilli()), Job: ffxiv.Jobs["Dancer"], Report: fflogs.Report{Code: code, FightId: 1}}
+}
+
+func TestClearWindowContains(t *testing.T) {
+	e := &Encounter{Name: "P12S", Ids: []int{1}}
+	w := &ClearWindow{}
+	assert.NoError(t, w.Init(&ConfigClearWindow{Name: "Week 1", Start: "2024-01-02", End: "2024-01-09"}, e))
+
+	// The start is inclusive and the end exclusive.
+	assert.False(t, w.Contains(day(1)))
+	assert.True(t, w.Contains(time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)))
+	assert.True(t, w.Contains(day(8)))
+	assert.False(t, w.Contains(time.Date(2024, 1, 9, 0, 0, 0, 0, time.UTC)))
+
+	after := &ClearWindow{}
+	assert.NoError(t, after.Init(&ConfigClearWindow{Name: "Day 3", DaysAfterFirstKill: 3}, e))
+	assert.True(t, after.WaitingForFirstKill())
+	after.SetFirstKill(day(1))
+	assert.False(t, after.WaitingForFirstKill())
+	assert.True(t, after.Contains(day(3)))
+	assert.False(t, after.Contains(day(4)))
+}
+
+func TestClearWindowShouldApply(t *testing.T) {
+	e := &Encounter{Name: "P12S", Ids: []int{1, 2}}
+	rankings := &fflogs.Rankings{Rankings: map[int]*fflogs.Ranking{
+		1: {Ranks: []*fflogs.Rank{windowRank("late", day(5)), windowRank("early", day(1))}},
+		2: {Ranks: []*fflogs.Rank{windowRank("earliest", day(3))}},
+	}}
+	opts := &ShouldApplyOpts{Rankings: rankings}
+
+	// The earliest kill in the window is cited, across all of the
+	// encounter's IDs.
+	w := &ClearWindow{}
+	assert.NoError(t, w.Init(&ConfigClearWindow{Name: "Week 1", Start: "2024-01-02", End: "2024-01-09"}, e))
+	ok, message := w.Role.ShouldApply(opts)
+	assert.True(t, ok)
+	assert.Contains(t, message, fmt.Sprintf("`DNC` on <t:%d:F>", day(3).Unix()))
+	assert.Contains(t, message, "reports/earliest#fight=1")
+
+	ok, _ = w.Role.ShouldApply(&ShouldApplyOpts{Rankings: &fflogs.Rankings{Rankings: map[int]*fflogs.Ranking{
+		1: {Ranks: []*fflogs.Rank{windowRank("early", day(1))}},
+	}}})
+	assert.False(t, ok)
+
+	// Windows after the first kill apply nothing until it is known.
+	after := &ClearWindow{}
+	assert.NoError(t, after.Init(&ConfigClearWindow{Name: "Day 2", DaysAfterFirstKill: 2}, e))
+	ok, message = after.Role.ShouldApply(opts)
+	assert.False(t, ok)
+	assert.Equal(t, "The first kill of P12S has not been recorded on FF Logs yet.", message)
+
+	after.SetFirstKill(day(1))
+	ok, message = after.Role.ShouldApply(opts)
+	assert.True(t, ok)
+	assert.Contains(t, message, "reports/early#fight=1")
+}
+
+func TestFirstKills(t *testing.T) {
+	e := &Encounter{Name: "P12S"}
+	path := filepath.Join(t.TempDir(), "state.json")
+	store, err := OpenStore(path)
+	assert.NoError(t, err)
+	fk := NewFirstKills()
+	assert.NoError(t, fk.Restore(store, "guild/firstKills"))
+
+	// A failed lookup is not retried until FirstKillRetry has passed.
+	lookups := 0
+	_, err = fk.Get(e, func() (time.Time, error) {
+		lookups++
+		return time.Time{}, errors.New("No kills recorded")
+	})
+	assert.Error(t, err)
+	_, err = fk.Get(e, func() (time.Time, error) {
+		lookups++
+		return day(1), nil
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, lookups)
+
+	fk.retryAfter[e.Name] = time.Now().Add(-time.Minute)
+	kill, err := fk.Get(e, func() (time.Time, error) {
+		lookups++
+		return day(1), nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, day(1), kill)
+	assert.Equal(t, 2, lookups)
+
+	// After a restart the first kill is known without looking it up.
+	store, err = OpenStore(path)
+	assert.NoError(t, err)
+	restored := NewFirstKills()
+	assert.NoError(t, restored.Restore(store, "guild/firstKills"))
+	kill, err = restored.Get(e, func() (time.Time, error) {
+		return time.Time{}, errors.New("Looked up again")
+	})
+	assert.NoError(t, err)
+	assert.True(t, day(1).Equal(kill))
+}
@@ -0,0 +1,62 @@
+package clearingway
+
+import (
+	"fmt"
+
+	"github.com/Veraticus/clearingway/internal/fflogs"
+)
+
+// ClearedProgRoles works out which prog roles a member no longer needs
+// because they cleared the encounter: its Cleared role is about to apply, or,
+// for ultimates that only have built-in prog roles, FF Logs shows a clear.
+// If the guild replaces prog with reclears, the encounter's Reclear role is
+// added for members who held one of its prog roles.
+func (g *Guild) ClearedProgRoles(
+	memberRoleIds []string,
+	rolesToApply []*pendingRole,
+	rankings *fflogs.Rankings,
+) ([]*pendingRole, []*pendingRole) {
+	progToApply := []*pendingRole{}
+	progToRemove := []*pendingRole{}
+	if !g.ClearProgEnabled {
+		return progToApply, progToRemove
+	}
+
+	for _, e := range g.ProgEncounters() {
+		cleared := false
+		if clearedRole, ok := e.Roles[ClearedRole]; ok {
+			for _, p := range rolesToApply {
+				if p.role == clearedRole {
+					cleared = true
+				}
+			}
+		} else {
+			cleared = e.FirstClear(rankings) != nil
+		}
+		if !cleared {
+			continue
+		}
+
+		heldProg := false
+		for _, role := range e.ProgRoles.Roles {
+			if role.Skip || !role.PresentInRoles(memberRoleIds) {
+				continue
+			}
+			heldProg = true
+			progToRemove = append(progToRemove, &pendingRole{
+				role:    role,
+				message: fmt.Sprintf("Cleared `%v`, so no longer in prog.", e.Name),
+			})
+		}
+
+		reclearRole, ok := e.Roles[ReclearRole]
+		if g.ClearProgReclear && heldProg && ok {
+			progToApply = append(progToApply, &pendingRole{
+				role:    reclearRole,
+				message: fmt.Sprintf("Cleared `%v` after progging it.", e.Name),
+			})
+		}
+	}
+
+	return progToApply, progToRemove
+}
@@ -1,11 +1,17 @@
 package clearingway
 
 import (
+	"fmt"
+	"sync"
+	"time"
+
 	"github.com/Veraticus/clearingway/internal/discord"
 	"github.com/Veraticus/clearingway/internal/fflogs"
 	"github.com/Veraticus/clearingway/internal/ffxiv"
+	"github.com/Veraticus/clearingway/internal/lodestone"
 
 	trie "github.com/Vivino/go-autocomplete-trie"
+	"github.com/bwmarrin/discordgo"
 )
 
 type Clearingway struct {
@@ -13,24 +19,88 @@ type Clearingway struct {
 	Discord *discord.Discord
 	Guilds  *Guilds
 	Fflogs  *fflogs.Fflogs
+	Store   *Store
 	Ready   bool
 
 	AllWorlds        []string
 	AutoCompleteTrie *trie.Trie
+
+	// progWatches are the reports being watched, keyed by guild and report.
+	progWatches sync.Map
 }
 
-func (c *Clearingway) Init() {
+func (c *Clearingway) Init() error {
 	c.AllWorlds = ffxiv.AllWorlds()
 	c.AutoCompleteTrie = trie.New()
 	for _, world := range c.AllWorlds {
 		c.AutoCompleteTrie.Insert(world)
 	}
 
+	stateFile := c.Config.StateFile
+	if len(stateFile) == 0 {
+		stateFile = DefaultStateFile
+	}
+	store, err := OpenStore(stateFile)
+	if err != nil {
+		return err
+	}
+	c.Store = store
+
 	c.Guilds = &Guilds{Guilds: map[string]*Guild{}}
 
 	for _, configGuild := range c.Config.ConfigGuilds {
 		guild := &Guild{}
-		guild.Init(configGuild)
+		err := guild.Init(configGuild)
+		if err != nil {
+			return fmt.Errorf("Invalid configuration for guild %s: %w", configGuild.Name, err)
+		}
+		err = guild.InitPrerequisites()
+		if err != nil {
+			return fmt.Errorf("Invalid roles in guild %s: %w", guild.Name, err)
+		}
+		err = guild.RestoreState(c.Store)
+		if err != nil {
+			return fmt.Errorf("Could not restore state of guild %s: %w", guild.Name, err)
+		}
 		c.Guilds.Guilds[guild.Id] = guild
 	}
+
+	return nil
+}
+
+// StartSweeps sweeps every guild at the given interval, forever: expired prog
+// roles first, then free company roles. Each sweep also logs how the
+// Lodestone has been treating Clearingway.
+func (c *Clearingway) StartSweeps(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for _, g := range c.Guilds.Guilds {
+			c.SweepProgExpiry(g)
+			c.SweepFreeCompanies(g)
+		}
+		fmt.Printf("Lodestone client: %s\n", lodestone.DefaultClient.Metrics())
+		<-ticker.C
+	}
+}
+
+// GuildMembers lists every member of the guild, which needs the Server
+// Members intent.
+func (c *Clearingway) GuildMembers(g *Guild) ([]*discordgo.Member, error) {
+	members := []*discordgo.Member{}
+	after := ""
+	for {
+		page, err := c.Discord.Session.GuildMembers(g.Id, after, 1000)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, page...)
+		if len(page) < 1000 {
+			break
+		}
+		after = page[len(page)-1].User.ID
+	}
+
+	return members, nil
 }
@@ -1,7 +1,10 @@
 package clearingway
 
 import (
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/Veraticus/clearingway/internal/discord"
@@ -52,11 +55,25 @@ func (c *Clearingway) Clears(s *discordgo.Session, i *discordgo.InteractionCreat
 	if option, ok := optionMap["last-name"]; ok {
 		lastName = option.StringValue()
 	}
+	lodestoneID := 0
+	if option, ok := optionMap["lodestone"]; ok {
+		lodestoneID, err = lodestone.ParseCharacterID(option.StringValue())
+		if err != nil {
+			err = discord.ContinueInteraction(s, i.Interaction, err.Error())
+			if err != nil {
+				fmt.Printf("Error sending Discord message: %v\n", err)
+			}
+			return
+		}
+	}
 
-	c.ClearsHelper(s, i, g, world, firstName, lastName)
+	c.ClearsHelper(s, i, g, world, firstName, lastName, lodestoneID)
 }
 
-func (c *Clearingway) ClearsHelper(s *discordgo.Session, i *discordgo.InteractionCreate, g *Guild, world string, firstName string, lastName string) {
+// ClearsHelper verifies the member owns the character and gives them roles
+// for it. A Lodestone ID the member gave is used instead of searching for the
+// character.
+func (c *Clearingway) ClearsHelper(s *discordgo.Session, i *discordgo.InteractionCreate, g *Guild, world string, firstName string, lastName string, lodestoneID int) {
 	if len(world) == 0 || len(firstName) == 0 || len(lastName) == 0 {
 		err := discord.ContinueInteraction(s, i.Interaction, "`/clears` command failed! Please input your world, first name, and last name.")
 		if err != nil {
@@ -65,6 +82,73 @@ func (c *Clearingway) ClearsHelper(s *discordgo.Session, i *discordgo.Interactio
 		return
 	}
 
+	char, ok := c.LinkCharacter(s, i, g, world, firstName, lastName, lodestoneID)
+	if !ok {
+		return
+	}
+
+	err := discord.ContinueInteraction(s, i.Interaction,
+		fmt.Sprintf("Analyzing logs for `%s (%s)`...", char.Name(), char.World),
+	)
+	if err != nil {
+		fmt.Printf("Error sending Discord message: %v\n", err)
+	}
+
+	if char.UpdatedRecently() {
+		err = discord.ContinueInteraction(s, i.Interaction,
+			fmt.Sprintf("Finished analysis for `%s (%s)`.", char.Name(), char.World),
+		)
+		if err != nil {
+			fmt.Printf("Error sending Discord message: %v\n", err)
+		}
+		return
+	}
+
+	roleTexts, err := c.UpdateClearsForCharacterInGuild(char, i.Member.User.ID, g)
+	if err != nil {
+		err = discord.ContinueInteraction(s, i.Interaction,
+			fmt.Sprintf("Could not analyze clears for `%s (%s)`: %s", char.Name(), char.World, err),
+		)
+		if err != nil {
+			fmt.Printf("Error sending Discord message: %v\n", err)
+		}
+		return
+	}
+
+	chunks := discord.NewChunks()
+	chunks.Write(fmt.Sprintf("Finished analysis for `%s (%s)`.\n\n", char.Name(), char.World))
+	if linked := g.Characters.ForDiscordId(i.Member.User.ID); len(linked) > 1 {
+		chunks.Write(fmt.Sprintf("Your roles count clears from all your characters: %s.\n\n", characterNames(linked)))
+	}
+
+	for _, roleText := range roleTexts {
+		chunks.Write(roleText + "\n")
+	}
+
+	for _, c := range chunks.Chunks {
+		err = discord.ContinueInteraction(s, i.Interaction, "_ _\n"+c.String())
+		if err != nil {
+			fmt.Printf("Error sending Discord message: %v\n", err)
+		}
+	}
+
+	// The portrait is a nicety, so a Lodestone hiccup here is only logged.
+	region := g.LodestoneRegionFor(char)
+	profile, err := lodestone.CachedCharacterProfile(char, region)
+	if err != nil {
+		fmt.Printf("Could not get Lodestone profile for %s (%s): %v\n", char.Name(), char.World, err)
+		return
+	}
+	err = discord.ContinueInteractionWithEmbeds(s, i.Interaction, []*discordgo.MessageEmbed{CharacterEmbed(char, profile, region)})
+	if err != nil {
+		fmt.Printf("Error sending Discord message: %v\n", err)
+	}
+}
+
+// LinkCharacter finds the character in the Lodestone, verifies the member
+// owns it and links it to them, telling them how it went. It returns false if
+// the character could not be linked.
+func (c *Clearingway) LinkCharacter(s *discordgo.Session, i *discordgo.InteractionCreate, g *Guild, world string, firstName string, lastName string, lodestoneID int) (*ffxiv.Character, bool) {
 	title := cases.Title(language.AmericanEnglish)
 	world = title.String(cleanWorld(world))
 	firstName = title.String(firstName)
@@ -77,7 +161,7 @@ func (c *Clearingway) ClearsHelper(s *discordgo.Session, i *discordgo.Interactio
 		if err != nil {
 			fmt.Printf("Error sending Discord message: %v\n", err)
 		}
-		return
+		return nil, false
 	}
 
 	err := discord.ContinueInteraction(s, i.Interaction,
@@ -85,7 +169,7 @@ func (c *Clearingway) ClearsHelper(s *discordgo.Session, i *discordgo.Interactio
 	)
 	if err != nil {
 		fmt.Printf("Error sending Discord message: %v\n", err)
-		return
+		return nil, false
 	}
 
 	char, err := g.Characters.Init(world, firstName, lastName)
@@ -94,10 +178,21 @@ func (c *Clearingway) ClearsHelper(s *discordgo.Session, i *discordgo.Interactio
 		if err != nil {
 			fmt.Printf("Error sending Discord message: %v\n", err)
 		}
-		return
+		return nil, false
 	}
 
-	err = c.Fflogs.SetCharacterLodestoneID(char)
+	if lodestoneID != 0 {
+		err = useLodestoneID(char, lodestoneID, g.LodestoneRegionFor(char))
+		if err != nil {
+			err = discord.ContinueInteraction(s, i.Interaction, err.Error())
+			if err != nil {
+				fmt.Printf("Error sending Discord message: %v\n", err)
+			}
+			return nil, false
+		}
+	} else {
+		err = c.Fflogs.SetCharacterLodestoneID(char)
+	}
 	if err != nil {
 		err := discord.ContinueInteraction(s, i.Interaction,
 			fmt.Sprintf(
@@ -106,22 +201,35 @@ func (c *Clearingway) ClearsHelper(s *discordgo.Session, i *discordgo.Interactio
 			))
 		if err != nil {
 			fmt.Printf("Error sending Discord message: %v\n", err)
-			return
+			return nil, false
+		}
+		err = lodestone.SetCharacterLodestoneID(char, g.LodestoneRegionFor(char))
+		var ambiguous *lodestone.AmbiguousError
+		if errors.As(err, &ambiguous) {
+			err = SendLodestoneCandidates(s, i.Interaction, g, char, ambiguous)
+			if err != nil {
+				fmt.Printf("Error sending Discord message: %v\n", err)
+			}
+			return nil, false
 		}
-		err = lodestone.SetCharacterLodestoneID(char)
 		if err != nil {
 			err := discord.ContinueInteraction(s, i.Interaction,
 				fmt.Sprintf(
-					"Error finding this character's Lodestone ID in the Lodestone: %v\nIf your character name is short or very common this can frequently fail. Please link your character in FF Logs to the Lodestone here: https://www.fflogs.com/lodestone/import",
+					"Error finding this character's Lodestone ID in the Lodestone: %v\nYou can run `/clears` again with your Lodestone profile URL in the `lodestone` option, or link your character in FF Logs to the Lodestone here: https://www.fflogs.com/lodestone/import",
 					err,
 				))
 			if err != nil {
 				fmt.Printf("Error sending Discord message: %v\n", err)
-				return
 			}
+			return nil, false
 		}
 	}
 
+	char, err = c.SyncCharacter(g, char)
+	if err != nil {
+		fmt.Printf("Could not sync %s (%s) with the Lodestone: %v\n", char.Name(), char.World, err)
+	}
+
 	err = discord.ContinueInteraction(s, i.Interaction,
 		fmt.Sprintf("Verifying ownership of `%s (%s)`...", char.Name(), char.World),
 	)
@@ -130,70 +238,24 @@ func (c *Clearingway) ClearsHelper(s *discordgo.Session, i *discordgo.Interactio
 	}
 
 	discordId := i.Member.User.ID
-	isOwner, err := lodestone.CharacterIsOwnedByDiscordUser(char, discordId)
+	isOwner, instructions, err := c.VerifyOwnership(g, char, discordId)
 	if err != nil {
 		err = discord.ContinueInteraction(s, i.Interaction, err.Error())
 		if err != nil {
 			fmt.Printf("Error sending Discord message: %v\n", err)
 		}
-		return
+		return nil, false
 	}
 	if !isOwner {
-		err = discord.ContinueInteraction(s, i.Interaction,
-			fmt.Sprintf(
-				"I could not verify your ownership of `%s (%s)`!\nIf this is your character, add the following code to your Lodestone profile and try again:\n\n**%s**\n\nYou can edit your Lodestone profile at https://na.finalfantasyxiv.com/lodestone/my/setting/profile/",
-				char.Name(),
-				char.World,
-				char.LodestoneSlug(discordId),
-			),
-		)
-		if err != nil {
-			fmt.Printf("Error sending Discord message: %v\n", err)
-		}
-		return
-	}
-
-	err = discord.ContinueInteraction(s, i.Interaction,
-		fmt.Sprintf("Analyzing logs for `%s (%s)`...", char.Name(), char.World),
-	)
-	if err != nil {
-		fmt.Printf("Error sending Discord message: %v\n", err)
-	}
-
-	if char.UpdatedRecently() {
-		err = discord.ContinueInteraction(s, i.Interaction,
-			fmt.Sprintf("Finished analysis for `%s (%s)`.", char.Name(), char.World),
-		)
+		err = SendOwnershipInstructions(s, i.Interaction, g, char, instructions)
 		if err != nil {
 			fmt.Printf("Error sending Discord message: %v\n", err)
 		}
-		return
-	}
-
-	roleTexts, err := c.UpdateClearsForCharacterInGuild(char, i.Member.User.ID, g)
-	if err != nil {
-		err = discord.ContinueInteraction(s, i.Interaction,
-			fmt.Sprintf("Could not analyze clears for `%s (%s)`: %s", char.Name(), char.World, err),
-		)
-		if err != nil {
-			fmt.Printf("Error sending Discord message: %v\n", err)
-		}
-		return
+		return nil, false
 	}
+	g.Link(char, discordId)
 
-	chunks := discord.NewChunks()
-	chunks.Write(fmt.Sprintf("Finished analysis for `%s (%s)`.\n\n", char.Name(), char.World))
-
-	for _, roleText := range roleTexts {
-		chunks.Write(roleText + "\n")
-	}
-
-	for _, c := range chunks.Chunks {
-		err = discord.ContinueInteraction(s, i.Interaction, "_ _\n"+c.String())
-		if err != nil {
-			fmt.Printf("Error sending Discord message: %v\n", err)
-		}
-	}
+	return char, true
 }
 
 func (c *Clearingway) UpdateClearsForCharacterInGuild(
@@ -203,14 +265,50 @@ func (c *Clearingway) UpdateClearsForCharacterInGuild(
 ) ([]string, error) {
 	rankingsToGet := []*fflogs.RankingToGet{}
 	for _, encounter := range guild.AllEncounters() {
-		rankingsToGet = append(rankingsToGet, &fflogs.RankingToGet{IDs: encounter.Ids, Difficulty: encounter.DifficultyInt()})
+		rankingsToGet = append(rankingsToGet, &fflogs.RankingToGet{
+			IDs:        encounter.Ids,
+			Difficulty: encounter.DifficultyInt(),
+			Partitions: encounter.Partitions,
+		})
 	}
-	rankings, err := c.Fflogs.GetRankingsForCharacter(rankingsToGet, char)
-	if err != nil {
-		return nil, fmt.Errorf("Error retrieving encounter rankings: %w", err)
+	// A member is judged on every character they have linked, so running
+	// this on an alt never takes away roles earned on their main.
+	chars := linkedCharacters(guild, char, discordUserId)
+	// Linked characters are looked up on FF Logs under their current name
+	// and world, so they are synced with the Lodestone first.
+	for i, linked := range chars {
+		synced, err := c.SyncCharacter(guild, linked)
+		if err != nil {
+			fmt.Printf("Could not sync %s (%s) with the Lodestone: %v\n", linked.Name(), linked.World, err)
+		}
+		if linked == char {
+			char = synced
+		}
+		chars[i] = synced
+	}
+	// Only the character asked about has to have logs. If another one's
+	// cannot be fetched, its clears are missing, so no role it could have
+	// earned is removed.
+	rankings := &fflogs.Rankings{Rankings: map[int]*fflogs.Ranking{}}
+	unfetched := []string{}
+	for _, linked := range chars {
+		linkedRankings, err := c.Fflogs.GetRankingsForCharacter(rankingsToGet, linked)
+		if err != nil {
+			if linked == char {
+				return nil, fmt.Errorf("Error retrieving encounter rankings for %s (%s): %w", linked.Name(), linked.World, err)
+			}
+			unfetched = append(unfetched, fmt.Sprintf(
+				"Could not get the logs of `%s (%s)`, so its clears were not counted and roles it could have earned were not removed: %s\n",
+				linked.Name(),
+				linked.World,
+				err,
+			))
+			continue
+		}
+		rankings.Merge(linkedRankings)
 	}
 
-	fmt.Printf("Found the following relevant rankings for %s (%s)...\n", char.Name(), char.World)
+	fmt.Printf("Found the following relevant rankings for %s...\n", characterNames(chars))
 	for _, e := range guild.Encounters.Encounters {
 		fmt.Printf("%s (%d):\n", e.Name, len(e.Ranks(rankings)))
 		for _, r := range e.Ranks(rankings) {
@@ -218,7 +316,7 @@ func (c *Clearingway) UpdateClearsForCharacterInGuild(
 		}
 	}
 
-	fmt.Printf("Found the following ultimate rankings for %s (%s)...\n", char.Name(), char.World)
+	fmt.Printf("Found the following ultimate rankings for %s...\n", characterNames(chars))
 	for _, e := range UltimateEncounters.Encounters {
 		fmt.Printf("%s (%d):\n", e.Name, len(e.Ranks(rankings)))
 		for _, r := range e.Ranks(rankings) {
@@ -231,10 +329,12 @@ func (c *Clearingway) UpdateClearsForCharacterInGuild(
 		return nil, fmt.Errorf("Could not retrieve roles for user: %w", err)
 	}
 
-	text := []string{}
+	text := unfetched
+
+	c.ResolveClearWindows(guild)
 
 	shouldApplyOpts := &ShouldApplyOpts{
-		Character: char,
+		Character: chars[0],
 		Rankings:  rankings,
 	}
 
@@ -275,23 +375,50 @@ func (c *Clearingway) UpdateClearsForCharacterInGuild(
 		}
 	}
 
-	// Add achievement roles when we have a performant way to do it
-	// fmt.Printf("Scraping completed achievements...\n")
-	// clearedAchievements, err := lodestone.GetAchievements(char)
-	// if err != nil {
-	// 	fmt.Printf("Please check to make sure your achievements page is not private.")
-	// } else {
-	// 	for _, role := range guild.AchievementRoles.Roles {
-	// 		for _, clearedAchievement := range clearedAchievements {
-	// 			if clearedAchievement == role.Description {
-	// 				message := fmt.Sprintf("Cleared achievement %s", clearedAchievement)
-	// 				text = append(text, fmt.Sprintf("__Adding role: **%s**__\n-> %s\n", role.Name, message))
-	// 				rolesToApply = append(rolesToApply, &pendingRole{role: role, message: message})
-	// 			}
-	// 		}
-	// 	}
-	// }
-	fmt.Printf("Scraping completed.\n")
+	// Achievements are scraped from the Lodestone, which only has to be
+	// walked as far as the newest achievement already seen.
+	if len(guild.AchievementRoles.Roles) != 0 {
+		fmt.Printf("Scraping completed achievements...\n")
+		clearedAchievements := []string{}
+		for _, linked := range chars {
+			region := guild.LodestoneRegionFor(linked)
+			achievements, err := lodestone.GetAchievements(linked, region)
+			if errors.Is(err, lodestone.ErrPrivate) {
+				text = append(text, fmt.Sprintf(
+					"The achievements of `%s (%s)` are private on the Lodestone, so achievement roles could not be checked for it. Make them public at %s and try again.\n",
+					linked.Name(),
+					linked.World,
+					region.AccountSettingsURL(),
+				))
+			} else if err != nil {
+				text = append(text, fmt.Sprintf("Could not check the achievements of `%s (%s)` on the Lodestone: %s\n", linked.Name(), linked.World, err))
+			} else {
+				clearedAchievements = append(clearedAchievements, achievements...)
+			}
+		}
+		rolesToApply = append(rolesToApply, guild.Achievements.PendingRoles(clearedAchievements)...)
+		fmt.Printf("Scraping completed.\n")
+	}
+
+	// Free company roles follow membership even if the guild otherwise skips
+	// removal, since keeping them in sync is the point.
+	freeCompanyToRemove := []*pendingRole{}
+	if len(guild.FreeCompanies.FreeCompanies) != 0 {
+		freeCompanyToApply, toRemove, err := guild.FreeCompanies.PendingRoles(chars, guild.FreeCompanyRegion(chars))
+		if err != nil {
+			text = append(text, fmt.Sprintf("Could not check your free company on the Lodestone: %s\n", err))
+		}
+		rolesToApply = append(rolesToApply, freeCompanyToApply...)
+		freeCompanyToRemove = toRemove
+	}
+
+	// Prog roles are cleaned up once the encounter is cleared even if the
+	// guild otherwise skips removal, since the guild asked for it.
+	progToApply, progToRemove := guild.ClearedProgRoles(member.Roles, rolesToApply, rankings)
+	rolesToApply = append(rolesToApply, progToApply...)
+
+	rolesToApply, rolesToRemove = guild.EnforcePrerequisites(member.Roles, rolesToApply, rolesToRemove)
+	rolesToRemove, milestoneToRemove := guild.PassedMilestoneRoles(member.Roles, rolesToApply, rolesToRemove)
 
 	for _, pendingRole := range rolesToApply {
 		role := pendingRole.role
@@ -309,6 +436,9 @@ func (c *Clearingway) UpdateClearsForCharacterInGuild(
 	if !guild.SkipRemoval {
 		for _, pendingRole := range rolesToRemove {
 			role := pendingRole.role
+			if len(unfetched) != 0 && guild.dependsOnLogs(role) {
+				continue
+			}
 			if !role.Skip {
 				if role.PresentInRoles(member.Roles) {
 					err := role.RemoveFromCharacter(guild.Id, discordUserId, c.Discord.Session)
@@ -321,7 +451,74 @@ func (c *Clearingway) UpdateClearsForCharacterInGuild(
 		}
 	}
 
-	char.LastUpdateTime = time.Now()
+	for _, pendingRole := range milestoneToRemove {
+		role := pendingRole.role
+		err := role.RemoveFromCharacter(guild.Id, discordUserId, c.Discord.Session)
+		if err != nil {
+			return nil, fmt.Errorf("Error removing Discord role +%v: %w", role, err)
+		}
+		text = append(text, fmt.Sprintf("__Removing role: **%s**__\n⮕ %s\n", role.Name, pendingRole.message))
+	}
+
+	for _, pendingRole := range freeCompanyToRemove {
+		role := pendingRole.role
+		if role.Skip || !role.PresentInRoles(member.Roles) {
+			continue
+		}
+		err := role.RemoveFromCharacter(guild.Id, discordUserId, c.Discord.Session)
+		if err != nil {
+			return nil, fmt.Errorf("Error removing Discord role +%v: %w", role, err)
+		}
+		text = append(text, fmt.Sprintf("__Removing role: **%s**__\n⮕ %s\n", role.Name, pendingRole.message))
+	}
+
+	for _, pendingRole := range progToRemove {
+		role := pendingRole.role
+		err := role.RemoveFromCharacter(guild.Id, discordUserId, c.Discord.Session)
+		if err != nil {
+			return nil, fmt.Errorf("Error removing Discord role +%v: %w", role, err)
+		}
+		guild.ProgGrants.Forget(discordUserId, role.Encounter)
+		text = append(text, fmt.Sprintf("__Removing role: **%s**__\n⮕ %s\n", role.Name, pendingRole.message))
+	}
+
+	for _, linked := range chars {
+		linked.LastUpdateTime = time.Now()
+	}
 
 	return text, nil
 }
+
+// dependsOnLogs reports whether the role is earned from FF Logs, directly or
+// through its prerequisites. Only datacenter roles are not.
+func (g *Guild) dependsOnLogs(role *Role) bool {
+	if !g.DatacenterEnabled || !slices.Contains(g.DatacenterRoles.Roles, role) {
+		return true
+	}
+	for _, prerequisite := range role.Prerequisites {
+		if g.dependsOnLogs(prerequisite) {
+			return true
+		}
+	}
+	return false
+}
+
+// linkedCharacters returns every character the member has linked, primary
+// first, or just the given character if they have not linked it yet.
+func linkedCharacters(guild *Guild, char *ffxiv.Character, discordUserId string) []*ffxiv.Character {
+	chars := guild.Characters.ForDiscordId(discordUserId)
+	for _, linked := range chars {
+		if linked == char {
+			return chars
+		}
+	}
+	return append(chars, char)
+}
+
+func characterNames(chars []*ffxiv.Character) string {
+	names := []string{}
+	for _, char := range chars {
+		names = append(names, fmt.Sprintf("%s (%s)", char.Name(), char.World))
+	}
+	return strings.Join(names, ", ")
+}
@@ -1,6 +1,7 @@
 package clearingway
 
 type Config struct {
+	StateFile    string         `yaml:"stateFile"`
 	ConfigGuilds []*ConfigGuild `yaml:"guilds"`
 }
 
@@ -8,13 +9,38 @@ type ConfigGuild struct {
 	Name                      string                      `yaml:"name"`
 	GuildId                   string                      `yaml:"guildId"`
 	ChannelId                 string                      `yaml:"channelId"`
+	LodestoneRegion           string                      `yaml:"lodestoneRegion"`
+	AuditChannelId            string                      `yaml:"auditChannelId"`
 	ConfigPhysicalDatacenters []*ConfigPhysicalDatacenter `yaml:"physicalDatacenters"`
 	ConfigEncounters          []*ConfigEncounter          `yaml:"encounters"`
+	ConfigTiers               []*ConfigTier               `yaml:"tiers"`
 	ConfigAchievements        []*ConfigAchievement        `yaml:"achievements"`
 	ConfigRoles               *ConfigRoles                `yaml:"roles"`
 	ConfigReconfigureRoles    []*ConfigReconfigureRoles   `yaml:"reconfigureRoles"`
 	ConfigMenus               []*ConfigMenu               `yaml:"menu"`
 	ConfigMenuOrder           []ConfigMenuOrder           `yaml:"menuOrder"`
+	ConfigUltimateProg        []*ConfigUltimateProg       `yaml:"ultimateProg"`
+	ConfigOwnership           *ConfigOwnership            `yaml:"ownership"`
+	ConfigFreeCompanies       []*ConfigFreeCompany        `yaml:"freeCompanies"`
+}
+
+// ConfigFreeCompany gives a role to members of a free company, identified by
+// its Lodestone ID.
+type ConfigFreeCompany struct {
+	Id         string      `yaml:"id"`
+	Name       string      `yaml:"name"`
+	ConfigRole *ConfigRole `yaml:"role"`
+}
+
+// ConfigOwnership chooses how members prove they own their characters. Any of
+// the strategies is enough; without any, members put a random code in their
+// Lodestone bio.
+type ConfigOwnership struct {
+	Strategies        []string `yaml:"strategies"`
+	NonceExpiry       string   `yaml:"nonceExpiry"`
+	Titles            []string `yaml:"titles"`
+	FreeCompanies     []string `yaml:"freeCompanies"`
+	ApprovalChannelId string   `yaml:"approvalChannelId"`
 }
 
 type ConfigRoles struct {
@@ -29,18 +55,64 @@ type ConfigRoles struct {
 	NameColor          bool `yaml:"nameColor"`
 	Reclear            bool `yaml:"reclear"`
 	Menu               bool `yaml:"menu"`
+	UltimateProg       bool `yaml:"ultimateProg"`
+	ClearProg          bool `yaml:"clearProg"`
+	ClearProgReclear   bool `yaml:"clearProgReclear"`
+	GroupProg          bool `yaml:"groupProg"`
 }
 
 type ConfigEncounter struct {
-	Ids                   []int         `yaml:"ids"`
-	Name                  string        `yaml:"name"`
-	Difficulty            string        `yaml:"difficulty"`
-	DefaultRoles          bool          `yaml:"defaultRoles"`
-	TotalWeaponsAvailable int           `yaml:"totalWeaponsAvailable"`
-	The                   string        `yaml:"the"`
-	ConfigRoles           []*ConfigRole `yaml:"roles"`
-	ConfigProg            []*ConfigRole `yaml:"prog"`
-	RequiredKillsToClear  int           `yaml:"requiredKillsToClear"`
+	Ids                   []int                  `yaml:"ids"`
+	Name                  string                 `yaml:"name"`
+	Difficulty            string                 `yaml:"difficulty"`
+	DefaultRoles          bool                   `yaml:"defaultRoles"`
+	TotalWeaponsAvailable int                    `yaml:"totalWeaponsAvailable"`
+	The                   string                 `yaml:"the"`
+	ConfigRoles           []*ConfigRole          `yaml:"roles"`
+	ConfigProg            []*ConfigProgRole      `yaml:"prog"`
+	RequiredKillsToClear  int                    `yaml:"requiredKillsToClear"`
+	ConfigClearWindows    []*ConfigClearWindow   `yaml:"clearWindows"`
+	Partitions            []int                  `yaml:"partitions"`
+	ConfigPartitionRoles  []*ConfigPartitionRole `yaml:"partitionRoles"`
+	ConfigMilestones      []*ConfigMilestone     `yaml:"milestones"`
+	ProgExpiry            string                 `yaml:"progExpiry"`
+	ProgExpiryDowngrade   bool                   `yaml:"progExpiryDowngrade"`
+}
+
+// ConfigUltimateProg overrides the built-in prog points for an ultimate. A
+// prog point with the same phase and boss percentage as a built-in one
+// replaces its name, color and so on; any other prog point is added.
+type ConfigUltimateProg struct {
+	Encounter           string            `yaml:"encounter"`
+	ConfigProg          []*ConfigProgRole `yaml:"prog"`
+	ProgExpiry          string            `yaml:"progExpiry"`
+	ProgExpiryDowngrade bool              `yaml:"progExpiryDowngrade"`
+}
+
+type ConfigMilestone struct {
+	Kills      int         `yaml:"kills"`
+	ConfigRole *ConfigRole `yaml:"role"`
+}
+
+type ConfigPartitionRole struct {
+	Name       string      `yaml:"name"`
+	Partitions []int       `yaml:"partitions"`
+	ConfigRole *ConfigRole `yaml:"role"`
+}
+
+type ConfigClearWindow struct {
+	Name               string      `yaml:"name"`
+	Start              string      `yaml:"start"`
+	End                string      `yaml:"end"`
+	DaysAfterFirstKill int         `yaml:"daysAfterFirstKill"`
+	ConfigRole         *ConfigRole `yaml:"role"`
+}
+
+type ConfigTier struct {
+	Name       string      `yaml:"name"`
+	Encounters []string    `yaml:"encounters"`
+	Required   int         `yaml:"required"`
+	ConfigRole *ConfigRole `yaml:"role"`
 }
 
 type ConfigAchievement struct {
@@ -49,12 +121,27 @@ type ConfigAchievement struct {
 }
 
 type ConfigRole struct {
-	Name        string `yaml:"name"`
-	Type        string `yaml:"type"`
-	Color       int    `yaml:"color"`
-	Hoist       bool   `yaml:"hoist"`
-	Mention     bool   `yaml:"mention"`
-	Description string `yaml:"description"`
+	Name        string   `yaml:"name"`
+	Type        string   `yaml:"type"`
+	Color       int      `yaml:"color"`
+	Hoist       bool     `yaml:"hoist"`
+	Mention     bool     `yaml:"mention"`
+	Description string   `yaml:"description"`
+	Requires    []string `yaml:"requires"`
+}
+
+// ConfigProgRole is a prog point: reaching a phase (counted from 1) with the
+// boss at or below a percentage of its HP. Without a phase, prog roles count
+// phases in the order they are listed; without a boss percentage, entering
+// the phase is enough. A phase name, a description of the mechanic the prog
+// point is at and an image can be shown alongside the role.
+type ConfigProgRole struct {
+	ConfigRole     `yaml:",inline"`
+	Phase          int     `yaml:"phase"`
+	BossPercentage float64 `yaml:"bossPercentage"`
+	PhaseName      string  `yaml:"phaseName"`
+	Mechanic       string  `yaml:"mechanic"`
+	Image          string  `yaml:"image"`
 }
 
 type ConfigPhysicalDatacenter struct {
@@ -70,14 +157,15 @@ type ConfigLogicalDatacenter struct {
 }
 
 type ConfigReconfigureRoles struct {
-	Type          string `yaml:"type"`
-	EncounterName string `yaml:"encounterName"`
-	From          string `yaml:"from"`
-	To            string `yaml:"to"`
-	Color         int    `yaml:"color"`
-	Skip          bool   `yaml:"skip"`
-	DontSkip      bool   `yaml:"dontSkip"`
-	Hoist         bool   `yaml:"hoist"`
+	Type          string   `yaml:"type"`
+	EncounterName string   `yaml:"encounterName"`
+	From          string   `yaml:"from"`
+	To            string   `yaml:"to"`
+	Color         int      `yaml:"color"`
+	Skip          bool     `yaml:"skip"`
+	DontSkip      bool     `yaml:"dontSkip"`
+	Hoist         bool     `yaml:"hoist"`
+	Requires      []string `yaml:"requires"`
 }
 
 type ConfigMenu struct {
@@ -112,4 +200,4 @@ type ConfigField struct {
 type ConfigMenuOrder struct {
 	Name  string   `yaml:"name"`
 	Menus []string `yaml:"menus"`
-}
\ No newline at end of file
+}
@@ -2,8 +2,10 @@ package clearingway
 
 import (
 	"fmt"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Veraticus/clearingway/internal/fflogs"
 )
@@ -17,6 +19,13 @@ var UltimateEncounters = &Encounters{
 			DefaultRoles:          false,
 			TotalWeaponsAvailable: 15,
 			The:                   "Legendary",
+			DefaultProg: []*ConfigProgRole{
+				{Phase: 1, PhaseName: "Twintania", ConfigRole: ConfigRole{Name: "UCoB P1 Prog (Twintania)"}},
+				{Phase: 2, PhaseName: "Nael deus Darnus", ConfigRole: ConfigRole{Name: "UCoB P2 Prog (Nael deus Darnus)"}},
+				{Phase: 3, PhaseName: "Bahamut Prime", ConfigRole: ConfigRole{Name: "UCoB P3 Prog (Bahamut Prime)"}},
+				{Phase: 4, PhaseName: "Triple Threat", ConfigRole: ConfigRole{Name: "UCoB P4 Prog (Triple Threat)"}},
+				{Phase: 5, PhaseName: "Golden Bahamut", ConfigRole: ConfigRole{Name: "UCoB P5 Prog (Golden Bahamut)"}},
+			},
 		},
 		{
 			Name:                  "The Weapon's Refrain (Ultimate)",
@@ -25,6 +34,12 @@ var UltimateEncounters = &Encounters{
 			DefaultRoles:          false,
 			TotalWeaponsAvailable: 15,
 			The:                   "Ultimate",
+			DefaultProg: []*ConfigProgRole{
+				{Phase: 1, PhaseName: "Garuda", ConfigRole: ConfigRole{Name: "UWU P1 Prog (Garuda)"}},
+				{Phase: 2, PhaseName: "Ifrit", ConfigRole: ConfigRole{Name: "UWU P2 Prog (Ifrit)"}},
+				{Phase: 3, PhaseName: "Titan", ConfigRole: ConfigRole{Name: "UWU P3 Prog (Titan)"}},
+				{Phase: 4, PhaseName: "The Ultima Weapon", ConfigRole: ConfigRole{Name: "UWU P4 Prog (The Ultima Weapon)"}},
+			},
 		},
 		{
 			Name:                  "The Epic of Alexander (Ultimate)",
@@ -33,6 +48,12 @@ var UltimateEncounters = &Encounters{
 			DefaultRoles:          false,
 			TotalWeaponsAvailable: 17,
 			The:                   "Perfect",
+			DefaultProg: []*ConfigProgRole{
+				{Phase: 1, PhaseName: "Living Liquid", ConfigRole: ConfigRole{Name: "TEA P1 Prog (Living Liquid)"}},
+				{Phase: 2, PhaseName: "Brute Justice and Cruise Chaser", ConfigRole: ConfigRole{Name: "TEA P2 Prog (Brute Justice and Cruise Chaser)"}},
+				{Phase: 3, PhaseName: "Alexander Prime", ConfigRole: ConfigRole{Name: "TEA P3 Prog (Alexander Prime)"}},
+				{Phase: 4, PhaseName: "Perfect Alexander", ConfigRole: ConfigRole{Name: "TEA P4 Prog (Perfect Alexander)"}},
+			},
 		},
 		{
 			Name:                  "Dragonsong's Reprise (Ultimate)",
@@ -41,6 +62,15 @@ var UltimateEncounters = &Encounters{
 			DefaultRoles:          false,
 			TotalWeaponsAvailable: 19,
 			The:                   "Heavenly",
+			DefaultProg: []*ConfigProgRole{
+				{Phase: 1, PhaseName: "Adelphel, Grinnaux and Charibert", ConfigRole: ConfigRole{Name: "DSR P1 Prog (Adelphel, Grinnaux and Charibert)"}},
+				{Phase: 2, PhaseName: "King Thordan", ConfigRole: ConfigRole{Name: "DSR P2 Prog (King Thordan)"}},
+				{Phase: 3, PhaseName: "Nidhogg", ConfigRole: ConfigRole{Name: "DSR P3 Prog (Nidhogg)"}},
+				{Phase: 4, PhaseName: "The Eyes", ConfigRole: ConfigRole{Name: "DSR P4 Prog (The Eyes)"}},
+				{Phase: 5, PhaseName: "Alternative End", ConfigRole: ConfigRole{Name: "DSR P5 Prog (Alternative End)"}},
+				{Phase: 6, PhaseName: "Nidhogg and Hraesvelgr", ConfigRole: ConfigRole{Name: "DSR P6 Prog (Nidhogg and Hraesvelgr)"}},
+				{Phase: 7, PhaseName: "Dragon-king Thordan", ConfigRole: ConfigRole{Name: "DSR P7 Prog (Dragon-king Thordan)"}},
+			},
 		},
 		{
 			Name:                  "The Omega Protocol (Ultimate)",
@@ -49,6 +79,14 @@ var UltimateEncounters = &Encounters{
 			DefaultRoles:          false,
 			TotalWeaponsAvailable: 19,
 			The:                   "Alpha",
+			DefaultProg: []*ConfigProgRole{
+				{Phase: 1, PhaseName: "Omega", ConfigRole: ConfigRole{Name: "TOP P1 Prog (Omega)"}},
+				{Phase: 2, PhaseName: "Omega-M and Omega-F", ConfigRole: ConfigRole{Name: "TOP P2 Prog (Omega-M and Omega-F)"}},
+				{Phase: 3, PhaseName: "Omega Reconfigured", ConfigRole: ConfigRole{Name: "TOP P3 Prog (Omega Reconfigured)"}},
+				{Phase: 4, PhaseName: "Blue Screen", ConfigRole: ConfigRole{Name: "TOP P4 Prog (Blue Screen)"}},
+				{Phase: 5, PhaseName: "Run: Dynamis", ConfigRole: ConfigRole{Name: "TOP P5 Prog (Run: Dynamis)"}},
+				{Phase: 6, PhaseName: "Alpha Omega", ConfigRole: ConfigRole{Name: "TOP P6 Prog (Alpha Omega)"}},
+			},
 		},
 		{
 			Name:                  "Futures Rewritten (Ultimate)",
@@ -57,6 +95,13 @@ var UltimateEncounters = &Encounters{
 			DefaultRoles:          false,
 			TotalWeaponsAvailable: 21,
 			The:                   "Roommate",
+			DefaultProg: []*ConfigProgRole{
+				{Phase: 1, PhaseName: "Fatebreaker", ConfigRole: ConfigRole{Name: "FRU P1 Prog (Fatebreaker)"}},
+				{Phase: 2, PhaseName: "Usurper of Frost", ConfigRole: ConfigRole{Name: "FRU P2 Prog (Usurper of Frost)"}},
+				{Phase: 3, PhaseName: "Oracle of Darkness", ConfigRole: ConfigRole{Name: "FRU P3 Prog (Oracle of Darkness)"}},
+				{Phase: 4, PhaseName: "Usurper and Oracle", ConfigRole: ConfigRole{Name: "FRU P4 Prog (Usurper and Oracle)"}},
+				{Phase: 5, PhaseName: "Pandora", ConfigRole: ConfigRole{Name: "FRU P5 Prog (Pandora)"}},
+			},
 		},
 	},
 }
@@ -75,15 +120,29 @@ type Encounter struct {
 	ProgRoles             *Roles
 	The                   string
 	RequiredKillsToClear  int
+	ClearWindows          []*ClearWindow
+	Partitions            []int
+	PartitionRoles        []*Role
+	MilestoneRoles        *Roles
+
+	// ProgExpiry is how long a fight counts as evidence for a prog role,
+	// or zero if prog roles never expire.
+	ProgExpiry          time.Duration
+	ProgExpiryDowngrade bool
+
+	// DefaultProg is the built-in prog points for an ultimate, one for each
+	// phase, which guilds can enable without configuring prog themselves.
+	DefaultProg []*ConfigProgRole
 }
 
-func (e *Encounter) Init(c *ConfigEncounter) {
+func (e *Encounter) Init(c *ConfigEncounter) error {
 	e.Ids = c.Ids
 	e.Name = c.Name
 	e.Difficulty = c.Difficulty
 	e.DefaultRoles = c.DefaultRoles
 	e.TotalWeaponsAvailable = c.TotalWeaponsAvailable
 	e.The = c.The
+	e.Partitions = c.Partitions
 	if c.RequiredKillsToClear == 0 {
 		e.RequiredKillsToClear = 1
 	} else {
@@ -136,9 +195,20 @@ func (e *Encounter) Init(c *ConfigEncounter) {
 		if configRole.Mention {
 			role.Mention = true
 		}
+		if len(configRole.Requires) != 0 {
+			role.Requires = configRole.Requires
+		}
 	}
 
 	for roleType, role := range e.Roles {
+		role.Encounter = e
+
+		// Reclears and name colors only make sense for people who have
+		// actually cleared the encounter.
+		if roleType == ReclearRole || roleType == ColorRole {
+			role.AddPrerequisite(e.Roles[ClearedRole])
+		}
+
 		if len(role.Description) != 0 {
 			continue
 		}
@@ -191,8 +261,46 @@ func (e *Encounter) Init(c *ConfigEncounter) {
 		return false, fmt.Sprintf("Has not cleared %v.", e.Name)
 	}
 
+	for _, configClearWindow := range c.ConfigClearWindows {
+		clearWindow := &ClearWindow{}
+		err := clearWindow.Init(configClearWindow, e)
+		if err != nil {
+			return fmt.Errorf("Invalid clear window %s for %s: %w", configClearWindow.Name, e.Name, err)
+		}
+		e.ClearWindows = append(e.ClearWindows, clearWindow)
+	}
+
+	for _, configPartitionRole := range c.ConfigPartitionRoles {
+		role, err := PartitionRoleForEncounter(configPartitionRole, e)
+		if err != nil {
+			return fmt.Errorf("Invalid partition role %s for %s: %w", configPartitionRole.Name, e.Name, err)
+		}
+		e.PartitionRoles = append(e.PartitionRoles, role)
+	}
+
+	if len(c.ConfigMilestones) != 0 {
+		milestoneRoles, err := MilestoneRoles(c.ConfigMilestones, e)
+		if err != nil {
+			return fmt.Errorf("Invalid milestones for %s: %w", e.Name, err)
+		}
+		e.MilestoneRoles = milestoneRoles
+	}
+
+	if len(c.ProgExpiry) != 0 {
+		progExpiry, err := parseProgExpiry(c.ProgExpiry)
+		if err != nil {
+			return fmt.Errorf("Invalid prog expiry for %s: %w", e.Name, err)
+		}
+		e.ProgExpiry = progExpiry
+	}
+	e.ProgExpiryDowngrade = c.ProgExpiryDowngrade
+
 	if c.ConfigProg != nil {
-		e.ProgRoles = ProgRoles(c.ConfigProg, e)
+		progRoles, err := ProgRoles(c.ConfigProg, e)
+		if err != nil {
+			return fmt.Errorf("Invalid prog roles for %s: %w", e.Name, err)
+		}
+		e.ProgRoles = progRoles
 	}
 
     // Add this logging at the end of the Init method:
@@ -205,8 +313,6 @@ func (e *Encounter) Init(c *ConfigEncounter) {
             fmt.Printf("    Config prog role [%d]: %s (color: %d)\n", i, progRole.Name, progRole.Color)
         }
         
-        e.ProgRoles = ProgRoles(c.ConfigProg, e)
-        
         fmt.Printf("  ProgRoles initialized with %d roles\n", len(e.ProgRoles.Roles))
         for i, role := range e.ProgRoles.Roles {
             fmt.Printf("    Initialized prog role [%d]: %s\n", i, role.Name)
@@ -214,6 +320,8 @@ func (e *Encounter) Init(c *ConfigEncounter) {
     } else {
         fmt.Printf("  No prog configuration found - ProgRoles will be nil\n")
     }
+
+	return nil
 }
 
 func (e *Encounter) DifficultyInt() int {
@@ -238,6 +346,13 @@ func (es *Encounters) Roles() *Roles {
 		if encounter.ProgRoles != nil {
 			roles.Roles = append(roles.Roles, encounter.ProgRoles.Roles...)
 		}
+		for _, clearWindow := range encounter.ClearWindows {
+			roles.Roles = append(roles.Roles, clearWindow.Role)
+		}
+		roles.Roles = append(roles.Roles, encounter.PartitionRoles...)
+		if encounter.MilestoneRoles != nil {
+			roles.Roles = append(roles.Roles, encounter.MilestoneRoles.Roles...)
+		}
 	}
 
 	return roles
@@ -334,6 +449,18 @@ func (es *Encounters) Names() []string {
 	return names
 }
 
+// ForIds returns the first encounter sharing an ID with ids.
+func (es *Encounters) ForIds(ids []int) *Encounter {
+	for _, e := range es.Encounters {
+		for _, id := range e.Ids {
+			if slices.Contains(ids, id) {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
 func (es *Encounters) ForName(name string) *Encounter {
 	for _, e := range es.Encounters {
 		if e.Name == name {
@@ -343,6 +470,45 @@ func (es *Encounters) ForName(name string) *Encounter {
 	return nil
 }
 
+// TotalKills adds up the kills of the encounter across all of its IDs.
+func (e *Encounter) TotalKills(rankings *fflogs.Rankings) int {
+	kills := 0
+	for _, id := range e.Ids {
+		ranking, ok := rankings.Rankings[id]
+		if !ok {
+			continue
+		}
+		kills = kills + ranking.TotalKills
+	}
+
+	return kills
+}
+
+// FirstClear returns the earliest kill of the encounter if it has been killed
+// at least RequiredKillsToClear times, or nil if it has not.
+func (e *Encounter) FirstClear(rankings *fflogs.Rankings) *fflogs.Rank {
+	var firstRank *fflogs.Rank
+	for _, id := range e.Ids {
+		ranking, ok := rankings.Rankings[id]
+		if !ok {
+			continue
+		}
+		if ranking.TotalKills < e.RequiredKillsToClear {
+			continue
+		}
+
+		ranks := ranking.RanksByTime()
+		if len(ranks) == 0 {
+			continue
+		}
+		if firstRank == nil || ranks[0].StartTime < firstRank.StartTime {
+			firstRank = ranks[0]
+		}
+	}
+
+	return firstRank
+}
+
 func (e *Encounter) Ranks(rankings *fflogs.Rankings) []*fflogs.Rank {
 	ranks := []*fflogs.Rank{}
 	for id, ranking := range rankings.Rankings {
@@ -0,0 +1,155 @@
+package clearingway
+
+import (
+	"fmt"
+
+	"github.com/Veraticus/clearingway/internal/ffxiv"
+	"github.com/Veraticus/clearingway/internal/lodestone"
+)
+
+// FreeCompany gives its role to members whose linked characters are in the
+// free company on the Lodestone.
+type FreeCompany struct {
+	Id   string
+	Name string
+	Role *Role
+}
+
+type FreeCompanies struct {
+	FreeCompanies []*FreeCompany
+}
+
+func (g *Guild) InitFreeCompanies(configFreeCompanies []*ConfigFreeCompany) error {
+	g.FreeCompanies = &FreeCompanies{FreeCompanies: []*FreeCompany{}}
+
+	for _, c := range configFreeCompanies {
+		if len(c.Id) == 0 {
+			return fmt.Errorf("Free companies must have a Lodestone ID")
+		}
+		name := c.Name
+		if len(name) == 0 {
+			name = c.Id
+		}
+
+		fc := &FreeCompany{Id: c.Id, Name: name}
+		fc.Role = &Role{
+			Name:        name,
+			Color:       0x11806a,
+			Type:        FreeCompanyRole,
+			Description: fmt.Sprintf("Member of the free company %s.", name),
+		}
+		applyConfigRole(fc.Role, c.ConfigRole)
+		g.FreeCompanies.FreeCompanies = append(g.FreeCompanies.FreeCompanies, fc)
+	}
+
+	return nil
+}
+
+func (fcs *FreeCompanies) Roles() *Roles {
+	roles := &Roles{Roles: []*Role{}}
+	for _, fc := range fcs.FreeCompanies {
+		roles.Roles = append(roles.Roles, fc.Role)
+	}
+	return roles
+}
+
+// PendingRoles works out which free company roles a member with the given
+// characters should and should not hold. A free company whose member list
+// cannot be read is left out of both, so a Lodestone outage never takes
+// roles away.
+func (fcs *FreeCompanies) PendingRoles(chars []*ffxiv.Character, region lodestone.Region) ([]*pendingRole, []*pendingRole, error) {
+	rolesToApply := []*pendingRole{}
+	rolesToRemove := []*pendingRole{}
+	var lastErr error
+
+	for _, fc := range fcs.FreeCompanies {
+		members, err := lodestone.GetFreeCompanyMembers(fc.Id, region)
+		if err != nil {
+			lastErr = fmt.Errorf("Could not read the members of %s: %w", fc.Name, err)
+			continue
+		}
+
+		var member *ffxiv.Character
+		for _, char := range chars {
+			if char.LodestoneID != 0 && members[char.LodestoneID] {
+				member = char
+				break
+			}
+		}
+		if member != nil {
+			rolesToApply = append(rolesToApply, &pendingRole{
+				role:    fc.Role,
+				message: fmt.Sprintf("`%s (%s)` is a member of %s.", member.Name(), member.World, fc.Name),
+			})
+		} else {
+			rolesToRemove = append(rolesToRemove, &pendingRole{
+				role:    fc.Role,
+				message: fmt.Sprintf("Not a member of %s.", fc.Name),
+			})
+		}
+	}
+
+	return rolesToApply, rolesToRemove, lastErr
+}
+
+// FreeCompanyRegion is the Lodestone site free company member lists are read
+// from for a member with the given characters: the one for their primary
+// character, like the rest of what is looked up about them. /clears and the
+// sweep both use it, so they read and cache the same pages.
+func (g *Guild) FreeCompanyRegion(chars []*ffxiv.Character) lodestone.Region {
+	if len(chars) == 0 {
+		return g.LodestoneRegion
+	}
+	return g.LodestoneRegionFor(chars[0])
+}
+
+// SweepFreeCompanies brings the free company roles of every member with a
+// linked character in line with the Lodestone. Members without a linked
+// character are left alone, since there is nothing to check them against.
+func (c *Clearingway) SweepFreeCompanies(g *Guild) {
+	if len(g.FreeCompanies.FreeCompanies) == 0 {
+		return
+	}
+
+	members, err := c.GuildMembers(g)
+	if err != nil {
+		fmt.Printf("Could not list members of %s to sweep free company roles: %v\n", g.Name, err)
+		return
+	}
+
+	for _, member := range members {
+		chars := g.Characters.ForDiscordId(member.User.ID)
+		if len(chars) == 0 {
+			continue
+		}
+
+		rolesToApply, rolesToRemove, err := g.FreeCompanies.PendingRoles(chars, g.FreeCompanyRegion(chars))
+		if err != nil {
+			fmt.Printf("Error sweeping free company roles in %s: %v\n", g.Name, err)
+		}
+		for _, pendingRole := range rolesToApply {
+			role := pendingRole.role
+			if role.Skip || role.PresentInRoles(member.Roles) {
+				continue
+			}
+			err := role.AddToCharacter(g.Id, member.User.ID, c.Discord.Session)
+			if err != nil {
+				fmt.Printf("Error adding free company role %s to %s: %v\n", role.Name, member.User.ID, err)
+				continue
+			}
+			fmt.Printf("Added free company role %s to %s in %s.\n", role.Name, member.User.ID, g.Name)
+		}
+		for _, pendingRole := range rolesToRemove {
+			role := pendingRole.role
+			if role.Skip || !role.PresentInRoles(member.Roles) {
+				continue
+			}
+			err := role.RemoveFromCharacter(g.Id, member.User.ID, c.Discord.Session)
+			if err != nil {
+				fmt.Printf("Error removing free company role %s from %s: %v\n", role.Name, member.User.ID, err)
+				continue
+			}
+			fmt.Printf("Removed free company role %s from %s in %s.\n", role.Name, member.User.ID, g.Name)
+		}
+	}
+}
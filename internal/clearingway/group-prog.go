@@ -0,0 +1,184 @@
+package clearingway
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/Veraticus/clearingway/internal/discord"
+	"github.com/Veraticus/clearingway/internal/fflogs"
+	"github.com/Veraticus/clearingway/internal/ffxiv"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// GroupProgOptOuts are the members of a guild who do not want reports other
+// members submit to update their prog roles. Once restored from a store, they
+// are saved to it whenever they change.
+type GroupProgOptOuts struct {
+	mu  sync.RWMutex
+	ids map[string]bool
+
+	store *Store
+	key   string
+}
+
+func NewGroupProgOptOuts() *GroupProgOptOuts {
+	return &GroupProgOptOuts{ids: map[string]bool{}}
+}
+
+// Restore loads the opt-outs saved in the store and saves them there from
+// now on.
+func (o *GroupProgOptOuts) Restore(store *Store, key string) error {
+	ids := []string{}
+	err := store.Load(key, &ids)
+	if err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.store = store
+	o.key = key
+	for _, id := range ids {
+		o.ids[id] = true
+	}
+	return nil
+}
+
+func (o *GroupProgOptOuts) Set(discordUserId string, optedOut bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if optedOut {
+		o.ids[discordUserId] = true
+	} else {
+		delete(o.ids, discordUserId)
+	}
+
+	if o.store == nil {
+		return
+	}
+	ids := []string{}
+	for id := range o.ids {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	o.store.Save(o.key, ids)
+}
+
+func (o *GroupProgOptOuts) OptedOut(discordUserId string) bool {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	return o.ids[discordUserId]
+}
+
+// ApplyGroupProg applies prog roles to every other verified member of the
+// guild whose character was in the submitter's pulls, and lets each of them
+// know if their roles changed.
+func (c *Clearingway) ApplyGroupProg(
+	reports []*fflogs.ProgReport,
+	submitterFights *fflogs.Fights,
+	submitter *ffxiv.Character,
+	guild *Guild,
+) []string {
+	text := []string{}
+	rankingsToGet := progRankingsToGet(guild)
+
+	for _, char := range guild.Characters.Linked() {
+		if char == submitter || char.DiscordId == submitter.DiscordId {
+			continue
+		}
+		if guild.GroupProgOptOuts.OptedOut(char.DiscordId) {
+			continue
+		}
+
+		fights := &fflogs.Fights{Fights: []*fflogs.Fight{}}
+		for _, report := range reports {
+			reportFights, err := report.FightsForCharacter(rankingsToGet, char)
+			if err != nil {
+				continue
+			}
+			for _, fight := range reportFights.Fights {
+				if submitterFights.Contains(fight) {
+					fights.Add(fight)
+				}
+			}
+		}
+		if len(fights.Fights) == 0 {
+			continue
+		}
+
+		progText, changed, err := c.ApplyProgForMember(fights, char, char.DiscordId, guild)
+		if err != nil {
+			text = append(text, fmt.Sprintf("Could not update prog for <@%s>: %s", char.DiscordId, err))
+			continue
+		}
+		if !changed {
+			continue
+		}
+
+		text = append(text, fmt.Sprintf("Updated prog for <@%s> (`%s (%s)`).\n", char.DiscordId, char.Name(), char.World))
+		c.notifyGroupProg(char, submitter, guild, progText)
+	}
+
+	return text
+}
+
+func (c *Clearingway) notifyGroupProg(char, submitter *ffxiv.Character, guild *Guild, progText []string) {
+	channel, err := c.Discord.Session.UserChannelCreate(char.DiscordId)
+	if err != nil {
+		fmt.Printf("Could not open DM with %s: %v\n", char.DiscordId, err)
+		return
+	}
+
+	chunks := discord.NewChunks()
+	chunks.Write(fmt.Sprintf(
+		"`%s (%s)` submitted a report in **%s** with pulls `%s (%s)` was in, which updated your prog:\n\n",
+		submitter.Name(),
+		submitter.World,
+		guild.Name,
+		char.Name(),
+		char.World,
+	))
+	for _, t := range progText {
+		chunks.Write(t + "\n")
+	}
+	chunks.Write("\nUse `/groupprog enabled:False` in that server if you would rather only update your prog yourself.")
+
+	for _, chunk := range chunks.Chunks {
+		_, err = c.Discord.Session.ChannelMessageSend(channel.ID, chunk.String())
+		if err != nil {
+			fmt.Printf("Could not send DM to %s: %v\n", char.DiscordId, err)
+			return
+		}
+	}
+}
+
+func (c *Clearingway) ToggleGroupProg(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	g, ok := c.Guilds.Guilds[i.GuildID]
+	if !ok {
+		fmt.Printf("Interaction received from guild %s with no configuration!\n", i.GuildID)
+		return
+	}
+
+	enabled := true
+	for _, opt := range i.ApplicationCommandData().Options {
+		if opt.Name == "enabled" {
+			enabled = opt.BoolValue()
+		}
+	}
+
+	g.GroupProgOptOuts.Set(i.Member.User.ID, !enabled)
+
+	message := "Reports other members submit with `/prog` can update your prog roles again."
+	if !enabled {
+		message = "Reports other members submit with `/prog` will no longer update your prog roles."
+	}
+	err := discord.StartInteraction(s, i.Interaction, message)
+	if err != nil {
+		fmt.Printf("Error sending Discord message: %v\n", err)
+	}
+}
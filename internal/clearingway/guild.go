@@ -2,8 +2,11 @@ package clearingway
 
 import (
 	"fmt"
+	"slices"
+	"strings"
 
 	"github.com/Veraticus/clearingway/internal/ffxiv"
+	"github.com/Veraticus/clearingway/internal/lodestone"
 	trie "github.com/Vivino/go-autocomplete-trie"
 	"github.com/bwmarrin/discordgo"
 )
@@ -16,8 +19,11 @@ type Guild struct {
 	Name                string
 	Id                  string
 	ChannelId           string
+	AuditChannelId      string
+	LodestoneRegion     lodestone.Region
 	Encounters          *Encounters
 	Achievements        *Achievements
+	FreeCompanies       *FreeCompanies
 	Characters          *ffxiv.Characters
 	PhysicalDatacenters *PhysicalDatacenters
 	Menus               *Menus
@@ -32,6 +38,10 @@ type Guild struct {
 	NameColorsEnabled         bool
 	ReclearsEnabled           bool
 	MenuEnabled               bool
+	UltimateProgEnabled       bool
+	ClearProgEnabled          bool
+	ClearProgReclear          bool
+	GroupProgEnabled          bool
 	SkipRemoval               bool
 
 	EncounterRoles          *Roles
@@ -43,16 +53,39 @@ type Guild struct {
 	UltimateRepetitionRoles *Roles
 	DatacenterRoles         *Roles
 	AchievementRoles        *Roles
+	TierRoles               *Roles
 	MenuRoles               *Roles // to ensure any additional roles added as part of menu config
+	UltimateProgRoles       *Roles
+	FreeCompanyRoles        *Roles
+
+	// UltimateProgEncounters are ultimates the guild did not configure
+	// itself, which only have the built-in prog roles.
+	UltimateProgEncounters *Encounters
+
+	ProgGrants       *ProgGrants
+	GroupProgOptOuts *GroupProgOptOuts
+	Ownership        *Ownership
+	FirstKills       *FirstKills
 }
 
-func (g *Guild) Init(c *ConfigGuild) {
+func (g *Guild) Init(c *ConfigGuild) error {
 	g.Name = c.Name
 	g.Id = c.GuildId
 	g.ChannelId = c.ChannelId
+	g.AuditChannelId = c.AuditChannelId
+	if len(c.LodestoneRegion) != 0 {
+		region, err := lodestone.ParseRegion(c.LodestoneRegion)
+		if err != nil {
+			return err
+		}
+		g.LodestoneRegion = region
+	}
 	g.Encounters = &Encounters{Encounters: []*Encounter{}}
 	g.Achievements = &Achievements{Achievements: []*Achievement{}}
 	g.Characters = &ffxiv.Characters{Characters: map[string]*ffxiv.Character{}}
+	g.ProgGrants = NewProgGrants()
+	g.GroupProgOptOuts = NewGroupProgOptOuts()
+	g.FirstKills = NewFirstKills()
 	g.Menus = &Menus{Menus: map[string]*Menu{}, MenuGroups: map[string][]string{}}
 	g.DefaultMenus()
 
@@ -62,7 +95,10 @@ func (g *Guild) Init(c *ConfigGuild) {
 
 	for _, configEncounter := range c.ConfigEncounters {
 		encounter := &Encounter{}
-		encounter.Init(configEncounter)
+		err := encounter.Init(configEncounter)
+		if err != nil {
+			return err
+		}
 		g.Encounters.Encounters = append(g.Encounters.Encounters, encounter)
 	}
 
@@ -139,6 +175,30 @@ func (g *Guild) Init(c *ConfigGuild) {
 			g.MenuEnabled = false
 		}
 
+		if c.ConfigRoles.UltimateProg {
+			g.UltimateProgEnabled = true
+		} else {
+			g.UltimateProgEnabled = false
+		}
+
+		if c.ConfigRoles.ClearProg {
+			g.ClearProgEnabled = true
+		} else {
+			g.ClearProgEnabled = false
+		}
+
+		if c.ConfigRoles.ClearProgReclear {
+			g.ClearProgReclear = true
+		} else {
+			g.ClearProgReclear = false
+		}
+
+		if c.ConfigRoles.GroupProg {
+			g.GroupProgEnabled = true
+		} else {
+			g.GroupProgEnabled = false
+		}
+
 		if c.ConfigRoles.SkipRemoval {
 			g.SkipRemoval = true
 		} else {
@@ -146,8 +206,33 @@ func (g *Guild) Init(c *ConfigGuild) {
 		}
 	}
 
+	err := g.InitUltimateProg(c.ConfigUltimateProg)
+	if err != nil {
+		return err
+	}
+
+	err = g.InitOwnership(c.ConfigOwnership)
+	if err != nil {
+		return err
+	}
+
+	err = g.InitFreeCompanies(c.ConfigFreeCompanies)
+	if err != nil {
+		return err
+	}
+
 	g.EncounterRoles = g.Encounters.Roles()
+	g.UltimateProgRoles = g.UltimateProgEncounters.Roles()
 	g.AchievementRoles = g.Achievements.Roles()
+	g.FreeCompanyRoles = g.FreeCompanies.Roles()
+
+	if len(c.ConfigTiers) != 0 {
+		tierRoles, err := TierRoles(c.ConfigTiers, g.Encounters)
+		if err != nil {
+			return err
+		}
+		g.TierRoles = tierRoles
+	}
 
 	if g.RelevantParsingEnabled {
 		g.RelevantParsingRoles = RelevantParsingRoles()
@@ -178,12 +263,12 @@ func (g *Guild) Init(c *ConfigGuild) {
 	}
 
 	if g.MenuEnabled {
-		if (c.ConfigMenuOrder != nil) {
+		if c.ConfigMenuOrder != nil {
 			for _, menuOrder := range c.ConfigMenuOrder {
 				g.Menus.MenuGroups[menuOrder.Name] = menuOrder.Menus
 			}
 		}
-		
+
 		g.InitDiscordMenu()
 		g.MenuRoles = g.Menus.Roles()
 	}
@@ -198,7 +283,7 @@ func (g *Guild) Init(c *ConfigGuild) {
 						continue
 					}
 
-					if configReconfigureRole.EncounterName != "" && role.Encounter.Name != configReconfigureRole.EncounterName {
+					if configReconfigureRole.EncounterName != "" && (role.Encounter == nil || role.Encounter.Name != configReconfigureRole.EncounterName) {
 						continue
 					}
 
@@ -214,15 +299,179 @@ func (g *Guild) Init(c *ConfigGuild) {
 					if configReconfigureRole.DontSkip {
 						role.Skip = false
 					}
+					if len(configReconfigureRole.Requires) != 0 {
+						role.Requires = append(role.Requires, configReconfigureRole.Requires...)
+					}
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// InitPrerequisites resolves the configured requirements of every role in the
+// guild into role prerequisites and makes sure they do not form a cycle.
+// Requirements on encounter roles are first matched against the role types of
+// the same encounter, then against role names in the whole guild.
+func (g *Guild) InitPrerequisites() error {
+	allRoles := g.AllRoles()
+
+	for _, role := range allRoles {
+		for _, required := range role.Requires {
+			var prereq *Role
+			if role.Encounter != nil {
+				prereq = role.Encounter.Roles[RoleType(required)]
+			}
+			if prereq == nil {
+				for _, r := range allRoles {
+					if r.Name == required {
+						prereq = r
+						break
+					}
 				}
 			}
+			if prereq == nil {
+				return fmt.Errorf("Role %s requires %s, but no such role exists", role.Name, required)
+			}
+			if prereq == role {
+				return fmt.Errorf("Role %s cannot require itself", role.Name)
+			}
+			role.AddPrerequisite(prereq)
+		}
+	}
+
+	// Walk the prerequisite graph depth-first; reaching a role that is still
+	// on the current path means the roles depend on each other.
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := map[*Role]int{}
+	var visit func(role *Role, path []string) error
+	visit = func(role *Role, path []string) error {
+		path = append(path, role.Name)
+		switch state[role] {
+		case visiting:
+			return fmt.Errorf("Role prerequisites form a cycle: %s", strings.Join(path, " -> "))
+		case visited:
+			return nil
+		}
+		state[role] = visiting
+		for _, prereq := range role.Prerequisites {
+			err := visit(prereq, path)
+			if err != nil {
+				return err
+			}
+		}
+		state[role] = visited
+		return nil
+	}
+	for _, role := range allRoles {
+		err := visit(role, []string{})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// EnforcePrerequisites works out which roles a member will hold once the
+// pending changes are made, then drops pending additions whose prerequisites
+// will not be held and schedules held roles that lost a prerequisite for
+// removal. Losing a role can cascade to the roles that depend on it.
+func (g *Guild) EnforcePrerequisites(memberRoleIds []string, rolesToApply, rolesToRemove []*pendingRole) ([]*pendingRole, []*pendingRole) {
+	held := map[*Role]bool{}
+	for _, role := range g.AllRoles() {
+		if role.Skip || role.DiscordRole == nil {
+			continue
+		}
+		if role.PresentInRoles(memberRoleIds) {
+			held[role] = true
+		}
+	}
+	for _, pendingRole := range rolesToApply {
+		held[pendingRole.role] = true
+	}
+	if !g.SkipRemoval {
+		for _, pendingRole := range rolesToRemove {
+			delete(held, pendingRole.role)
+		}
+	}
+
+	lost := map[*Role]*Role{}
+	for {
+		changed := false
+		for role := range held {
+			prereq := role.MissingPrerequisite(held)
+			if prereq == nil {
+				continue
+			}
+			delete(held, role)
+			lost[role] = prereq
+			changed = true
+		}
+		if !changed {
+			break
+		}
+	}
+
+	if len(lost) == 0 {
+		return rolesToApply, rolesToRemove
+	}
+
+	filteredRolesToApply := []*pendingRole{}
+	for _, pendingRole := range rolesToApply {
+		if _, ok := lost[pendingRole.role]; ok {
+			continue
+		}
+		filteredRolesToApply = append(filteredRolesToApply, pendingRole)
+	}
+
+	for role, prereq := range lost {
+		message := fmt.Sprintf("Requires the **%s** role.", prereq.Name)
+		found := false
+		for _, pendingRole := range rolesToRemove {
+			if pendingRole.role == role {
+				pendingRole.message = message
+				found = true
+			}
+		}
+		if !found {
+			rolesToRemove = append(rolesToRemove, &pendingRole{role: role, message: message})
 		}
 	}
+
+	return filteredRolesToApply, rolesToRemove
 }
 
+// AllEncounters is the guild's encounters plus any ultimates the guild has
+// not configured itself.
 func (g *Guild) AllEncounters() []*Encounter {
-	encounters := g.Encounters.Encounters
-	encounters = append(encounters, UltimateEncounters.Encounters...)
+	encounters := slices.Clone(g.Encounters.Encounters)
+	for _, ult := range UltimateEncounters.Encounters {
+		if g.Encounters.ForName(ult.Name) != nil || g.Encounters.ForIds(ult.Ids) != nil {
+			continue
+		}
+		encounters = append(encounters, ult)
+	}
+
+	return encounters
+}
+
+// ProgEncounters is every encounter in the guild with prog roles.
+func (g *Guild) ProgEncounters() []*Encounter {
+	encounters := []*Encounter{}
+	for _, encounter := range g.Encounters.Encounters {
+		if encounter.ProgRoles != nil {
+			encounters = append(encounters, encounter)
+		}
+	}
+	if g.UltimateProgEncounters != nil {
+		encounters = append(encounters, g.UltimateProgEncounters.Encounters...)
+	}
 
 	return encounters
 }
@@ -231,6 +480,12 @@ func (g *Guild) NonUltRoles() []*Role {
 	roles := g.EncounterRoles.Roles
 	roles = append(roles, g.AchievementRoles.Roles...)
 
+	if g.TierRoles != nil {
+		roles = append(roles, g.TierRoles.Roles...)
+	}
+	if g.FreeCompanyRoles != nil {
+		roles = append(roles, g.FreeCompanyRoles.Roles...)
+	}
 	if g.RelevantParsingEnabled {
 		roles = append(roles, g.RelevantParsingRoles.Roles...)
 	}
@@ -262,6 +517,9 @@ func (g *Guild) UltRoles() []*Role {
 	if g.UltimateRepetitionEnabled {
 		roles = append(roles, g.UltimateRepetitionRoles.Roles...)
 	}
+	if g.UltimateProgEnabled {
+		roles = append(roles, g.UltimateProgRoles.Roles...)
+	}
 
 	return roles
 }
@@ -271,13 +529,7 @@ func (g *Guild) AllRoles() []*Role {
 }
 
 func (g *Guild) IsProgEnabled() bool {
-	for _, encounter := range g.Encounters.Encounters {
-		if encounter.ProgRoles != nil {
-			return true
-		}
-	}
-
-	return false
+	return len(g.ProgEncounters()) != 0
 }
 
 func (g *Guild) InitDiscordMenu() {
@@ -314,7 +566,7 @@ func (g *Guild) InitDiscordMenu() {
 	for group, _ := range g.Menus.MenuGroups {
 		menuGroupName := "group " + group
 		g.Menus.Autocomplete = append(g.Menus.Autocomplete, &discordgo.ApplicationCommandOptionChoice{
-			Name: menuGroupName,
+			Name:  menuGroupName,
 			Value: menuGroupName,
 		})
 		g.Menus.AutoCompleteTrie.Insert(menuGroupName)
@@ -324,3 +576,12 @@ func (g *Guild) InitDiscordMenu() {
 	dataMenuRemove := g.Menus.Menus[string(MenuRemove)]
 	dataMenuRemove.MenuRemoveInit()
 }
+
+// LodestoneRegionFor returns the Lodestone site to use for a character: the
+// guild's configured region, or the one for the character's datacenter.
+func (g *Guild) LodestoneRegionFor(char *ffxiv.Character) lodestone.Region {
+	if len(g.LodestoneRegion) != 0 {
+		return g.LodestoneRegion
+	}
+	return lodestone.RegionForCharacter(char)
+}
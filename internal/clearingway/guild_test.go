@@ -0,0 +1,329 @@
+package clearingway
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Veraticus/clearingway/internal/fflogs"
+	"github.com/Veraticus/clearingway/internal/ffxiv"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func testGuild(roles ...*Role) *Guild {
+	return &Guild{
+		EncounterRoles:   &Roles{Roles: roles},
+		AchievementRoles: &Roles{Roles: []*Role{}},
+	}
+}
+
+func TestInitPrerequisites(t *testing.T) {
+	tests := []struct {
+		name          string
+		roles         func() []*Role
+		expectedError string
+	}{
+		{
+			name: "Resolves role names",
+			roles: func() []*Role {
+				return []*Role{
+					{Name: "The Legend"},
+					{Name: "Legend Blue", Requires: []string{"The Legend"}},
+				}
+			},
+		},
+		{
+			name: "Unknown role",
+			roles: func() []*Role {
+				return []*Role{
+					{Name: "Legend Blue", Requires: []string{"The Legend"}},
+				}
+			},
+			expectedError: "Role Legend Blue requires The Legend, but no such role exists",
+		},
+		{
+			name: "Cycle",
+			roles: func() []*Role {
+				return []*Role{
+					{Name: "A", Requires: []string{"B"}},
+					{Name: "B", Requires: []string{"C"}},
+					{Name: "C", Requires: []string{"A"}},
+				}
+			},
+			expectedError: "Role prerequisites form a cycle: A -> B -> C -> A",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := testGuild(tt.roles()...).InitPrerequisites()
+			if tt.expectedError == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.expectedError)
+			}
+		})
+	}
+}
+
+func TestInitPrerequisitesEncounterRoleTypes(t *testing.T) {
+	e := &Encounter{Name: "FRU", Roles: map[RoleType]*Role{}}
+	cleared := &Role{Name: "FRU Cleared", Type: ClearedRole, Encounter: e}
+	c4x := &Role{Name: "FRU C4X", Type: C4XRole, Encounter: e, Requires: []string{"Cleared"}}
+	e.Roles[ClearedRole] = cleared
+	e.Roles[C4XRole] = c4x
+
+	err := testGuild(cleared, c4x).InitPrerequisites()
+	assert.NoError(t, err)
+	assert.Equal(t, []*Role{cleared}, c4x.Prerequisites)
+}
+
+func TestEnforcePrerequisites(t *testing.T) {
+	legend := &Role{Name: "The Legend", DiscordRole: &discordgo.Role{ID: "1"}}
+	blue := &Role{Name: "Legend Blue", DiscordRole: &discordgo.Role{ID: "2"}, Requires: []string{"The Legend"}}
+	shiny := &Role{Name: "Shiny Legend Blue", DiscordRole: &discordgo.Role{ID: "3"}, Requires: []string{"Legend Blue"}}
+	g := testGuild(legend, blue, shiny)
+	assert.NoError(t, g.InitPrerequisites())
+
+	// Losing the base role cascades to every role depending on it.
+	toApply, toRemove := g.EnforcePrerequisites(
+		[]string{"1", "2", "3"},
+		[]*pendingRole{},
+		[]*pendingRole{{role: legend, message: "Did not clear only one ultimate."}},
+	)
+	assert.Empty(t, toApply)
+	removed := map[string]string{}
+	for _, p := range toRemove {
+		removed[p.role.Name] = p.message
+	}
+	assert.Equal(t, map[string]string{
+		"The Legend":        "Did not clear only one ultimate.",
+		"Legend Blue":       "Requires the **The Legend** role.",
+		"Shiny Legend Blue": "Requires the **Legend Blue** role.",
+	}, removed)
+
+	// A pending role is not added without its prerequisite.
+	toApply, _ = g.EnforcePrerequisites(
+		[]string{},
+		[]*pendingRole{{role: blue}},
+		[]*pendingRole{},
+	)
+	assert.Empty(t, toApply)
+
+	// Nothing changes when prerequisites are held.
+	toApply, toRemove = g.EnforcePrerequisites(
+		[]string{"1"},
+		[]*pendingRole{{role: blue}},
+		[]*pendingRole{},
+	)
+	assert.Len(t, toApply, 1)
+	assert.Empty(t, toRemove)
+}
+
+func TestTierRoles(t *testing.T) {
+	p9s := &Encounter{Name: "P9S", Ids: []int{1}}
+	p10s := &Encounter{Name: "P10S", Ids: []int{2}}
+	p11s := &Encounter{Name: "P11S", Ids: []int{3}}
+	es := &Encounters{Encounters: []*Encounter{p9s, p10s, p11s}}
+
+	roles, err := TierRoles([]*ConfigTier{
+		{Name: "Anabaseios", Encounters: []string{"P9S", "P10S", "P11S"}},
+		{Name: "Any Two", Encounters: []string{"P9S", "P10S", "P11S"}, Required: 2, ConfigRole: &ConfigRole{Name: "Two Down", Color: 1}},
+	}, es)
+	assert.NoError(t, err)
+	all, anyTwo := roles.Roles[0], roles.Roles[1]
+	assert.Equal(t, "Anabaseios-Cleared", all.Name)
+	assert.Equal(t, "Cleared every encounter in Anabaseios.", all.Description)
+	assert.Equal(t, "Two Down", anyTwo.Name)
+	assert.Equal(t, 1, anyTwo.Color)
+	assert.Equal(t, "Cleared at least 2 encounters in Any Two.", anyTwo.Description)
+
+	rank := func(at time.Time) *fflogs.Rank {
+		return &fflogs.Rank{StartTime: int(at.UnixMilli()), Job: ffxiv.Jobs["Sage"], Report: fflogs.Report{Code: "abc", FightId: 1}}
+	}
+	opts := &ShouldApplyOpts{Rankings: &fflogs.Rankings{Rankings: map[int]*fflogs.Ranking{
+		1: {TotalKills: 1, Ranks: []*fflogs.Rank{rank(day(1))}},
+		3: {TotalKills: 1, Ranks: []*fflogs.Rank{rank(day(2))}},
+	}}}
+
+	// Two of three clears is enough for any two, but not for all of them.
+	ok, message := all.ShouldApply(opts)
+	assert.False(t, ok)
+	assert.Equal(t, "Cleared **2** of the **3** required encounters in `Anabaseios`.", message)
+
+	ok, message = anyTwo.ShouldApply(opts)
+	assert.True(t, ok)
+	assert.Contains(t, message, "Cleared **2** of **3** encounters in `Any Two`:")
+	assert.Contains(t, message, fmt.Sprintf("`P9S` with `SGE` on <t:%d:F>", day(1).Unix()))
+	assert.Contains(t, message, fmt.Sprintf("`P11S` with `SGE` on <t:%d:F>", day(2).Unix()))
+	assert.NotContains(t, message, "P10S")
+
+	opts.Rankings.Rankings[2] = &fflogs.Ranking{TotalKills: 1, Ranks: []*fflogs.Rank{rank(day(3))}}
+	ok, _ = all.ShouldApply(opts)
+	assert.True(t, ok)
+
+	_, err = TierRoles([]*ConfigTier{{Name: "Pandaemonium", Encounters: []string{"P1S"}}}, es)
+	assert.EqualError(t, err, "Tier Pandaemonium references encounter P1S, which is not configured")
+	_, err = TierRoles([]*ConfigTier{{Name: "Too Many", Encounters: []string{"P9S"}, Required: 2}}, es)
+	assert.EqualError(t, err, "Tier Too Many requires 2 clears but only has 1 encounters")
+}
+
+func TestMilestoneRoles(t *testing.T) {
+	e := &Encounter{Name: "P12S", Ids: []int{1}}
+	roles, err := MilestoneRoles([]*ConfigMilestone{
+		{Kills: 100},
+		{Kills: 1},
+		{Kills: 10, ConfigRole: &ConfigRole{Name: "P12S Farmer"}},
+	}, e)
+	assert.NoError(t, err)
+
+	// Rungs are sorted by kills, whatever order they are configured in.
+	names := []string{}
+	for _, role := range roles.Roles {
+		names = append(names, role.Name)
+	}
+	assert.Equal(t, []string{"P12S-1 Clear", "P12S Farmer", "P12S-100 Clears"}, names)
+
+	// Only the highest rung reached applies.
+	for kills, applies := range map[int]string{0: "", 1: "P12S-1 Clear", 10: "P12S Farmer", 11: "P12S Farmer", 100: "P12S-100 Clears"} {
+		opts := &ShouldApplyOpts{Rankings: &fflogs.Rankings{Rankings: map[int]*fflogs.Ranking{1: {TotalKills: kills}}}}
+		applied := []string{}
+		for _, role := range roles.Roles {
+			if ok, _ := role.ShouldApply(opts); ok {
+				applied = append(applied, role.Name)
+			}
+		}
+		if len(applies) == 0 {
+			assert.Empty(t, applied, "%d kills", kills)
+		} else {
+			assert.Equal(t, []string{applies}, applied, "%d kills", kills)
+		}
+	}
+
+	_, err = MilestoneRoles([]*ConfigMilestone{{Kills: 10}, {Kills: 10}}, e)
+	assert.EqualError(t, err, "More than one milestone requires 10 kills")
+	_, err = MilestoneRoles([]*ConfigMilestone{{Kills: 0}, {Kills: 10}}, e)
+	assert.EqualError(t, err, "Milestones must require at least one kill")
+}
+
+func TestPassedMilestoneRoles(t *testing.T) {
+	e := &Encounter{Name: "P12S", Ids: []int{1}}
+	roles, err := MilestoneRoles([]*ConfigMilestone{{Kills: 1}, {Kills: 10}, {Kills: 100}}, e)
+	assert.NoError(t, err)
+	for i, role := range roles.Roles {
+		role.DiscordRole = &discordgo.Role{ID: fmt.Sprint(i + 1)}
+	}
+	e.MilestoneRoles = roles
+	one, ten, hundred := roles.Roles[0], roles.Roles[1], roles.Roles[2]
+	other := &Role{Name: "P12S Cleared", DiscordRole: &discordgo.Role{ID: "4"}}
+
+	g := testGuild(other)
+	g.Encounters = &Encounters{Encounters: []*Encounter{e}}
+
+	// Reaching ten kills takes the held one-kill rung out of the roles to
+	// remove, so it goes even if removal is skipped.
+	remaining, passed := g.PassedMilestoneRoles(
+		[]string{"1", "4"},
+		[]*pendingRole{{role: ten}},
+		[]*pendingRole{{role: one}, {role: hundred}, {role: other}},
+	)
+	assert.Equal(t, []*pendingRole{{role: hundred}, {role: other}}, remaining)
+	assert.Equal(t, []*pendingRole{{role: one}}, passed)
+
+	// Without a rung to apply, nothing is passed.
+	remaining, passed = g.PassedMilestoneRoles([]string{"1"}, []*pendingRole{}, []*pendingRole{{role: one}})
+	assert.Equal(t, []*pendingRole{{role: one}}, remaining)
+	assert.Empty(t, passed)
+}
+
+func TestInitUltimateProg(t *testing.T) {
+	top := &Encounter{Name: "TOP", Ids: []int{1068, 1077}, Roles: map[RoleType]*Role{}}
+	g := &Guild{
+		Encounters:          &Encounters{Encounters: []*Encounter{top}},
+		UltimateProgEnabled: true,
+	}
+
+	err := g.InitUltimateProg([]*ConfigUltimateProg{
+		{Encounter: "Futures Rewritten (Ultimate)", ConfigProg: []*ConfigProgRole{
+			{Phase: 2, Mechanic: "Diamond Dust", ConfigRole: ConfigRole{Name: "FRU Diamond Dust"}},
+			{Phase: 2, BossPercentage: 50, ConfigRole: ConfigRole{Name: "FRU Light Rampant"}},
+		}},
+	})
+	assert.NoError(t, err)
+
+	// The guild's own copy of TOP gets the prog roles instead of a duplicate.
+	assert.NotNil(t, top.ProgRoles)
+	assert.Nil(t, g.UltimateProgEncounters.ForName("The Omega Protocol (Ultimate)"))
+
+	fru := g.UltimateProgEncounters.ForName("Futures Rewritten (Ultimate)")
+	assert.NotNil(t, fru)
+	names := []string{}
+	for _, role := range fru.ProgRoles.Roles {
+		names = append(names, role.Name)
+	}
+	assert.Equal(t, []string{
+		"FRU P1 Prog (Fatebreaker)",
+		"FRU Diamond Dust",
+		"FRU Light Rampant",
+		"FRU P3 Prog (Oracle of Darkness)",
+		"FRU P4 Prog (Usurper and Oracle)",
+		"FRU P5 Prog (Pandora)",
+	}, names)
+	// Overrides keep the built-in phase name.
+	assert.Equal(t, "Reached phase 2 (Usurper of Frost) in prog: Diamond Dust", fru.ProgRoles.Roles[1].Description)
+
+	encounterNames := []string{}
+	for _, e := range g.AllEncounters() {
+		encounterNames = append(encounterNames, e.Name)
+	}
+	assert.NotContains(t, encounterNames, "The Omega Protocol (Ultimate)")
+	assert.Len(t, encounterNames, len(UltimateEncounters.Encounters))
+
+	err = g.InitUltimateProg([]*ConfigUltimateProg{{Encounter: "Unreal"}})
+	assert.EqualError(t, err, "Ultimate prog overrides Unreal, which is not an ultimate")
+}
+
+func TestClearedProgRoles(t *testing.T) {
+	e := &Encounter{Name: "TOP", Roles: map[RoleType]*Role{}}
+	cleared := &Role{Name: "TOP Cleared", Type: ClearedRole, DiscordRole: &discordgo.Role{ID: "1"}}
+	reclear := &Role{Name: "TOP Reclear", Type: ReclearRole, DiscordRole: &discordgo.Role{ID: "2"}}
+	p5 := &Role{Name: "TOP P5 Prog", Type: ProgRole, DiscordRole: &discordgo.Role{ID: "3"}, Encounter: e}
+	p6 := &Role{Name: "TOP P6 Prog", Type: ProgRole, DiscordRole: &discordgo.Role{ID: "4"}, Encounter: e}
+	e.Roles[ClearedRole] = cleared
+	e.Roles[ReclearRole] = reclear
+	e.ProgRoles = &Roles{Roles: []*Role{p5, p6}}
+
+	g := testGuild(cleared, reclear, p5, p6)
+	g.Encounters = &Encounters{Encounters: []*Encounter{e}}
+	g.ClearProgEnabled = true
+	g.ClearProgReclear = true
+
+	toApply, toRemove := g.ClearedProgRoles([]string{"4"}, []*pendingRole{{role: cleared}}, nil)
+	assert.Len(t, toRemove, 1)
+	assert.Equal(t, p6, toRemove[0].role)
+	assert.Len(t, toApply, 1)
+	assert.Equal(t, reclear, toApply[0].role)
+
+	// Nothing changes until the Cleared role applies.
+	toApply, toRemove = g.ClearedProgRoles([]string{"4"}, []*pendingRole{}, nil)
+	assert.Empty(t, toApply)
+	assert.Empty(t, toRemove)
+}
+
+func TestDependsOnLogs(t *testing.T) {
+	cleared := &Role{Name: "TOP Cleared", Type: ClearedRole}
+	aether := &Role{Name: "Aether"}
+	primal := &Role{Name: "Primal", Prerequisites: []*Role{cleared}}
+
+	g := testGuild(cleared)
+	g.DatacenterEnabled = true
+	g.DatacenterRoles = &Roles{Roles: []*Role{aether, primal}}
+
+	// A linked character whose logs could not be fetched might have earned
+	// any role but those that only depend on the primary character's world.
+	assert.True(t, g.dependsOnLogs(cleared))
+	assert.False(t, g.dependsOnLogs(aether))
+	assert.True(t, g.dependsOnLogs(primal))
+}
@@ -64,6 +64,7 @@ func (c *Clearingway) DiscordReady(s *discordgo.Session, event *discordgo.Ready)
 
 		commandList := []*discordgo.ApplicationCommand{
 			ClearCommand,
+			CharactersCommand,
 			UncomfyCommand,
 			UncolorCommand,
 			RemoveCommand,
@@ -72,6 +73,9 @@ func (c *Clearingway) DiscordReady(s *discordgo.Session, event *discordgo.Ready)
 
 		if guild.IsProgEnabled() {
 			commandList = append(commandList, ProgCommand)
+			if guild.GroupProgEnabled {
+				commandList = append(commandList, GroupProgCommand)
+			}
 		}
 
 		if guild.ReclearsEnabled {
@@ -149,6 +153,12 @@ var ClearCommand = &discordgo.ApplicationCommand{
 			Description: "Your character's last name",
 			Required:    true,
 		},
+		{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "lodestone",
+			Description: "Your Lodestone profile URL or ID, if others share your character's name",
+			Required:    false,
+		},
 	},
 }
 
@@ -174,7 +184,7 @@ var RolesCommand = &discordgo.ApplicationCommand{
 
 var ProgCommand = &discordgo.ApplicationCommand{
 	Name:        "prog",
-	Description: "Assign yourself roles based on prog from a linked fflogs report",
+	Description: "Assign yourself roles based on prog from linked or recent fflogs reports",
 	Options: []*discordgo.ApplicationCommandOption{
 		{
 			Type:         discordgo.ApplicationCommandOptionString,
@@ -198,7 +208,44 @@ var ProgCommand = &discordgo.ApplicationCommand{
 		{
 			Type:        discordgo.ApplicationCommandOptionString,
 			Name:        "report-id",
-			Description: "An fflogs report URL or ID",
+			Description: "One or more fflogs report URLs or IDs, separated by spaces or commas",
+			Required:    false,
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionBoolean,
+			Name:        "recent",
+			Description: "Also search your recent public fflogs reports",
+			Required:    false,
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionBoolean,
+			Name:        "group",
+			Description: "Also update prog for other verified members in the same pulls",
+			Required:    false,
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionBoolean,
+			Name:        "watch",
+			Description: "Keep watching a live report and update prog as new pulls are logged",
+			Required:    false,
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionBoolean,
+			Name:        "summary",
+			Description: "Also show a summary of the pulls: time spent, furthest pull and wipes per phase",
+			Required:    false,
+		},
+	},
+}
+
+var GroupProgCommand = &discordgo.ApplicationCommand{
+	Name:        "groupprog",
+	Description: "Choose whether reports other members submit with /prog can update your prog roles",
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionBoolean,
+			Name:        "enabled",
+			Description: "Whether other members' reports can update your prog roles",
 			Required:    true,
 		},
 	},
@@ -288,6 +335,8 @@ func (c *Clearingway) InteractionCreate(s *discordgo.Session, i *discordgo.Inter
 		switch i.ApplicationCommandData().Name {
 		case "clears":
 			c.Clears(s, i)
+		case "characters":
+			c.Characters(s, i)
 		case "uncomfy":
 			c.Uncomfy(s, i)
 		case "uncolor":
@@ -296,6 +345,8 @@ func (c *Clearingway) InteractionCreate(s *discordgo.Session, i *discordgo.Inter
 			c.Roles(s, i)
 		case "prog":
 			c.Prog(s, i)
+		case "groupprog":
+			c.ToggleGroupProg(s, i)
 		case "removeall":
 			c.RemoveAll(s, i)
 		case "namecolor":
@@ -309,8 +360,10 @@ func (c *Clearingway) InteractionCreate(s *discordgo.Session, i *discordgo.Inter
 		switch i.ApplicationCommandData().Name {
 		case "clears":
 			c.Autocomplete(s, i)
+		case "characters":
+			c.CharactersAutocomplete(s, i)
 		case "prog":
-        	c.Autocomplete(s, i)
+			c.Autocomplete(s, i)
 		case "menu":
 			c.MenuAutocomplete(s, i)
 		}
@@ -327,6 +380,15 @@ func (c *Clearingway) InteractionCreate(s *discordgo.Session, i *discordgo.Inter
 			return
 		}
 
+		if command[0] == OwnershipApproval {
+			c.OwnershipApprovalRespond(s, i, command)
+			return
+		}
+		if command[0] == LodestoneSelect {
+			c.LodestoneSelectRespond(s, i, command)
+			return
+		}
+
 		switch MenuType(command[0]) {
 		case MenuVerify:
 			switch CommandType(command[1]) {
@@ -358,7 +420,7 @@ func (c *Clearingway) InteractionCreate(s *discordgo.Session, i *discordgo.Inter
 				c.MenuEncounterProcess(s, i, command[2], command[3])
 			default:
 				fmt.Printf("Invalid custom ID received: \"%v\"\n", customID)
-			}	
+			}
 		default:
 			fmt.Printf("Invalid custom ID received: \"%v\"\n", customID)
 		}
@@ -626,10 +688,16 @@ func (c *Clearingway) Roles(s *discordgo.Session, i *discordgo.InteractionCreate
 
 	chunks.Write("\nClearingway can give out these roles with `/clears`:\n")
 
+	// Prog roles are shown as embeds after the rest of the roles.
+	progEmbeds := []*discordgo.MessageEmbed{}
 	for _, r := range g.AllRoles() {
 		if r.Skip {
 			continue
 		}
+		if r.ProgPoint != nil {
+			progEmbeds = append(progEmbeds, r.ProgEmbed(nil))
+			continue
+		}
 		chunks.Write(fmt.Sprintf("__**%s**__\n⮕ %s\n\n", r.Name, r.Description))
 	}
 
@@ -645,6 +713,19 @@ func (c *Clearingway) Roles(s *discordgo.Session, i *discordgo.InteractionCreate
 			return
 		}
 	}
+
+	if len(progEmbeds) == 0 {
+		return
+	}
+	err := discord.ContinueInteraction(s, i.Interaction, "_ _\nClearingway can give out these roles with `/prog`:")
+	if err != nil {
+		fmt.Printf("Error sending Discord message: %v\n", err)
+		return
+	}
+	err = discord.ContinueInteractionWithEmbeds(s, i.Interaction, progEmbeds)
+	if err != nil {
+		fmt.Printf("Error sending Discord message: %v\n", err)
+	}
 }
 
 func (c *Clearingway) RemoveAll(s *discordgo.Session, i *discordgo.InteractionCreate) {
@@ -740,23 +821,12 @@ func (c *Clearingway) ToggleReclear(s *discordgo.Session, i *discordgo.Interacti
 	ultimate := i.ApplicationCommandData().Options[0].StringValue()
 	encounter := g.Encounters.ForName(ultimate)
 	reclearRole := encounter.Roles[ReclearRole]
-	clearedRole := encounter.Roles[ClearedRole]
 
-	var rolePresent = false
-	var clearPresent = false
-	for _, r := range i.Member.Roles {
-		if r == reclearRole.DiscordRole.ID {
-			rolePresent = true
-			continue
-		}
-		if r == clearedRole.DiscordRole.ID {
-			clearPresent = true
-			continue
-		}
-	}
+	rolePresent := reclearRole.PresentInRoles(i.Member.Roles)
+	missingRoles := reclearRole.MissingPrerequisitesInRoles(i.Member.Roles)
 
 	// Remove role no matter what
-	// Add role only if cleared role is present
+	// Add role only if its prerequisites are present
 	if rolePresent {
 		fmt.Printf("Removing role: %+v\n", reclearRole.Name)
 		err = reclearRole.RemoveFromCharacter(g.Id, i.Member.User.ID, c.Discord.Session)
@@ -770,7 +840,7 @@ func (c *Clearingway) ToggleReclear(s *discordgo.Session, i *discordgo.Interacti
 			fmt.Printf("Error sending Discord message: %v\n", err)
 		}
 	} else {
-		if clearPresent {
+		if len(missingRoles) == 0 {
 			fmt.Printf("Adding role: %+v\n", reclearRole.Name)
 			err = reclearRole.AddToCharacter(g.Id, i.Member.User.ID, c.Discord.Session)
 			if err != nil {
@@ -783,8 +853,7 @@ func (c *Clearingway) ToggleReclear(s *discordgo.Session, i *discordgo.Interacti
 				fmt.Printf("Error sending Discord message: %v\n", err)
 			}
 		} else {
-			tempstr := fmt.Sprintf("You do not have the required role: <@&%v>", clearedRole.DiscordRole.ID)
-			err = discord.ContinueInteraction(s, i.Interaction, tempstr)
+			err = discord.ContinueInteraction(s, i.Interaction, missingPrerequisitesMessage(missingRoles))
 			if err != nil {
 				fmt.Printf("Error sending Discord message: %v\n", err)
 			}
@@ -792,6 +861,14 @@ func (c *Clearingway) ToggleReclear(s *discordgo.Session, i *discordgo.Interacti
 	}
 }
 
+func missingPrerequisitesMessage(missingRoles []*Role) string {
+	message := strings.Builder{}
+	for _, missingRole := range missingRoles {
+		message.WriteString(fmt.Sprintf("You do not have the required role: <@&%v>\n", missingRole.DiscordRole.ID))
+	}
+	return strings.TrimSuffix(message.String(), "\n")
+}
+
 func (c *Clearingway) ToggleColor(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	g, ok := c.Guilds.Guilds[i.GuildID]
 	if !ok {
@@ -819,10 +896,9 @@ func (c *Clearingway) ToggleColor(s *discordgo.Session, i *discordgo.Interaction
 	}
 
 	requestedColorRole := wantedEncounter.Roles[ColorRole]
-	clearedRole := wantedEncounter.Roles[ClearedRole]
+	missingRoles := requestedColorRole.MissingPrerequisitesInRoles(i.Member.Roles)
 
 	var roleToRemove *Role
-	var cleared = false
 	for _, memberRole := range i.Member.Roles {
 		if roleToRemove == nil {
 			for _, colorRole := range colorRoles {
@@ -832,12 +908,9 @@ func (c *Clearingway) ToggleColor(s *discordgo.Session, i *discordgo.Interaction
 				}
 			}
 		}
-		if (!cleared) && (memberRole == clearedRole.DiscordRole.ID) {
-			cleared = true
-		}
 	}
 
-	if cleared {
+	if len(missingRoles) == 0 {
 		// remove existing color role
 		tempstr := ""
 		if roleToRemove != nil {
@@ -868,8 +941,7 @@ func (c *Clearingway) ToggleColor(s *discordgo.Session, i *discordgo.Interaction
 			discord.ContinueInteraction(s, i.Interaction, tempstr)
 		} else {
 			// user doesn't meet the requirements
-			tempstr := fmt.Sprintf("You do not have the required role: <@&%v>", clearedRole.DiscordRole.ID)
-			err = discord.ContinueInteraction(s, i.Interaction, tempstr)
+			err = discord.ContinueInteraction(s, i.Interaction, missingPrerequisitesMessage(missingRoles))
 			if err != nil {
 				fmt.Printf("Error sending Discord message: %v\n", err)
 			}
@@ -903,6 +975,12 @@ func (c *Clearingway) Autocomplete(s *discordgo.Session, i *discordgo.Interactio
 	optionMap := make(map[string]*discordgo.ApplicationCommandInteractionDataOption, len(options))
 	for _, opt := range options {
 		optionMap[opt.Name] = opt
+		// Subcommands carry their own options.
+		if opt.Type == discordgo.ApplicationCommandOptionSubCommand {
+			for _, subOpt := range opt.Options {
+				optionMap[subOpt.Name] = subOpt
+			}
+		}
 	}
 
 	var world string
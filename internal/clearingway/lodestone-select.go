@@ -0,0 +1,124 @@
+package clearingway
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Veraticus/clearingway/internal/discord"
+	"github.com/Veraticus/clearingway/internal/ffxiv"
+	"github.com/Veraticus/clearingway/internal/lodestone"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// LodestoneSelect prefixes the custom ID of the menu members use to pick their
+// character out of several Lodestone search results, in the format
+// [LodestoneSelect] [world] [first name] [last name].
+const LodestoneSelect = "lodestoneSelect"
+
+// MaxSelectOptions is the most options Discord shows in a select menu.
+const MaxSelectOptions = 25
+
+// SendLodestoneCandidates shows the characters a Lodestone search found and
+// lets the member pick theirs.
+func SendLodestoneCandidates(s *discordgo.Session, i *discordgo.Interaction, g *Guild, char *ffxiv.Character, ambiguous *lodestone.AmbiguousError) error {
+	region := g.LodestoneRegionFor(char)
+	candidates := ambiguous.Candidates
+	if len(candidates) > MaxSelectOptions {
+		candidates = candidates[:MaxSelectOptions]
+	}
+
+	embeds := []*discordgo.MessageEmbed{}
+	options := []discordgo.SelectMenuOption{}
+	for _, candidate := range candidates {
+		if len(embeds) < discord.MaxEmbedsPerMessage {
+			embed := &discordgo.MessageEmbed{
+				Title:  fmt.Sprintf("%s (%s)", candidate.Name, candidate.World),
+				URL:    region.CharacterURL(candidate.LodestoneID),
+				Footer: &discordgo.MessageEmbedFooter{Text: fmt.Sprintf("Lodestone ID %d", candidate.LodestoneID)},
+			}
+			if len(candidate.Avatar) != 0 {
+				embed.Thumbnail = &discordgo.MessageEmbedThumbnail{URL: candidate.Avatar}
+			}
+			embeds = append(embeds, embed)
+		}
+		options = append(options, discordgo.SelectMenuOption{
+			Label:       fmt.Sprintf("%s (%s)", candidate.Name, candidate.World),
+			Value:       strconv.Itoa(candidate.LodestoneID),
+			Description: fmt.Sprintf("Lodestone ID %d", candidate.LodestoneID),
+		})
+	}
+
+	_, err := s.FollowupMessageCreate(i, true, &discordgo.WebhookParams{
+		Content: fmt.Sprintf(
+			"I found more than one `%s (%s)` on the Lodestone. Which one is yours?\nYou can also run `/clears` again with your Lodestone profile URL in the `lodestone` option.",
+			char.Name(),
+			char.World,
+		),
+		Embeds: embeds,
+		Components: []discordgo.MessageComponent{
+			discordgo.ActionsRow{
+				Components: []discordgo.MessageComponent{
+					discordgo.SelectMenu{
+						CustomID:    strings.Join([]string{LodestoneSelect, char.World, char.FirstName, char.LastName}, " "),
+						Placeholder: "Pick your character",
+						Options:     options,
+					},
+				},
+			},
+		},
+		Flags: discordgo.MessageFlagsEphemeral,
+	})
+	return err
+}
+
+// LodestoneSelectRespond carries on with /clears once the member has picked
+// their character.
+func (c *Clearingway) LodestoneSelectRespond(s *discordgo.Session, i *discordgo.InteractionCreate, command []string) {
+	g, ok := c.Guilds.Guilds[i.GuildID]
+	if !ok {
+		fmt.Printf("Interaction received from guild %s with no configuration!\n", i.GuildID)
+		return
+	}
+	values := i.MessageComponentData().Values
+	if len(command) != 4 || len(values) != 1 {
+		fmt.Printf("Invalid custom ID received: \"%v\"\n", strings.Join(command, " "))
+		return
+	}
+	lodestoneID, err := strconv.Atoi(values[0])
+	if err != nil {
+		fmt.Printf("Invalid Lodestone ID selected: %v\n", values[0])
+		return
+	}
+
+	err = discord.StartInteraction(s, i.Interaction, "Received your character...")
+	if err != nil {
+		fmt.Printf("Error sending Discord message: %v\n", err)
+		return
+	}
+
+	c.ClearsHelper(s, i, g, command[1], command[2], command[3], lodestoneID)
+}
+
+// useLodestoneID gives the character a Lodestone ID the member picked or
+// pasted, once the Lodestone confirms it is the same character.
+func useLodestoneID(char *ffxiv.Character, lodestoneID int, region lodestone.Region) error {
+	profile, err := lodestone.GetCharacterProfileByID(lodestoneID, region)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(profile.Name, char.Name()) || !strings.EqualFold(profile.World, char.World) {
+		return fmt.Errorf(
+			"Lodestone ID %d belongs to `%s (%s)`, not `%s (%s)`!",
+			lodestoneID,
+			profile.Name,
+			profile.World,
+			char.Name(),
+			char.World,
+		)
+	}
+
+	char.LodestoneID = lodestoneID
+	return nil
+}
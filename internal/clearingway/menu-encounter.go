@@ -57,12 +57,13 @@ func (m *Menu) MenuEncounterInit(es *Encounters, roleTypes []RoleType) {
 			}
 
 			menuRoleHelper := &MenuRoleHelper{
-				Role: role,
+				Role:          role,
+				Prerequisites: role.Prerequisites,
 			}
 
 			if additionalData.RequireClear {
-				if prereq, ok := encounter.Roles[ClearedRole]; ok {
-					menuRoleHelper.Prerequisite = prereq
+				if prereq, ok := encounter.Roles[ClearedRole]; ok && !slices.Contains(menuRoleHelper.Prerequisites, prereq) {
+					menuRoleHelper.Prerequisites = append(slices.Clone(menuRoleHelper.Prerequisites), prereq)
 				}
 			}
 
@@ -99,7 +100,8 @@ func (m *Menu) MenuEncounterInit(es *Encounters, roleTypes []RoleType) {
 		}
 
 		menuRoleHelper := &MenuRoleHelper{
-			Role: role,
+			Role:          role,
+			Prerequisites: role.Prerequisites,
 		}
 
 		dropdownSlice[extraRolesIndex].SelectMenuOptions = append(dropdownSlice[extraRolesIndex].SelectMenuOptions, dropdownOption)
@@ -317,12 +319,18 @@ func (c *Clearingway) MenuEncounterProcess(s *discordgo.Session, i *discordgo.In
 
 	// add/remove roles based on prereq met
 	for _, roleHelper := range rolesToAdd {
-		prereq := roleHelper.Prerequisite
-		if roleHelper.Prerequisite != nil {
-			if _, prereqMet := userRolesMap[prereq.DiscordRole.ID]; !prereqMet {
-				failedRoles = append(failedRoles, roleHelper.Prerequisite.DiscordRole.ID)
+		prereqsMet := true
+		for _, prereq := range roleHelper.Prerequisites {
+			if prereq.Skip || prereq.DiscordRole == nil {
 				continue
 			}
+			if _, prereqMet := userRolesMap[prereq.DiscordRole.ID]; !prereqMet {
+				failedRoles = append(failedRoles, prereq.DiscordRole.ID)
+				prereqsMet = false
+			}
+		}
+		if !prereqsMet {
+			continue
 		}
 
 		err := roleHelper.Role.AddToCharacter(i.GuildID, i.Member.User.ID, s)
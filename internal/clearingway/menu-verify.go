@@ -91,5 +91,5 @@ func (c *Clearingway) MenuVerifyProcess(s *discordgo.Session, i *discordgo.Inter
 	lastName = options.Components[1].(*discordgo.ActionsRow).Components[0].(*discordgo.TextInput).Value
 	world = options.Components[2].(*discordgo.ActionsRow).Components[0].(*discordgo.TextInput).Value
 
-	c.ClearsHelper(s, i, g, world, firstName, lastName)
+	c.ClearsHelper(s, i, g, world, firstName, lastName, 0)
 }
@@ -53,7 +53,7 @@ type Menu struct {
 
 type MenuRoleHelper struct {
 	Role            *Role
-	Prerequisite    *Role
+	Prerequisites   []*Role
 	DifficultyIndex int
 }
 
@@ -185,6 +185,9 @@ func (m *Menu) Init(c *ConfigMenu) {
 			if configRole.Mention {
 				role.Mention = true
 			}
+			if len(configRole.Requires) != 0 {
+				role.Requires = configRole.Requires
+			}
 			data.ExtraRoles = append(data.ExtraRoles, role)
 		}
 	}
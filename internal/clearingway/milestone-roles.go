@@ -0,0 +1,104 @@
+package clearingway
+
+import (
+	"fmt"
+	"sort"
+)
+
+// MilestoneRoles builds a ladder of roles for clearing an encounter a number
+// of times. Only the highest milestone reached applies, so climbing the
+// ladder swaps the previous milestone role for the next one.
+func MilestoneRoles(configMilestones []*ConfigMilestone, e *Encounter) (*Roles, error) {
+	milestones := make([]*ConfigMilestone, len(configMilestones))
+	copy(milestones, configMilestones)
+	sort.SliceStable(milestones, func(i, j int) bool { return milestones[i].Kills < milestones[j].Kills })
+
+	roles := &Roles{Roles: []*Role{}}
+	for i, milestone := range milestones {
+		if milestone.Kills <= 0 {
+			return nil, fmt.Errorf("Milestones must require at least one kill")
+		}
+		if i > 0 && milestones[i-1].Kills == milestone.Kills {
+			return nil, fmt.Errorf("More than one milestone requires %d kills", milestone.Kills)
+		}
+
+		kills := milestone.Kills
+		nextKills := 0
+		if i+1 < len(milestones) {
+			nextKills = milestones[i+1].Kills
+		}
+
+		role := &Role{
+			Name:        fmt.Sprintf("%s-%d Clears", e.Name, kills),
+			Color:       0x11806a,
+			Type:        MilestoneRole,
+			Encounter:   e,
+			Description: fmt.Sprintf("Cleared %s at least %d times.", e.Name, kills),
+		}
+		if kills == 1 {
+			role.Name = e.Name + "-1 Clear"
+			role.Description = fmt.Sprintf("Cleared %s at least once.", e.Name)
+		}
+		applyConfigRole(role, milestone.ConfigRole)
+
+		role.ShouldApply = func(opts *ShouldApplyOpts) (bool, string) {
+			totalKills := e.TotalKills(opts.Rankings)
+
+			if totalKills < kills {
+				return false, fmt.Sprintf("Cleared `%v` **%v** times, fewer than **%v**.", e.Name, totalKills, kills)
+			}
+			if nextKills != 0 && totalKills >= nextKills {
+				return false, fmt.Sprintf("Cleared `%v` **%v** times, reaching the **%v** clear milestone.", e.Name, totalKills, nextKills)
+			}
+
+			return true, fmt.Sprintf("Cleared `%v` **%v** times, reaching the **%v** clear milestone.", e.Name, totalKills, kills)
+		}
+
+		roles.Roles = append(roles.Roles, role)
+	}
+
+	return roles, nil
+}
+
+// PassedMilestoneRoles takes the milestone roles a member has climbed past,
+// every rung below one about to apply, out of the roles to remove. They are
+// removed even if the guild otherwise skips removal, since only the highest
+// milestone reached is kept.
+func (g *Guild) PassedMilestoneRoles(
+	memberRoleIds []string,
+	rolesToApply []*pendingRole,
+	rolesToRemove []*pendingRole,
+) ([]*pendingRole, []*pendingRole) {
+	passed := map[*Role]bool{}
+	for _, e := range g.Encounters.Encounters {
+		if e.MilestoneRoles == nil {
+			continue
+		}
+		highest := 0
+		for i, role := range e.MilestoneRoles.Roles {
+			for _, p := range rolesToApply {
+				if p.role == role {
+					highest = i
+				}
+			}
+		}
+		for _, role := range e.MilestoneRoles.Roles[:highest] {
+			passed[role] = true
+		}
+	}
+
+	remaining := []*pendingRole{}
+	milestoneToRemove := []*pendingRole{}
+	for _, p := range rolesToRemove {
+		if !passed[p.role] {
+			remaining = append(remaining, p)
+			continue
+		}
+		if p.role.Skip || !p.role.PresentInRoles(memberRoleIds) {
+			continue
+		}
+		milestoneToRemove = append(milestoneToRemove, p)
+	}
+
+	return remaining, milestoneToRemove
+}
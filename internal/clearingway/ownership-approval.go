@@ -0,0 +1,318 @@
+package clearingway
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Veraticus/clearingway/internal/ffxiv"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// OwnershipApproval prefixes the custom IDs of the buttons members use to ask
+// for approval and moderators use to approve or deny ownership claims, in the
+// format [OwnershipApproval] [request|approve|deny] [Discord ID] [Lodestone ID].
+const OwnershipApproval = "ownershipApproval"
+
+// approvalOwnership asks the guild's moderators to approve the claim in an
+// approval channel, for members who cannot prove it any other way. Members
+// have to ask for it with the button sent with their instructions, so that
+// moderators only see claims members chose to send them.
+type approvalOwnership struct {
+	channelId string
+
+	mu       sync.Mutex
+	pending  map[string]*OwnershipClaim
+	approved map[string]bool
+}
+
+func (*approvalOwnership) Name() string {
+	return "approval"
+}
+
+// storedApproval is the approval state as it is saved. Pending claims only
+// keep what the moderators' decision needs.
+type storedApproval struct {
+	Pending  map[string]*storedClaim `json:"pending"`
+	Approved []string                `json:"approved"`
+}
+
+type storedClaim struct {
+	DiscordId   string `json:"discordId"`
+	LodestoneID int    `json:"lodestoneId"`
+	FirstName   string `json:"firstName"`
+	LastName    string `json:"lastName"`
+	World       string `json:"world"`
+}
+
+func (ao *approvalOwnership) snapshot() any {
+	ao.mu.Lock()
+	defer ao.mu.Unlock()
+
+	stored := &storedApproval{Pending: map[string]*storedClaim{}, Approved: []string{}}
+	for claimKey, claim := range ao.pending {
+		stored.Pending[claimKey] = &storedClaim{
+			DiscordId:   claim.DiscordId,
+			LodestoneID: claim.Character.LodestoneID,
+			FirstName:   claim.Character.FirstName,
+			LastName:    claim.Character.LastName,
+			World:       claim.Character.World,
+		}
+	}
+	for claimKey := range ao.approved {
+		stored.Approved = append(stored.Approved, claimKey)
+	}
+	sort.Strings(stored.Approved)
+	return stored
+}
+
+func (ao *approvalOwnership) restore(raw json.RawMessage) error {
+	stored := &storedApproval{}
+	err := json.Unmarshal(raw, stored)
+	if err != nil {
+		return err
+	}
+
+	ao.mu.Lock()
+	defer ao.mu.Unlock()
+
+	for claimKey, claim := range stored.Pending {
+		ao.pending[claimKey] = &OwnershipClaim{
+			DiscordId: claim.DiscordId,
+			Character: &ffxiv.Character{
+				FirstName:   claim.FirstName,
+				LastName:    claim.LastName,
+				World:       claim.World,
+				LodestoneID: claim.LodestoneID,
+			},
+		}
+	}
+	for _, claimKey := range stored.Approved {
+		ao.approved[claimKey] = true
+	}
+	return nil
+}
+
+func (ao *approvalOwnership) Verify(claim *OwnershipClaim) bool {
+	ao.mu.Lock()
+	defer ao.mu.Unlock()
+
+	return ao.approved[claim.key()]
+}
+
+func (ao *approvalOwnership) Challenge(_ *Clearingway, _ *Guild, claim *OwnershipClaim) (string, error) {
+	ao.mu.Lock()
+	defer ao.mu.Unlock()
+
+	if _, ok := ao.pending[claim.key()]; ok {
+		return "Wait for a moderator to approve your claim, then try again.", nil
+	}
+	return "Press **Ask a moderator** below to have a moderator approve your claim, then try again once they have.", nil
+}
+
+// request sends the claim to the approval channel for moderators to decide.
+func (ao *approvalOwnership) request(c *Clearingway, claim *OwnershipClaim) (string, error) {
+	ao.mu.Lock()
+	defer ao.mu.Unlock()
+
+	message := "A moderator has been asked to approve your claim. You will get a DM once they decide."
+	if _, ok := ao.pending[claim.key()]; ok {
+		return message, nil
+	}
+
+	customID := func(decision string) string {
+		return strings.Join([]string{OwnershipApproval, decision, claim.DiscordId, fmt.Sprintf("%d", claim.Character.LodestoneID)}, " ")
+	}
+	_, err := c.Discord.Session.ChannelMessageSendComplex(ao.channelId, &discordgo.MessageSend{
+		Content: fmt.Sprintf(
+			"<@%s> claims to own `%s (%s)`: %s",
+			claim.DiscordId,
+			claim.Character.Name(),
+			claim.Character.World,
+			claim.Region.CharacterURL(claim.Character.LodestoneID),
+		),
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+		Components: []discordgo.MessageComponent{
+			discordgo.ActionsRow{
+				Components: []discordgo.MessageComponent{
+					discordgo.Button{Label: "Approve", Style: discordgo.SuccessButton, CustomID: customID("approve")},
+					discordgo.Button{Label: "Deny", Style: discordgo.DangerButton, CustomID: customID("deny")},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("Could not ask moderators to approve your claim: %w", err)
+	}
+	ao.pending[claim.key()] = claim
+
+	return message, nil
+}
+
+// decide approves or denies a pending claim, returning it if there was one.
+func (ao *approvalOwnership) decide(key string, approve bool) *OwnershipClaim {
+	ao.mu.Lock()
+	defer ao.mu.Unlock()
+
+	claim, ok := ao.pending[key]
+	if !ok {
+		return nil
+	}
+	delete(ao.pending, key)
+	if approve {
+		ao.approved[key] = true
+	}
+	return claim
+}
+
+// OwnershipApprovalRespond handles a moderator pressing approve or deny on an
+// ownership claim.
+func (c *Clearingway) OwnershipApprovalRespond(s *discordgo.Session, i *discordgo.InteractionCreate, command []string) {
+	g, ok := c.Guilds.Guilds[i.GuildID]
+	if !ok {
+		fmt.Printf("Interaction received from guild %s with no configuration!\n", i.GuildID)
+		return
+	}
+	if len(command) != 4 {
+		fmt.Printf("Invalid custom ID received: \"%v\"\n", strings.Join(command, " "))
+		return
+	}
+	strategy, ok := g.Ownership.Strategy("approval").(*approvalOwnership)
+	if !ok {
+		fmt.Printf("Ownership approval received in %s, which does not use it!\n", g.Name)
+		return
+	}
+
+	respond := func(message string) {
+		err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{Content: message, Flags: discordgo.MessageFlagsEphemeral},
+		})
+		if err != nil {
+			fmt.Printf("Error sending Discord message: %v\n", err)
+		}
+	}
+	if command[1] == "request" && i.Member != nil {
+		respond(c.requestOwnershipApproval(g, strategy, i.Member.User.ID, command))
+		return
+	}
+	if i.Member == nil || i.Member.Permissions&discordgo.PermissionManageRoles == 0 {
+		respond("Only moderators who can manage roles can approve ownership claims.")
+		return
+	}
+
+	approve := command[1] == "approve"
+	discordId := command[2]
+	claim := strategy.decide(discordId+"-"+command[3], approve)
+	if claim == nil {
+		respond("This claim has already been decided.")
+		return
+	}
+	g.Ownership.save()
+
+	decision := "denied"
+	if approve {
+		decision = "approved"
+	}
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Content: fmt.Sprintf(
+				"<@%s>'s claim to own `%s (%s)` was %s by <@%s>.",
+				discordId,
+				claim.Character.Name(),
+				claim.Character.World,
+				decision,
+				i.Member.User.ID,
+			),
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+			Components:      []discordgo.MessageComponent{},
+		},
+	})
+	if err != nil {
+		fmt.Printf("Error updating ownership approval: %v\n", err)
+	}
+
+	channel, err := s.UserChannelCreate(discordId)
+	if err != nil {
+		fmt.Printf("Could not open DM with %s: %v\n", discordId, err)
+		return
+	}
+	message := fmt.Sprintf("A moderator in **%s** denied your claim to own `%s (%s)`.", g.Name, claim.Character.Name(), claim.Character.World)
+	if approve {
+		message = fmt.Sprintf(
+			"A moderator in **%s** approved your claim to own `%s (%s)`. Run `/clears` again to get your roles.",
+			g.Name,
+			claim.Character.Name(),
+			claim.Character.World,
+		)
+	}
+	_, err = s.ChannelMessageSend(channel.ID, message)
+	if err != nil {
+		fmt.Printf("Could not send DM to %s: %v\n", discordId, err)
+	}
+}
+
+// requestOwnershipApproval asks the moderators to approve a member's claim
+// when they press the button sent with their instructions, returning what to
+// tell them.
+func (c *Clearingway) requestOwnershipApproval(g *Guild, strategy *approvalOwnership, discordId string, command []string) string {
+	if discordId != command[2] {
+		return "Only the member who made this claim can ask for it to be approved."
+	}
+	lodestoneID, err := strconv.Atoi(command[3])
+	if err != nil {
+		fmt.Printf("Invalid Lodestone ID in custom ID: \"%v\"\n", strings.Join(command, " "))
+		return "I could not read this claim. Please run `/clears` again."
+	}
+	char := g.Characters.ByLodestoneID(lodestoneID)
+	if char == nil {
+		return "I no longer know this character. Please run `/clears` again."
+	}
+
+	claim := &OwnershipClaim{Character: char, DiscordId: discordId, Region: g.LodestoneRegionFor(char)}
+	if g.Ownership.IsVerified(claim) {
+		return "You are already verified! Run `/clears` again to get your roles."
+	}
+	message, err := strategy.request(c, claim)
+	if err != nil {
+		return err.Error()
+	}
+	g.Ownership.save()
+	return message
+}
+
+// SendOwnershipInstructions tells the member how to prove they own the
+// character. If the guild accepts moderator approval, the instructions come
+// with a button to ask for it.
+func SendOwnershipInstructions(s *discordgo.Session, i *discordgo.Interaction, g *Guild, char *ffxiv.Character, instructions string) error {
+	params := &discordgo.WebhookParams{
+		Content: fmt.Sprintf(
+			"I could not verify your ownership of `%s (%s)`!\nIf this is your character, prove it like this:\n\n%s",
+			char.Name(),
+			char.World,
+			instructions,
+		),
+		Flags: discordgo.MessageFlagsEphemeral,
+	}
+	if g.Ownership.Strategy("approval") != nil {
+		params.Components = []discordgo.MessageComponent{
+			discordgo.ActionsRow{
+				Components: []discordgo.MessageComponent{
+					discordgo.Button{
+						Label:    "Ask a moderator",
+						Style:    discordgo.SecondaryButton,
+						CustomID: strings.Join([]string{OwnershipApproval, "request", i.Member.User.ID, strconv.Itoa(char.LodestoneID)}, " "),
+					},
+				},
+			},
+		}
+	}
+
+	_, err := s.FollowupMessageCreate(i, true, params)
+	return err
+}
@@ -0,0 +1,519 @@
+package clearingway
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math/big"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Veraticus/clearingway/internal/ffxiv"
+	"github.com/Veraticus/clearingway/internal/lodestone"
+)
+
+// DefaultOwnershipNonceExpiry is how long a bio code is valid for when the
+// guild does not say otherwise.
+var DefaultOwnershipNonceExpiry = time.Hour
+
+// OwnershipClaim is a Discord user claiming a character, with what the
+// Lodestone says about the character.
+type OwnershipClaim struct {
+	Character *ffxiv.Character
+	DiscordId string
+	Region    lodestone.Region
+	Profile   *lodestone.CharacterProfile
+}
+
+func (oc *OwnershipClaim) key() string {
+	return fmt.Sprintf("%s-%d", oc.DiscordId, oc.Character.LodestoneID)
+}
+
+// OwnershipStrategy is one way for a Discord user to prove they own a
+// character.
+type OwnershipStrategy interface {
+	// Name is how the strategy is configured.
+	Name() string
+	// Verify reports whether the claim has been proven.
+	Verify(claim *OwnershipClaim) bool
+	// Challenge tells the user how to prove the claim with this strategy,
+	// setting up whatever it needs to check later.
+	Challenge(c *Clearingway, guild *Guild, claim *OwnershipClaim) (string, error)
+}
+
+// persistentOwnership is a strategy with state of its own that has to
+// survive a restart, like the codes it has handed out.
+type persistentOwnership interface {
+	OwnershipStrategy
+	snapshot() any
+	restore(raw json.RawMessage) error
+}
+
+// Ownership is how a guild verifies that members own the characters they
+// claim, and the claims it has already verified. Once restored from a store,
+// verified claims and the strategies' state are saved to it whenever they
+// change.
+type Ownership struct {
+	Strategies []OwnershipStrategy
+	// Legacy strategies still verify claims but are never offered to
+	// members. Guilds that do not choose strategies keep accepting the bio
+	// code members used before strategies existed.
+	Legacy []OwnershipStrategy
+
+	mu       sync.Mutex
+	verified map[string]bool
+
+	saveMu sync.Mutex
+	store  *Store
+	key    string
+}
+
+// storedOwnership is the ownership state as it is saved, with each
+// strategy's own state under its name.
+type storedOwnership struct {
+	Verified   []string                   `json:"verified"`
+	Strategies map[string]json.RawMessage `json:"strategies"`
+}
+
+func (g *Guild) InitOwnership(c *ConfigOwnership) error {
+	g.Ownership = &Ownership{Strategies: []OwnershipStrategy{}, verified: map[string]bool{}}
+	if c == nil {
+		c = &ConfigOwnership{}
+	}
+
+	strategies := c.Strategies
+	if len(strategies) == 0 {
+		strategies = []string{"bio"}
+		g.Ownership.Legacy = []OwnershipStrategy{&slugOwnership{}}
+	}
+	expiry := DefaultOwnershipNonceExpiry
+	if len(c.NonceExpiry) != 0 {
+		var err error
+		expiry, err = time.ParseDuration(c.NonceExpiry)
+		if err != nil {
+			return fmt.Errorf("Could not parse ownership nonce expiry %s: %w", c.NonceExpiry, err)
+		}
+	}
+	for _, name := range strategies {
+		var strategy OwnershipStrategy
+		switch name {
+		case "bio":
+			strategy = &nonceOwnership{expiry: expiry, nonces: map[string]*ownershipNonce{}}
+		case "slug":
+			strategy = &slugOwnership{}
+		case "title":
+			// With a single title, a character already wearing it could
+			// not be asked for anything it does not already show.
+			distinct := map[string]bool{}
+			for _, title := range c.Titles {
+				distinct[strings.ToLower(title)] = true
+			}
+			if len(distinct) < 2 {
+				return fmt.Errorf("Ownership strategy title needs at least two different titles")
+			}
+			strategy = &titleOwnership{titles: c.Titles, expiry: expiry, challenges: map[string]*titleChallenge{}}
+		case "freeCompany":
+			if len(c.FreeCompanies) == 0 {
+				return fmt.Errorf("Ownership strategy freeCompany needs at least one free company")
+			}
+			strategy = &freeCompanyOwnership{freeCompanyIds: c.FreeCompanies}
+		case "approval":
+			if len(c.ApprovalChannelId) == 0 {
+				return fmt.Errorf("Ownership strategy approval needs an approval channel")
+			}
+			strategy = &approvalOwnership{channelId: c.ApprovalChannelId, pending: map[string]*OwnershipClaim{}, approved: map[string]bool{}}
+		default:
+			return fmt.Errorf("Unknown ownership strategy %s", name)
+		}
+		g.Ownership.Strategies = append(g.Ownership.Strategies, strategy)
+	}
+
+	return nil
+}
+
+// Strategy returns the guild's strategy with the given name, or nil.
+func (o *Ownership) Strategy(name string) OwnershipStrategy {
+	for _, strategy := range o.Strategies {
+		if strategy.Name() == name {
+			return strategy
+		}
+	}
+	return nil
+}
+
+func (o *Ownership) IsVerified(claim *OwnershipClaim) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.verified[claim.key()]
+}
+
+func (o *Ownership) setVerified(claim *OwnershipClaim) {
+	o.mu.Lock()
+	o.verified[claim.key()] = true
+	o.mu.Unlock()
+
+	o.save()
+}
+
+// Forget drops a verified claim, so it has to be proven again.
+func (o *Ownership) Forget(claim *OwnershipClaim) {
+	o.mu.Lock()
+	delete(o.verified, claim.key())
+	o.mu.Unlock()
+
+	o.save()
+}
+
+// Restore loads the verified claims and the strategies' state saved in the
+// store and saves them there from now on.
+func (o *Ownership) Restore(store *Store, key string) error {
+	stored := &storedOwnership{}
+	err := store.Load(key, stored)
+	if err != nil {
+		return err
+	}
+
+	for _, strategy := range o.Strategies {
+		persistent, ok := strategy.(persistentOwnership)
+		if !ok {
+			continue
+		}
+		raw, ok := stored.Strategies[strategy.Name()]
+		if !ok {
+			continue
+		}
+		err = persistent.restore(raw)
+		if err != nil {
+			return fmt.Errorf("Could not restore ownership strategy %s: %w", strategy.Name(), err)
+		}
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.store = store
+	o.key = key
+	for _, claimKey := range stored.Verified {
+		o.verified[claimKey] = true
+	}
+	return nil
+}
+
+// save writes the verified claims and the strategies' state to the store.
+func (o *Ownership) save() {
+	o.saveMu.Lock()
+	defer o.saveMu.Unlock()
+
+	o.mu.Lock()
+	store := o.store
+	stored := &storedOwnership{Verified: []string{}, Strategies: map[string]json.RawMessage{}}
+	for claimKey := range o.verified {
+		stored.Verified = append(stored.Verified, claimKey)
+	}
+	o.mu.Unlock()
+	if store == nil {
+		return
+	}
+	sort.Strings(stored.Verified)
+
+	for _, strategy := range o.Strategies {
+		persistent, ok := strategy.(persistentOwnership)
+		if !ok {
+			continue
+		}
+		raw, err := json.Marshal(persistent.snapshot())
+		if err != nil {
+			fmt.Printf("Could not marshal ownership strategy %s: %v\n", strategy.Name(), err)
+			continue
+		}
+		stored.Strategies[strategy.Name()] = raw
+	}
+	store.Save(o.key, stored)
+}
+
+// Link links a character the Discord user was verified to own. If
+// it was linked to someone else, their claim is forgotten, so they have to
+// prove it again to take it back.
+func (g *Guild) Link(char *ffxiv.Character, discordId string) {
+	previous := g.Characters.Link(char, discordId)
+	if len(previous) == 0 {
+		return
+	}
+	g.Ownership.Forget(&OwnershipClaim{Character: char, DiscordId: previous})
+}
+
+// VerifyOwnership checks whether the Discord user owns the character with any
+// of the guild's strategies. If not, it returns how to prove it with each of
+// them.
+func (c *Clearingway) VerifyOwnership(guild *Guild, char *ffxiv.Character, discordId string) (bool, string, error) {
+	claim := &OwnershipClaim{Character: char, DiscordId: discordId, Region: guild.LodestoneRegionFor(char)}
+	if guild.Ownership.IsVerified(claim) {
+		return true, "", nil
+	}
+
+	profile, err := lodestone.GetCharacterProfile(char, claim.Region)
+	if err != nil {
+		return false, "", err
+	}
+	claim.Profile = profile
+
+	for _, strategy := range append(slices.Clone(guild.Ownership.Strategies), guild.Ownership.Legacy...) {
+		if strategy.Verify(claim) {
+			fmt.Printf("Verified %s owns %s (%s) with %s.\n", discordId, char.Name(), char.World, strategy.Name())
+			guild.Ownership.setVerified(claim)
+			return true, "", nil
+		}
+	}
+
+	challenges := []string{}
+	for _, strategy := range guild.Ownership.Strategies {
+		challenge, err := strategy.Challenge(c, guild, claim)
+		if err != nil {
+			return false, "", err
+		}
+		challenges = append(challenges, challenge)
+	}
+	// Challenges hand out codes and titles that must still be known when
+	// the member comes back, even if Clearingway restarted in between.
+	guild.Ownership.save()
+
+	if len(challenges) == 1 {
+		return false, challenges[0], nil
+	}
+	return false, "Do any of the following and try again:\n\n⮕ " + strings.Join(challenges, "\n\n⮕ "), nil
+}
+
+type ownershipNonce struct {
+	code    string
+	expires time.Time
+}
+
+// nonceOwnership asks for a random code in the character's Lodestone bio.
+// Codes are only valid for a while, and only for the claim they were made
+// for.
+type nonceOwnership struct {
+	expiry time.Duration
+
+	mu     sync.Mutex
+	nonces map[string]*ownershipNonce
+}
+
+func (*nonceOwnership) Name() string {
+	return "bio"
+}
+
+type storedNonce struct {
+	Code    string    `json:"code"`
+	Expires time.Time `json:"expires"`
+}
+
+func (no *nonceOwnership) snapshot() any {
+	no.mu.Lock()
+	defer no.mu.Unlock()
+
+	stored := map[string]*storedNonce{}
+	for claimKey, nonce := range no.nonces {
+		if time.Now().After(nonce.expires) {
+			continue
+		}
+		stored[claimKey] = &storedNonce{Code: nonce.code, Expires: nonce.expires}
+	}
+	return stored
+}
+
+func (no *nonceOwnership) restore(raw json.RawMessage) error {
+	stored := map[string]*storedNonce{}
+	err := json.Unmarshal(raw, &stored)
+	if err != nil {
+		return err
+	}
+
+	no.mu.Lock()
+	defer no.mu.Unlock()
+
+	for claimKey, nonce := range stored {
+		no.nonces[claimKey] = &ownershipNonce{code: nonce.Code, expires: nonce.Expires}
+	}
+	return nil
+}
+
+func (no *nonceOwnership) Verify(claim *OwnershipClaim) bool {
+	no.mu.Lock()
+	defer no.mu.Unlock()
+
+	nonce, ok := no.nonces[claim.key()]
+	if !ok || time.Now().After(nonce.expires) {
+		return false
+	}
+	if !strings.Contains(claim.Profile.Bio, nonce.code) {
+		return false
+	}
+	delete(no.nonces, claim.key())
+	return true
+}
+
+func (no *nonceOwnership) Challenge(_ *Clearingway, _ *Guild, claim *OwnershipClaim) (string, error) {
+	no.mu.Lock()
+	defer no.mu.Unlock()
+
+	nonce, ok := no.nonces[claim.key()]
+	if !ok || time.Now().After(nonce.expires) {
+		b := make([]byte, 6)
+		_, err := rand.Read(b)
+		if err != nil {
+			return "", fmt.Errorf("Could not generate a verification code: %w", err)
+		}
+		nonce = &ownershipNonce{code: "clearingway-" + hex.EncodeToString(b), expires: time.Now().Add(no.expiry)}
+		no.nonces[claim.key()] = nonce
+	}
+
+	return fmt.Sprintf(
+		"Add the following code to your Lodestone profile before <t:%d:t>, then try again (you can remove it once you are verified):\n\n**%s**\n\nYou can edit your Lodestone profile at %s",
+		nonce.expires.Unix(),
+		nonce.code,
+		claim.Region.ProfileSettingsURL(),
+	), nil
+}
+
+// slugOwnership asks for a code derived from the Discord ID in the
+// character's Lodestone bio. Anyone can work out the code for anyone else, so
+// it is only for guilds that want the old behavior.
+type slugOwnership struct{}
+
+func (*slugOwnership) Name() string {
+	return "slug"
+}
+
+func (*slugOwnership) Verify(claim *OwnershipClaim) bool {
+	return strings.Contains(claim.Profile.Bio, claim.Character.LodestoneSlug(claim.DiscordId))
+}
+
+func (*slugOwnership) Challenge(_ *Clearingway, _ *Guild, claim *OwnershipClaim) (string, error) {
+	return fmt.Sprintf(
+		"Add the following code to your Lodestone profile and try again:\n\n**%s**\n\nYou can edit your Lodestone profile at %s",
+		claim.Character.LodestoneSlug(claim.DiscordId),
+		claim.Region.ProfileSettingsURL(),
+	), nil
+}
+
+type titleChallenge struct {
+	Title   string    `json:"title"`
+	Expires time.Time `json:"expires"`
+}
+
+// titleOwnership asks for the character to display a title picked at random
+// from the guild's list, which only whoever plays the character can change.
+// Like bio codes, titles are only asked for for a while.
+type titleOwnership struct {
+	titles []string
+	expiry time.Duration
+
+	mu         sync.Mutex
+	challenges map[string]*titleChallenge
+}
+
+func (*titleOwnership) Name() string {
+	return "title"
+}
+
+func (to *titleOwnership) snapshot() any {
+	to.mu.Lock()
+	defer to.mu.Unlock()
+
+	stored := map[string]*titleChallenge{}
+	for claimKey, challenge := range to.challenges {
+		if time.Now().After(challenge.Expires) {
+			continue
+		}
+		stored[claimKey] = challenge
+	}
+	return stored
+}
+
+func (to *titleOwnership) restore(raw json.RawMessage) error {
+	stored := map[string]*titleChallenge{}
+	err := json.Unmarshal(raw, &stored)
+	if err != nil {
+		return err
+	}
+
+	to.mu.Lock()
+	defer to.mu.Unlock()
+
+	maps.Copy(to.challenges, stored)
+	return nil
+}
+
+func (to *titleOwnership) Verify(claim *OwnershipClaim) bool {
+	to.mu.Lock()
+	defer to.mu.Unlock()
+
+	challenge, ok := to.challenges[claim.key()]
+	if !ok || time.Now().After(challenge.Expires) {
+		return false
+	}
+	if !strings.EqualFold(claim.Profile.Title, challenge.Title) {
+		return false
+	}
+	delete(to.challenges, claim.key())
+	return true
+}
+
+func (to *titleOwnership) Challenge(_ *Clearingway, _ *Guild, claim *OwnershipClaim) (string, error) {
+	to.mu.Lock()
+	defer to.mu.Unlock()
+
+	challenge, ok := to.challenges[claim.key()]
+	if !ok || time.Now().After(challenge.Expires) {
+		// The character's current title would prove nothing.
+		titles := slices.DeleteFunc(slices.Clone(to.titles), func(t string) bool {
+			return strings.EqualFold(t, claim.Profile.Title)
+		})
+		if len(titles) == 0 {
+			return "", fmt.Errorf(
+				"Your character already shows the only title that could be asked for, so it cannot prove you own it. Please verify another way.",
+			)
+		}
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(titles))))
+		if err != nil {
+			return "", fmt.Errorf("Could not pick a title: %w", err)
+		}
+		challenge = &titleChallenge{Title: titles[n.Int64()], Expires: time.Now().Add(to.expiry)}
+		to.challenges[claim.key()] = challenge
+	}
+
+	return fmt.Sprintf(
+		"Set your character's title to **%s** in game before <t:%d:t>, wait for the Lodestone to update, and try again.",
+		challenge.Title,
+		challenge.Expires.Unix(),
+	), nil
+}
+
+// freeCompanyOwnership trusts members of the guild's own free companies.
+// It shows the character is in one of them, not who plays it, so it is
+// meant for servers whose free companies vet their members.
+type freeCompanyOwnership struct {
+	freeCompanyIds []string
+}
+
+func (*freeCompanyOwnership) Name() string {
+	return "freeCompany"
+}
+
+func (fo *freeCompanyOwnership) Verify(claim *OwnershipClaim) bool {
+	return len(claim.Profile.FreeCompanyID) != 0 && slices.Contains(fo.freeCompanyIds, claim.Profile.FreeCompanyID)
+}
+
+func (fo *freeCompanyOwnership) Challenge(_ *Clearingway, _ *Guild, claim *OwnershipClaim) (string, error) {
+	return fmt.Sprintf(
+		"Join one of this server's free companies with `%s (%s)` and try again.",
+		claim.Character.Name(),
+		claim.Character.World,
+	), nil
+}
@@ -0,0 +1,149 @@
+package clearingway
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Veraticus/clearingway/internal/ffxiv"
+	"github.com/Veraticus/clearingway/internal/lodestone"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInitOwnership(t *testing.T) {
+	g := &Guild{}
+	assert.NoError(t, g.InitOwnership(nil))
+	assert.Len(t, g.Ownership.Strategies, 1)
+	assert.Equal(t, "bio", g.Ownership.Strategies[0].Name())
+	// The old bio code keeps working, but is not offered.
+	assert.Len(t, g.Ownership.Legacy, 1)
+	assert.Equal(t, "slug", g.Ownership.Legacy[0].Name())
+
+	err := g.InitOwnership(&ConfigOwnership{Strategies: []string{"title"}})
+	assert.EqualError(t, err, "Ownership strategy title needs at least two different titles")
+	err = g.InitOwnership(&ConfigOwnership{Strategies: []string{"title"}, Titles: []string{"The Sworn", "the sworn"}})
+	assert.EqualError(t, err, "Ownership strategy title needs at least two different titles")
+
+	err = g.InitOwnership(&ConfigOwnership{Strategies: []string{"bio", "nope"}})
+	assert.EqualError(t, err, "Unknown ownership strategy nope")
+}
+
+func TestOwnershipStrategies(t *testing.T) {
+	claim := &OwnershipClaim{
+		Character: &ffxiv.Character{FirstName: "Test", LastName: "Character", World: "Gilgamesh", LodestoneID: 1},
+		DiscordId: "123",
+		Region:    lodestone.RegionNA,
+		Profile:   &lodestone.CharacterProfile{Bio: "Hello!", Title: "Hero of the Day", FreeCompanyID: "42"},
+	}
+
+	nonce := &nonceOwnership{expiry: DefaultOwnershipNonceExpiry, nonces: map[string]*ownershipNonce{}}
+	assert.False(t, nonce.Verify(claim))
+	_, err := nonce.Challenge(nil, nil, claim)
+	assert.NoError(t, err)
+	code := nonce.nonces[claim.key()].code
+	assert.True(t, strings.HasPrefix(code, "clearingway-"))
+	claim.Profile.Bio = "Hello! " + code
+	assert.True(t, nonce.Verify(claim))
+	// Codes can only be used once.
+	assert.False(t, nonce.Verify(claim))
+
+	title := &titleOwnership{
+		titles:     []string{"Hero of the Day", "The Sworn"},
+		expiry:     DefaultOwnershipNonceExpiry,
+		challenges: map[string]*titleChallenge{},
+	}
+	assert.False(t, title.Verify(claim))
+	_, err = title.Challenge(nil, nil, claim)
+	assert.NoError(t, err)
+	// The character's current title is never the challenge.
+	assert.Equal(t, "The Sworn", title.challenges[claim.key()].Title)
+	claim.Profile.Title = "the sworn"
+	assert.True(t, title.Verify(claim))
+
+	// Titles expire like codes.
+	_, err = title.Challenge(nil, nil, claim)
+	assert.NoError(t, err)
+	title.challenges[claim.key()].Expires = time.Now().Add(-time.Minute)
+	claim.Profile.Title = title.challenges[claim.key()].Title
+	assert.False(t, title.Verify(claim))
+	assert.Empty(t, title.snapshot())
+
+	fc := &freeCompanyOwnership{freeCompanyIds: []string{"42"}}
+	assert.True(t, fc.Verify(claim))
+	claim.Profile.FreeCompanyID = ""
+	assert.False(t, fc.Verify(claim))
+}
+
+func TestTitleOwnershipCurrentTitle(t *testing.T) {
+	claim := &OwnershipClaim{
+		Character: &ffxiv.Character{FirstName: "Test", LastName: "Character", World: "Gilgamesh", LodestoneID: 1},
+		DiscordId: "123",
+		Region:    lodestone.RegionNA,
+		Profile:   &lodestone.CharacterProfile{Title: "The Sworn"},
+	}
+
+	// A title the character already shows proves nothing, so it is never
+	// handed out, even when it is the only one configured.
+	title := &titleOwnership{
+		titles:     []string{"The Sworn", "the sworn"},
+		expiry:     DefaultOwnershipNonceExpiry,
+		challenges: map[string]*titleChallenge{},
+	}
+	_, err := title.Challenge(nil, nil, claim)
+	assert.Error(t, err)
+	assert.Empty(t, title.challenges)
+	assert.False(t, title.Verify(claim))
+}
+
+func TestOwnershipRestore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	claim := &OwnershipClaim{
+		Character: &ffxiv.Character{FirstName: "Test", LastName: "Character", World: "Gilgamesh", LodestoneID: 1},
+		DiscordId: "123",
+		Region:    lodestone.RegionNA,
+		Profile:   &lodestone.CharacterProfile{},
+	}
+	verified := &OwnershipClaim{Character: &ffxiv.Character{LodestoneID: 2}, DiscordId: "123"}
+
+	store, err := OpenStore(path)
+	assert.NoError(t, err)
+	g := &Guild{}
+	assert.NoError(t, g.InitOwnership(nil))
+	assert.NoError(t, g.Ownership.Restore(store, "guild/ownership"))
+	_, err = g.Ownership.Strategies[0].(*nonceOwnership).Challenge(nil, g, claim)
+	assert.NoError(t, err)
+	g.Ownership.setVerified(verified)
+	code := g.Ownership.Strategies[0].(*nonceOwnership).nonces[claim.key()].code
+
+	// After a restart, verified claims stay verified and codes handed out
+	// before it still work.
+	store, err = OpenStore(path)
+	assert.NoError(t, err)
+	restarted := &Guild{}
+	assert.NoError(t, restarted.InitOwnership(nil))
+	assert.NoError(t, restarted.Ownership.Restore(store, "guild/ownership"))
+	assert.True(t, restarted.Ownership.IsVerified(verified))
+	claim.Profile.Bio = code
+	assert.True(t, restarted.Ownership.Strategies[0].Verify(claim))
+}
+
+func TestLinkForgetsPreviousOwner(t *testing.T) {
+	g := &Guild{Characters: &ffxiv.Characters{Characters: map[string]*ffxiv.Character{}}}
+	assert.NoError(t, g.InitOwnership(nil))
+	char, err := g.Characters.Init("Gilgamesh", "Test", "Character")
+	assert.NoError(t, err)
+	char.LodestoneID = 1
+	a := &OwnershipClaim{Character: char, DiscordId: "123"}
+	b := &OwnershipClaim{Character: char, DiscordId: "456"}
+
+	g.Ownership.setVerified(a)
+	g.Link(char, "123")
+	g.Ownership.setVerified(b)
+	g.Link(char, "456")
+
+	// The previous owner has to prove it again to take the character back.
+	assert.False(t, g.Ownership.IsVerified(a))
+	assert.True(t, g.Ownership.IsVerified(b))
+	assert.Equal(t, "456", char.DiscordId)
+}
@@ -0,0 +1,70 @@
+package clearingway
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/Veraticus/clearingway/internal/fflogs"
+)
+
+// PartitionRoleForEncounter builds a role that applies when the encounter was
+// cleared in one of the given FF Logs partitions, like the launch patch or the
+// partitions from before the echo was added. The partitions must be among the
+// ones the encounter itself queries, since only those rankings are fetched.
+func PartitionRoleForEncounter(c *ConfigPartitionRole, e *Encounter) (*Role, error) {
+	if len(c.Name) == 0 {
+		return nil, fmt.Errorf("Partition roles must have a name")
+	}
+	if len(c.Partitions) == 0 {
+		return nil, fmt.Errorf("Partition roles must list at least one partition")
+	}
+	for _, partition := range c.Partitions {
+		if !slices.Contains(e.Partitions, partition) {
+			return nil, fmt.Errorf("Partition %d is not in the encounter's partitions %v", partition, e.Partitions)
+		}
+	}
+
+	partitionName := c.Name
+	partitions := c.Partitions
+
+	role := &Role{
+		Name:        e.Name + "-" + partitionName,
+		Color:       0x11806a,
+		Type:        PartitionRole,
+		Encounter:   e,
+		Description: fmt.Sprintf("Cleared %s (%s).", e.Name, partitionName),
+	}
+	applyConfigRole(role, c.ConfigRole)
+
+	role.ShouldApply = func(opts *ShouldApplyOpts) (bool, string) {
+		var firstRank *fflogs.Rank
+		for _, id := range e.Ids {
+			ranking, ok := opts.Rankings.Rankings[id]
+			if !ok {
+				continue
+			}
+			ranks := ranking.RanksInPartitions(partitions)
+			if len(ranks) == 0 {
+				continue
+			}
+			if firstRank == nil || ranks[0].StartTime < firstRank.StartTime {
+				firstRank = ranks[0]
+			}
+		}
+
+		if firstRank == nil {
+			return false, fmt.Sprintf("Did not clear %v (%v).", e.Name, partitionName)
+		}
+
+		return true, fmt.Sprintf(
+			"Cleared `%v` (%v):\n     `%v` on <t:%v:F> (%v).",
+			e.Name,
+			partitionName,
+			firstRank.Job.Abbreviation,
+			firstRank.UnixTime(),
+			firstRank.Report.Url(),
+		)
+	}
+
+	return role, nil
+}
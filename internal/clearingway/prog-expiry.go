@@ -0,0 +1,299 @@
+package clearingway
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProgGrant is the evidence behind the prog role a member holds for an
+// encounter: the role and when the fight that earned it happened.
+type ProgGrant struct {
+	Role         *Role
+	EvidenceTime time.Time
+}
+
+// ProgGrants tracks the prog roles members hold in a guild so they can
+// expire. Once restored from a store, they are saved to it whenever they
+// change.
+type ProgGrants struct {
+	mu     sync.Mutex
+	grants map[string]map[*Encounter]*ProgGrant
+
+	store *Store
+	key   string
+}
+
+func NewProgGrants() *ProgGrants {
+	return &ProgGrants{grants: map[string]map[*Encounter]*ProgGrant{}}
+}
+
+// storedProgGrant is a grant as it is saved, with its encounter and role by
+// name.
+type storedProgGrant struct {
+	Role         string    `json:"role"`
+	EvidenceTime time.Time `json:"evidenceTime"`
+}
+
+// Restore loads the grants saved in the store and saves them there from now
+// on. Grants for encounters or roles that are no longer configured are
+// dropped.
+func (pg *ProgGrants) Restore(store *Store, key string, encounters []*Encounter) error {
+	stored := map[string]map[string]*storedProgGrant{}
+	err := store.Load(key, &stored)
+	if err != nil {
+		return err
+	}
+
+	pg.mu.Lock()
+	defer pg.mu.Unlock()
+
+	pg.store = store
+	pg.key = key
+	for discordUserId, storedGrants := range stored {
+		for _, e := range encounters {
+			storedGrant, ok := storedGrants[e.Name]
+			if !ok || e.ProgRoles == nil {
+				continue
+			}
+			for _, role := range e.ProgRoles.Roles {
+				if role.Name != storedGrant.Role {
+					continue
+				}
+				if _, ok := pg.grants[discordUserId]; !ok {
+					pg.grants[discordUserId] = map[*Encounter]*ProgGrant{}
+				}
+				pg.grants[discordUserId][e] = &ProgGrant{Role: role, EvidenceTime: storedGrant.EvidenceTime}
+			}
+		}
+	}
+
+	return nil
+}
+
+// save writes the grants to the store. It must be called with the lock held.
+func (pg *ProgGrants) save() {
+	if pg.store == nil {
+		return
+	}
+	stored := map[string]map[string]*storedProgGrant{}
+	for discordUserId, grants := range pg.grants {
+		if len(grants) == 0 {
+			continue
+		}
+		stored[discordUserId] = map[string]*storedProgGrant{}
+		for e, grant := range grants {
+			stored[discordUserId][e.Name] = &storedProgGrant{Role: grant.Role.Name, EvidenceTime: grant.EvidenceTime}
+		}
+	}
+	pg.store.Save(pg.key, stored)
+}
+
+// Record stores the evidence for a member's prog role. Evidence older than
+// what is already recorded for the same role is ignored.
+func (pg *ProgGrants) Record(discordUserId string, e *Encounter, role *Role, evidenceTime time.Time) {
+	pg.mu.Lock()
+	defer pg.mu.Unlock()
+
+	grants, ok := pg.grants[discordUserId]
+	if !ok {
+		grants = map[*Encounter]*ProgGrant{}
+		pg.grants[discordUserId] = grants
+	}
+	existing, ok := grants[e]
+	if ok && existing.Role == role && existing.EvidenceTime.After(evidenceTime) {
+		return
+	}
+	grants[e] = &ProgGrant{Role: role, EvidenceTime: evidenceTime}
+	pg.save()
+}
+
+func (pg *ProgGrants) Get(discordUserId string, e *Encounter) *ProgGrant {
+	pg.mu.Lock()
+	defer pg.mu.Unlock()
+
+	return pg.grants[discordUserId][e]
+}
+
+func (pg *ProgGrants) Forget(discordUserId string, e *Encounter) {
+	pg.mu.Lock()
+	defer pg.mu.Unlock()
+
+	if _, ok := pg.grants[discordUserId][e]; !ok {
+		return
+	}
+	delete(pg.grants[discordUserId], e)
+	pg.save()
+}
+
+type staleProgGrant struct {
+	discordUserId string
+	encounter     *Encounter
+	grant         *ProgGrant
+}
+
+// Stale returns every grant whose evidence is older than its encounter's
+// prog expiry.
+func (pg *ProgGrants) Stale(now time.Time) []*staleProgGrant {
+	pg.mu.Lock()
+	defer pg.mu.Unlock()
+
+	stale := []*staleProgGrant{}
+	for discordUserId, grants := range pg.grants {
+		for e, grant := range grants {
+			if e.ProgExpiry == 0 || now.Sub(grant.EvidenceTime) <= e.ProgExpiry {
+				continue
+			}
+			stale = append(stale, &staleProgGrant{discordUserId: discordUserId, encounter: e, grant: grant})
+		}
+	}
+
+	return stale
+}
+
+// parseProgExpiry parses a duration like "720h", or a number of days like
+// "30d".
+func parseProgExpiry(s string) (time.Duration, error) {
+	var expiry time.Duration
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("Could not parse prog expiry %s: %w", s, err)
+		}
+		expiry = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		expiry, err = time.ParseDuration(s)
+		if err != nil {
+			return 0, fmt.Errorf("Could not parse prog expiry %s: %w", s, err)
+		}
+	}
+	if expiry <= 0 {
+		return 0, fmt.Errorf("Prog expiry %s must be positive", s)
+	}
+
+	return expiry, nil
+}
+
+// ProgExpiryString describes the encounter's prog expiry in days where it
+// can.
+func (e *Encounter) ProgExpiryString() string {
+	if e.ProgExpiry%(24*time.Hour) == 0 {
+		days := int(e.ProgExpiry / (24 * time.Hour))
+		if days == 1 {
+			return "1 day"
+		}
+		return fmt.Sprintf("%d days", days)
+	}
+	return e.ProgExpiry.String()
+}
+
+// SweepProgExpiry removes prog roles whose evidence has expired, or moves
+// them down one prog point for every expiry period that has passed if the
+// encounter downgrades instead.
+func (c *Clearingway) SweepProgExpiry(g *Guild) {
+	expiring := false
+	for _, e := range g.ProgEncounters() {
+		if e.ProgExpiry != 0 {
+			expiring = true
+		}
+	}
+	if !expiring {
+		return
+	}
+
+	now := time.Now()
+	c.adoptProgRoles(g, now)
+
+	for _, stale := range g.ProgGrants.Stale(now) {
+		e := stale.encounter
+		grant := stale.grant
+
+		err := grant.Role.RemoveFromCharacter(g.Id, stale.discordUserId, c.Discord.Session)
+		if err != nil {
+			fmt.Printf("Error removing expired prog role %s from %s: %v\n", grant.Role.Name, stale.discordUserId, err)
+			continue
+		}
+		fmt.Printf("Removed expired prog role %s from %s in %s.\n", grant.Role.Name, stale.discordUserId, g.Name)
+
+		ok, i := e.ProgRoles.IndexOfRole(grant.Role)
+		periods := int(now.Sub(grant.EvidenceTime) / e.ProgExpiry)
+		if !e.ProgExpiryDowngrade || !ok || i-periods < 0 {
+			g.ProgGrants.Forget(stale.discordUserId, e)
+			continue
+		}
+
+		lowerRole := e.ProgRoles.Roles[i-periods]
+		err = lowerRole.AddToCharacter(g.Id, stale.discordUserId, c.Discord.Session)
+		if err != nil {
+			fmt.Printf("Error downgrading prog role for %s to %s: %v\n", stale.discordUserId, lowerRole.Name, err)
+			g.ProgGrants.Forget(stale.discordUserId, e)
+			continue
+		}
+		fmt.Printf("Downgraded prog role for %s in %s to %s.\n", stale.discordUserId, g.Name, lowerRole.Name)
+		g.ProgGrants.Record(
+			stale.discordUserId,
+			e,
+			lowerRole,
+			grant.EvidenceTime.Add(time.Duration(periods)*e.ProgExpiry),
+		)
+	}
+}
+
+// CheckProgExpiry makes sure Clearingway can list the members of every guild
+// with prog expiry, which it needs to find the prog roles to expire. Listing
+// members needs the Server Members intent.
+func (c *Clearingway) CheckProgExpiry() error {
+	for _, g := range c.Guilds.Guilds {
+		for _, e := range g.ProgEncounters() {
+			if e.ProgExpiry == 0 {
+				continue
+			}
+			_, err := c.Discord.Session.GuildMembers(g.Id, "", 1)
+			if err != nil {
+				return fmt.Errorf("Prog expiry is configured in %s but its members cannot be listed (is the Server Members intent enabled?): %w", g.Name, err)
+			}
+			break
+		}
+	}
+
+	return nil
+}
+
+// adoptProgRoles brings the guild's grants in line with the prog roles
+// members actually hold. Roles given out before Clearingway started recording
+// them count from now, and grants for roles a member no longer holds are
+// dropped.
+func (c *Clearingway) adoptProgRoles(g *Guild, now time.Time) {
+	members, err := c.GuildMembers(g)
+	if err != nil {
+		fmt.Printf("Could not list members of %s to sweep prog roles: %v\n", g.Name, err)
+		return
+	}
+
+	for _, member := range members {
+		for _, e := range g.ProgEncounters() {
+			if e.ProgExpiry == 0 {
+				continue
+			}
+
+			var heldRole *Role
+			for _, role := range e.ProgRoles.Roles {
+				if !role.Skip && role.PresentInRoles(member.Roles) {
+					heldRole = role
+				}
+			}
+
+			if heldRole == nil {
+				g.ProgGrants.Forget(member.User.ID, e)
+				continue
+			}
+			grant := g.ProgGrants.Get(member.User.ID, e)
+			if grant == nil || grant.Role != heldRole {
+				g.ProgGrants.Record(member.User.ID, e, heldRole, now)
+			}
+		}
+	}
+}
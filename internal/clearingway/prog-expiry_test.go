@@ -0,0 +1,66 @@
+package clearingway
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseProgExpiry(t *testing.T) {
+	expiry, err := parseProgExpiry("30d")
+	assert.NoError(t, err)
+	assert.Equal(t, 30*24*time.Hour, expiry)
+
+	expiry, err = parseProgExpiry("36h")
+	assert.NoError(t, err)
+	assert.Equal(t, 36*time.Hour, expiry)
+
+	_, err = parseProgExpiry("-1d")
+	assert.EqualError(t, err, "Prog expiry -1d must be positive")
+}
+
+func TestProgGrantsStale(t *testing.T) {
+	now := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	expiring := &Encounter{Name: "TOP", ProgExpiry: 30 * 24 * time.Hour}
+	forever := &Encounter{Name: "FRU"}
+	p5 := &Role{Name: "TOP P5 Prog"}
+	p6 := &Role{Name: "TOP P6 Prog"}
+
+	pg := NewProgGrants()
+	pg.Record("1", expiring, p5, now.AddDate(0, 0, -40))
+	pg.Record("2", expiring, p5, now.AddDate(0, 0, -10))
+	pg.Record("3", forever, &Role{Name: "FRU P5 Prog"}, now.AddDate(-2, 0, 0))
+
+	stale := pg.Stale(now)
+	assert.Len(t, stale, 1)
+	assert.Equal(t, "1", stale[0].discordUserId)
+
+	// Older evidence for the same role does not replace newer evidence, but
+	// a new role always does.
+	pg.Record("2", expiring, p5, now.AddDate(0, 0, -50))
+	assert.Equal(t, now.AddDate(0, 0, -10), pg.Get("2", expiring).EvidenceTime)
+	pg.Record("2", expiring, p6, now.AddDate(0, 0, -50))
+	assert.Equal(t, p6, pg.Get("2", expiring).Role)
+}
+
+func TestProgGrantsRestore(t *testing.T) {
+	evidence := time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC)
+	p5 := &Role{Name: "TOP P5 Prog"}
+	e := &Encounter{Name: "TOP", ProgExpiry: 30 * 24 * time.Hour, ProgRoles: &Roles{Roles: []*Role{p5}}}
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	store, err := OpenStore(path)
+	assert.NoError(t, err)
+	pg := NewProgGrants()
+	assert.NoError(t, pg.Restore(store, "guild/progGrants", []*Encounter{e}))
+	pg.Record("1", e, p5, evidence)
+
+	// A restart reads the evidence back rather than starting it over.
+	store, err = OpenStore(path)
+	assert.NoError(t, err)
+	restored := NewProgGrants()
+	assert.NoError(t, restored.Restore(store, "guild/progGrants", []*Encounter{e}))
+	assert.Equal(t, &ProgGrant{Role: p5, EvidenceTime: evidence}, restored.Get("1", e))
+}
@@ -1,9 +1,12 @@
 package clearingway
 
 import (
+	"errors"
 	"fmt"
+	"slices"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/Veraticus/clearingway/internal/discord"
 	"github.com/Veraticus/clearingway/internal/fflogs"
@@ -15,6 +18,13 @@ import (
 	"golang.org/x/text/language"
 )
 
+// MaxProgReports is the most reports `/prog` analyzes at once.
+const MaxProgReports = 10
+
+// RecentProgReportsToSearch is how many of a character's most recent reports
+// are searched for relevant fights.
+const RecentProgReportsToSearch = 25
+
 func (c *Clearingway) Prog(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	g, ok := c.Guilds.Guilds[i.GuildID]
 	if !ok {
@@ -43,7 +53,11 @@ func (c *Clearingway) Prog(s *discordgo.Session, i *discordgo.InteractionCreate)
 	var world string
 	var firstName string
 	var lastName string
-	var reportId string
+	var reports string
+	var recent bool
+	var group bool
+	var watch bool
+	var summary bool
 
 	if option, ok := optionMap["world"]; ok {
 		world = option.StringValue()
@@ -55,11 +69,23 @@ func (c *Clearingway) Prog(s *discordgo.Session, i *discordgo.InteractionCreate)
 		lastName = option.StringValue()
 	}
 	if option, ok := optionMap["report-id"]; ok {
-		reportId = option.StringValue()
+		reports = option.StringValue()
+	}
+	if option, ok := optionMap["recent"]; ok {
+		recent = option.BoolValue()
+	}
+	if option, ok := optionMap["group"]; ok {
+		group = option.BoolValue()
+	}
+	if option, ok := optionMap["watch"]; ok {
+		watch = option.BoolValue()
+	}
+	if option, ok := optionMap["summary"]; ok {
+		summary = option.BoolValue()
 	}
 
-	if len(world) == 0 || len(firstName) == 0 || len(lastName) == 0 || len(reportId) == 0 {
-		err := discord.ContinueInteraction(s, i.Interaction, "`/prog` command failed! Make sure you input your world, first name, last name, and fflogs report URL or ID.")
+	if len(world) == 0 || len(firstName) == 0 || len(lastName) == 0 || (len(reports) == 0 && !recent) {
+		err := discord.ContinueInteraction(s, i.Interaction, "`/prog` command failed! Make sure you input your world, first name, last name, and fflogs report URLs or IDs (or search your recent reports).")
 		if err != nil {
 			fmt.Printf("Error sending Discord message: %v\n", err)
 		}
@@ -70,7 +96,23 @@ func (c *Clearingway) Prog(s *discordgo.Session, i *discordgo.InteractionCreate)
 	world = title.String(cleanWorld(world))
 	firstName = title.String(firstName)
 	lastName = title.String(lastName)
-	reportId = CleanReportId(reportId)
+	reportIds := CleanReportIds(reports)
+	if len(reportIds) > MaxProgReports {
+		err := discord.ContinueInteraction(s, i.Interaction,
+			fmt.Sprintf("`/prog` can analyze at most %d reports at once!", MaxProgReports),
+		)
+		if err != nil {
+			fmt.Printf("Error sending Discord message: %v\n", err)
+		}
+		return
+	}
+	if watch && (len(reportIds) != 1 || recent) {
+		err := discord.ContinueInteraction(s, i.Interaction, "`/prog` can only watch a single report at a time!")
+		if err != nil {
+			fmt.Printf("Error sending Discord message: %v\n", err)
+		}
+		return
+	}
 
 	if !ffxiv.IsWorld(world) {
 		err := discord.ContinueInteraction(s, i.Interaction,
@@ -110,7 +152,19 @@ func (c *Clearingway) Prog(s *discordgo.Session, i *discordgo.InteractionCreate)
 			fmt.Printf("Error sending Discord message: %v\n", err)
 			return
 		}
-		err = lodestone.SetCharacterLodestoneID(char)
+		err = lodestone.SetCharacterLodestoneID(char, g.LodestoneRegionFor(char))
+		if errors.Is(err, lodestone.ErrAmbiguous) {
+			err = discord.ContinueInteraction(s, i.Interaction,
+				fmt.Sprintf(
+					"More than one `%s (%s)` is on the Lodestone! Run `/clears` first to pick yours, then run `/prog` again.",
+					char.Name(),
+					char.World,
+				))
+			if err != nil {
+				fmt.Printf("Error sending Discord message: %v\n", err)
+			}
+			return
+		}
 		if err != nil {
 			err = discord.ContinueInteraction(s, i.Interaction,
 				fmt.Sprintf(
@@ -124,6 +178,11 @@ func (c *Clearingway) Prog(s *discordgo.Session, i *discordgo.InteractionCreate)
 		}
 	}
 
+	char, err = c.SyncCharacter(g, char)
+	if err != nil {
+		fmt.Printf("Could not sync %s (%s) with the Lodestone: %v\n", char.Name(), char.World, err)
+	}
+
 	err = discord.ContinueInteraction(s, i.Interaction,
 		fmt.Sprintf("Verifying ownership of `%s (%s)`...", char.Name(), char.World),
 	)
@@ -132,7 +191,7 @@ func (c *Clearingway) Prog(s *discordgo.Session, i *discordgo.InteractionCreate)
 	}
 
 	discordId := i.Member.User.ID
-	isOwner, err := lodestone.CharacterIsOwnedByDiscordUser(char, discordId)
+	isOwner, instructions, err := c.VerifyOwnership(g, char, discordId)
 	if err != nil {
 		err = discord.ContinueInteraction(s, i.Interaction, err.Error())
 		if err != nil {
@@ -141,12 +200,29 @@ func (c *Clearingway) Prog(s *discordgo.Session, i *discordgo.InteractionCreate)
 		return
 	}
 	if !isOwner {
+		err = SendOwnershipInstructions(s, i.Interaction, g, char, instructions)
+		if err != nil {
+			fmt.Printf("Error sending Discord message: %v\n", err)
+		}
+		return
+	}
+	g.Link(char, discordId)
+
+	if watch {
+		err = c.StartProgWatch(reportIds[0], char, g, group, i.ChannelID)
+		if err != nil {
+			err = discord.ContinueInteraction(s, i.Interaction, err.Error())
+			if err != nil {
+				fmt.Printf("Error sending Discord message: %v\n", err)
+			}
+			return
+		}
 		err = discord.ContinueInteraction(s, i.Interaction,
 			fmt.Sprintf(
-				"I could not verify your ownership of `%s (%s)`!\nIf this is your character, add the following code to your Lodestone profile and then run `/prog` again:\n\n**%s**\n\nYou can edit your Lodestone profile at https://na.finalfantasyxiv.com/lodestone/my/setting/profile/",
+				"Watching report `%s` for `%s (%s)`. Prog roles will update as new pulls are logged, and this channel will show the best pull so far.",
+				reportIds[0],
 				char.Name(),
 				char.World,
-				char.LodestoneSlug(discordId),
 			),
 		)
 		if err != nil {
@@ -155,8 +231,28 @@ func (c *Clearingway) Prog(s *discordgo.Session, i *discordgo.InteractionCreate)
 		return
 	}
 
+	if recent {
+		err = discord.ContinueInteraction(s, i.Interaction,
+			fmt.Sprintf("Searching recent reports for `%s (%s)`...", char.Name(), char.World),
+		)
+		if err != nil {
+			fmt.Printf("Error sending Discord message: %v\n", err)
+		}
+
+		reportIds, err = c.AddRecentReportIds(reportIds, char, g)
+		if err != nil {
+			err = discord.ContinueInteraction(s, i.Interaction,
+				fmt.Sprintf("Could not search recent reports for `%s (%s)`: %s", char.Name(), char.World, err),
+			)
+			if err != nil {
+				fmt.Printf("Error sending Discord message: %v\n", err)
+			}
+			return
+		}
+	}
+
 	err = discord.ContinueInteraction(s, i.Interaction,
-		fmt.Sprintf("Analyzing report for `%s (%s)`...", char.Name(), char.World),
+		fmt.Sprintf("Analyzing %d report(s) for `%s (%s)`...", len(reportIds), char.Name(), char.World),
 	)
 	if err != nil {
 		fmt.Printf("Error sending Discord message: %v\n", err)
@@ -172,7 +268,7 @@ func (c *Clearingway) Prog(s *discordgo.Session, i *discordgo.InteractionCreate)
 		return
 	}
 
-	roleTexts, err := c.UpdateProgForCharacterInGuild(reportId, char, i.Member.User.ID, g)
+	roleTexts, embeds, err := c.UpdateProgForCharacterInGuild(reportIds, char, i.Member.User.ID, g, group, summary)
 	if err != nil {
 		err = discord.ContinueInteraction(s, i.Interaction,
 			fmt.Sprintf("Could not analyze prog for `%s (%s)`: %s", char.Name(), char.World, err),
@@ -196,45 +292,160 @@ func (c *Clearingway) Prog(s *discordgo.Session, i *discordgo.InteractionCreate)
 			fmt.Printf("Error sending Discord message: %v\n", err)
 		}
 	}
+
+	err = discord.ContinueInteractionWithEmbeds(s, i.Interaction, embeds)
+	if err != nil {
+		fmt.Printf("Error sending Discord message: %v\n", err)
+	}
+}
+
+// AddRecentReportIds adds the character's most recent reports with fights
+// against the guild's encounters to reportIds, up to MaxProgReports reports.
+func (c *Clearingway) AddRecentReportIds(reportIds []string, char *ffxiv.Character, guild *Guild) ([]string, error) {
+	recentReportIds, err := c.Fflogs.GetRecentReportsForCharacter(progRankingsToGet(guild), char, RecentProgReportsToSearch)
+	if err != nil {
+		return nil, fmt.Errorf("Error retrieving recent reports: %w", err)
+	}
+
+	for _, reportId := range recentReportIds {
+		if len(reportIds) >= MaxProgReports {
+			break
+		}
+		if !slices.Contains(reportIds, reportId) {
+			reportIds = append(reportIds, reportId)
+		}
+	}
+	if len(reportIds) == 0 {
+		return nil, fmt.Errorf("No recent public reports with relevant fights found on FF Logs!")
+	}
+
+	return reportIds, nil
 }
 
+func progRankingsToGet(guild *Guild) []*fflogs.RankingToGet {
+	rankingsToGet := []*fflogs.RankingToGet{}
+	for _, encounter := range guild.AllEncounters() {
+		rankingsToGet = append(rankingsToGet, &fflogs.RankingToGet{IDs: encounter.Ids, Difficulty: encounter.DifficultyInt()})
+	}
+	return rankingsToGet
+}
+
+// UpdateProgForCharacterInGuild applies prog roles for the furthest point the
+// character reached across all of the given reports. Reports that cannot be
+// analyzed are mentioned in the returned text and otherwise skipped. With
+// group set, other verified members in the same pulls are updated too. The
+// returned embeds show the furthest prog point reached in each encounter and,
+// with summary set, a summary of the pulls.
 func (c *Clearingway) UpdateProgForCharacterInGuild(
-	reportId string,
+	reportIds []string,
 	char *ffxiv.Character,
 	discordUserId string,
 	guild *Guild,
-) ([]string, error) {
-	rankingsToGet := []*fflogs.RankingToGet{}
-	for _, encounter := range guild.AllEncounters() {
-		rankingsToGet = append(rankingsToGet, &fflogs.RankingToGet{IDs: encounter.Ids, Difficulty: encounter.DifficultyInt()})
+	group bool,
+	summary bool,
+) ([]string, []*discordgo.MessageEmbed, error) {
+	text := []string{}
+	rankingsToGet := progRankingsToGet(guild)
+	reports := []*fflogs.ProgReport{}
+	fights := &fflogs.Fights{Fights: []*fflogs.Fight{}}
+	unchecked := []string{}
+	var lastErr error
+	for _, reportId := range reportIds {
+		report, err := c.Fflogs.GetProgReport(reportId)
+		if err == nil {
+			var reportFights *fflogs.Fights
+			reportFights, err = report.FightsForCharacter(rankingsToGet, char)
+			if err == nil {
+				reports = append(reports, report)
+				if !report.ChecksLodestoneID(char) {
+					unchecked = append(unchecked, "`"+reportId+"`")
+				}
+				for _, fight := range reportFights.Fights {
+					fights.Add(fight)
+				}
+			}
+		}
+		if err != nil {
+			lastErr = err
+			text = append(text, fmt.Sprintf("Could not analyze report `%s`: %s", reportId, err))
+		}
 	}
-	fights, err := c.Fflogs.GetProgForReport(reportId, rankingsToGet, char)
-	if err != nil {
-		return nil, fmt.Errorf("Error retrieving prog: %w", err)
+	if lastErr != nil && len(fights.Fights) == 0 {
+		return nil, nil, fmt.Errorf("Error retrieving prog: %w", lastErr)
+	}
+	if len(unchecked) != 0 {
+		text = append(text, fmt.Sprintf(
+			"`%s (%s)` has no rankings in report(s) %s (for example because they only have wipes), so FF Logs could not confirm it is your verified character. It was matched by name and world only.",
+			char.Name(),
+			char.World,
+			strings.Join(unchecked, ", "),
+		))
 	}
 
 	fmt.Printf("Found the following relevant fights for %s (%s)...\n", char.Name(), char.World)
-	for _, e := range guild.Encounters.Encounters {
-	    fmt.Printf("Processing encounter: %s\n", e.Name)
-	    
-	    // Add this logging:
-	    if e.ProgRoles != nil {
-	        fmt.Printf("  Encounter has ProgRoles initialized with %d roles\n", len(e.ProgRoles.Roles))
-	        for i, role := range e.ProgRoles.Roles {
-	            fmt.Printf("    Prog role [%d]: %s\n", i, role.Name)
-	        }
-	    } else {
-	        fmt.Printf("  Encounter has NO ProgRoles initialized\n")
-	    }
-	    
-	    for _, r := range e.Fights(fights) {
-	        fmt.Printf("  %+v\n", r)
-	    }
+	for _, e := range guild.ProgEncounters() {
+		fmt.Printf("%s (%d):\n", e.Name, len(e.Fights(fights)))
+		for _, r := range e.Fights(fights) {
+			fmt.Printf("  %+v\n", r)
+		}
+	}
+
+	progText, _, err := c.ApplyProgForMember(fights, char, discordUserId, guild)
+	if err != nil {
+		return nil, nil, err
+	}
+	text = append(text, progText...)
+
+	if group {
+		if !guild.GroupProgEnabled {
+			text = append(text, "Group prog is not enabled in this server, so only your prog was updated.")
+		} else {
+			text = append(text, c.ApplyGroupProg(reports, fights, char, guild)...)
+		}
+	}
+
+	char.LastUpdateTime = time.Now()
+
+	embeds := guild.ProgEmbeds(fights, time.Now())
+	if summary {
+		embeds = append(embeds, guild.ProgSummaryEmbeds(fights)...)
 	}
 
+	return text, embeds, nil
+}
+
+// ProgEmbeds describes the furthest prog point the fights reached in each of
+// the guild's prog encounters, with the fight that reached it.
+func (g *Guild) ProgEmbeds(fights *fflogs.Fights, now time.Time) []*discordgo.MessageEmbed {
+	embeds := []*discordgo.MessageEmbed{}
+	for _, encounter := range g.ProgEncounters() {
+		if encounter.ProgRoles == nil {
+			continue
+		}
+		evidence := encounter.ProgEvidence(fights, now)
+		if evidence == nil {
+			continue
+		}
+		_, role := encounter.ProgRoles.FurthestProgRole(evidence)
+		if role == nil {
+			continue
+		}
+		embeds = append(embeds, role.ProgEmbed(evidence))
+	}
+	return embeds
+}
+
+// ApplyProgForMember applies prog roles to a member for the given fights,
+// returning what happened and whether any of their roles changed.
+func (c *Clearingway) ApplyProgForMember(
+	fights *fflogs.Fights,
+	char *ffxiv.Character,
+	discordUserId string,
+	guild *Guild,
+) ([]string, bool, error) {
 	member, err := c.Discord.Session.GuildMember(guild.Id, discordUserId)
 	if err != nil {
-		return nil, fmt.Errorf("Could not retrieve roles for user: %w", err)
+		return nil, false, fmt.Errorf("Could not retrieve roles for user: %w", err)
 	}
 	existingRoles := &Roles{Roles: []*Role{}}
 	for _, guildRole := range guild.AllRoles() {
@@ -248,23 +459,22 @@ func (c *Clearingway) UpdateProgForCharacterInGuild(
 		}
 	}
 	text := []string{}
+	changed := false
 
 	shouldApplyOpts := &ShouldApplyOpts{
-	    Character:     char,
-	    Fights:        fights,  // Make sure this is the fights from GetProgForReport
-	    ExistingRoles: existingRoles,
-	    Encounters:    guild.Encounters,
+		Character:     char,
+		Fights:        fights,
+		ExistingRoles: existingRoles,
+		Encounters:    guild.Encounters,
 	}
-	
-	fmt.Printf("About to call ShouldApply with %d fights\n", len(fights.Fights))
-	if len(fights.Fights) > 0 {
-	    fmt.Printf("First fight: %+v\n", fights.Fights[0])
-	}
-
-	for _, encounter := range guild.Encounters.Encounters {
+	for _, encounter := range guild.ProgEncounters() {
 		if encounter.ProgRoles == nil {
 			continue
 		}
+		if clearedRole, ok := encounter.Roles[ClearedRole]; ok && guild.ClearProgEnabled && clearedRole.PresentInRoles(member.Roles) {
+			text = append(text, fmt.Sprintf("You have already cleared `%s`, so you do not need prog roles for it.", encounter.Name))
+			continue
+		}
 
 		shouldApply, message, rolesToApply, rolesToRemove := encounter.ProgRoles.ShouldApply(shouldApplyOpts)
 
@@ -276,9 +486,10 @@ func (c *Clearingway) UpdateProgForCharacterInGuild(
 					if !role.PresentInRoles(member.Roles) {
 						err := role.AddToCharacter(guild.Id, discordUserId, c.Discord.Session)
 						if err != nil {
-							return nil, fmt.Errorf("Error adding Discord role: %v", err)
+							return nil, false, fmt.Errorf("Error adding Discord role: %v", err)
 						}
 						text = append(text, fmt.Sprintf("Adding role: __**%s**__\n", role.Name))
+						changed = true
 					}
 				}
 			}
@@ -288,31 +499,69 @@ func (c *Clearingway) UpdateProgForCharacterInGuild(
 					if role.PresentInRoles(member.Roles) {
 						err := role.RemoveFromCharacter(guild.Id, discordUserId, c.Discord.Session)
 						if err != nil {
-							return nil, fmt.Errorf("Error removing Discord role: %v", err)
+							return nil, false, fmt.Errorf("Error removing Discord role: %v", err)
 						}
 						text = append(text, fmt.Sprintf("Removing role: __**%s**__\n", role.Name))
+						changed = true
 					}
 				}
 			}
 		}
+
+		// Remember when the member last showed the prog for the role they now
+		// hold, so newer reports push its expiry back.
+		if encounter.ProgExpiry != 0 {
+			evidence := encounter.ProgEvidence(fights, time.Now())
+			if evidence == nil {
+				continue
+			}
+			_, reachedRole := encounter.ProgRoles.FurthestProgRole(evidence)
+			if reachedRole == nil {
+				continue
+			}
+			applied := shouldApply && slices.Contains(rolesToApply, reachedRole)
+			if !applied && !reachedRole.PresentInRoles(member.Roles) {
+				continue
+			}
+			guild.ProgGrants.Record(discordUserId, encounter, reachedRole, evidence.StartTime)
+			grant := guild.ProgGrants.Get(discordUserId, encounter)
+			text = append(text, fmt.Sprintf(
+				"`%s` expires <t:%d:R> unless you submit a newer report.\n",
+				reachedRole.Name,
+				grant.EvidenceTime.Add(encounter.ProgExpiry).Unix(),
+			))
+		}
 	}
 
-	char.LastUpdateTime = time.Now()
+	return text, changed, nil
+}
 
-	return text, nil
+// CleanReportIds splits a list of report URLs or IDs separated by spaces or
+// commas into report IDs, dropping duplicates.
+func CleanReportIds(reports string) []string {
+	reportIds := []string{}
+	for _, report := range strings.FieldsFunc(reports, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	}) {
+		reportId := CleanReportId(report)
+		if len(reportId) != 0 && !slices.Contains(reportIds, reportId) {
+			reportIds = append(reportIds, reportId)
+		}
+	}
+	return reportIds
 }
 
 func CleanReportId(reportId string) string {
-    reportId = strings.TrimRight(reportId, "/")
-    
-    parts := strings.Split(reportId, "#")
-    reportId = parts[0]
-    
-    parts = strings.Split(reportId, "?")
-    reportId = parts[0]
-    
-    parts = strings.Split(reportId, "/")
-    reportId = parts[len(parts)-1]
-    
-    return reportId
+	reportId = strings.TrimRight(reportId, "/")
+
+	parts := strings.Split(reportId, "#")
+	reportId = parts[0]
+
+	parts = strings.Split(reportId, "?")
+	reportId = parts[0]
+
+	parts = strings.Split(reportId, "/")
+	reportId = parts[len(parts)-1]
+
+	return reportId
 }
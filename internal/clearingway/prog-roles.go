@@ -2,123 +2,285 @@ package clearingway
 
 import (
 	"fmt"
+	"sort"
 	"strings"
+	"time"
+
+	"github.com/Veraticus/clearingway/internal/fflogs"
+
+	"github.com/bwmarrin/discordgo"
 )
 
-func ProgRoles(rs []*ConfigRole, e *Encounter) *Roles {
+// ProgPoint is how far into an encounter a prog role is: a phase, counted
+// from 1, and the boss HP percentage that must be reached within it. The
+// phase name, mechanic and image are only for display.
+type ProgPoint struct {
+	Phase          int
+	BossPercentage float64
+	PhaseName      string
+	Mechanic       string
+	Image          string
+}
+
+// ReachedBy reports whether a fight got at least as far as the prog point.
+// Kills reach every prog point.
+func (p *ProgPoint) ReachedBy(f *fflogs.Fight) bool {
+	if f.Kill {
+		return true
+	}
+	phase := f.LastPhaseIndex + 1
+	if phase != p.Phase {
+		return phase > p.Phase
+	}
+	return f.BossPercentage <= p.BossPercentage
+}
+
+func (p *ProgPoint) String() string {
+	phase := fmt.Sprintf("phase %d", p.Phase)
+	if len(p.PhaseName) != 0 {
+		phase = fmt.Sprintf("phase %d (%s)", p.Phase, p.PhaseName)
+	}
+	if p.BossPercentage >= 100 {
+		return phase
+	}
+	return fmt.Sprintf("%s at or below %v%% boss HP", phase, p.BossPercentage)
+}
+
+func ProgRoles(rs []*ConfigProgRole, e *Encounter) (*Roles, error) {
 	roles := &Roles{Roles: []*Role{}}
 	for i, r := range rs {
-		phase := i
+		progPoint := &ProgPoint{
+			Phase:          r.Phase,
+			BossPercentage: r.BossPercentage,
+			PhaseName:      r.PhaseName,
+			Mechanic:       r.Mechanic,
+			Image:          r.Image,
+		}
+		if progPoint.Phase == 0 {
+			progPoint.Phase = i + 1
+		}
+		if progPoint.BossPercentage == 0 {
+			progPoint.BossPercentage = 100
+		}
+		if progPoint.Phase < 0 {
+			return nil, fmt.Errorf("Prog role %s has an invalid phase %d", r.Name, r.Phase)
+		}
+		if progPoint.BossPercentage < 0 || progPoint.BossPercentage > 100 {
+			return nil, fmt.Errorf("Prog role %s has an invalid boss percentage %v", r.Name, r.BossPercentage)
+		}
+
 		role := &Role{
 			Name: r.Name, Color: r.Color, Type: ProgRole,
 			Hoist: r.Hoist, Mention: r.Mention,
-			Description: fmt.Sprintf("Reached phase %d (%s) in prog.", phase+1, r.Name),
+			Encounter:   e,
+			ProgPoint:   progPoint,
+			Description: fmt.Sprintf("Reached %s in prog.", progPoint),
+		}
+		if len(progPoint.Mechanic) != 0 {
+			role.Description = fmt.Sprintf("Reached %s in prog: %s", progPoint, progPoint.Mechanic)
+		}
+		if len(r.Description) != 0 {
+			role.Description = r.Description
+		}
+		if len(r.Requires) != 0 {
+			role.Requires = r.Requires
 		}
 		roles.Roles = append(roles.Roles, role)
 	}
+
+	// Prog roles are ordered from the earliest prog point to the furthest, so
+	// a role's index is how far along it is.
+	sort.SliceStable(roles.Roles, func(i, j int) bool {
+		a, b := roles.Roles[i].ProgPoint, roles.Roles[j].ProgPoint
+		if a.Phase != b.Phase {
+			return a.Phase < b.Phase
+		}
+		return a.BossPercentage > b.BossPercentage
+	})
+
 	roles.ShouldApply = func(opts *ShouldApplyOpts) (bool, string, []*Role, []*Role) {
 		fmt.Printf("ProgRoles ShouldApply called with %d existing roles\n", len(opts.ExistingRoles.Roles))
-		fmt.Printf("Available prog roles: %d\n", len(roles.Roles))
-		
+
 		if opts.Fights == nil || len(opts.Fights.Fights) == 0 {
 			fmt.Printf("No fights provided to ShouldApply\n")
-			return false, "No valid fights found in provided report!", nil, nil
+			return false, "No valid fights found in provided reports!", nil, nil
 		}
-		
+
+		// Only fights against this encounter count towards its prog.
+		if len(e.Fights(opts.Fights)) == 0 {
+			return false, fmt.Sprintf("No fights against `%s` found in provided reports.", e.Name), nil, nil
+		}
+		furthestFight := e.ProgEvidence(opts.Fights, time.Now())
+		if furthestFight == nil {
+			return false, fmt.Sprintf(
+				"Every fight against `%s` in provided reports is older than %s, so it no longer counts as prog.",
+				e.Name,
+				e.ProgExpiryString(),
+			), nil, nil
+		}
+
 		// Find existing PROG roles only (ignore cleared roles for prog logic)
 		var existingProgRole *Role
 		var existingProgRoleIndex int = -1
 		for _, existingRole := range opts.ExistingRoles.Roles {
-			// Only check for actual prog roles, not cleared roles
-			if existingRole.Type == ProgRole {
-				ok, i := roles.IndexOfRole(existingRole)
-				if ok {
-					existingProgRole = existingRole
-					existingProgRoleIndex = i
-					break // Take the highest prog role found
-				}
+			if existingRole.Type != ProgRole {
+				continue
+			}
+			ok, i := roles.IndexOfRole(existingRole)
+			if ok && i > existingProgRoleIndex {
+				existingProgRole = existingRole
+				existingProgRoleIndex = i
 			}
 		}
-		
-		// Find the furthest prog provided in the fights from the report
-		furthestFight := opts.Fights.FurthestFight()
-		
-		// Debug logging
-		fmt.Printf("Furthest fight: ID=%d, Kill=%t, LastPhaseIndex=%d\n", furthestFight.ID, furthestFight.Kill, furthestFight.LastPhaseIndex)
-		
-		var furthestProgRole *Role
-		var furthestProgRoleIndex int
-		
+
+		fmt.Printf(
+			"Furthest fight: ID=%d, Kill=%t, LastPhaseIndex=%d, BossPercentage=%v\n",
+			furthestFight.ID,
+			furthestFight.Kill,
+			furthestFight.LastPhaseIndex,
+			furthestFight.BossPercentage,
+		)
+
 		// Create return message.
 		messageString := strings.Builder{}
-		messageString.WriteString(fmt.Sprintf("⮕ Fight %d\n", furthestFight.ID))
-		
-		// For prog roles, we only care about the highest phase reached, not kills
-		// Even if there's a kill, we want to assign the prog role for the phase reached
-		if furthestFight.Kill {
-			// If there's a kill, assign prog role based on the phase where the kill happened
-			furthestProgRoleIndex = furthestFight.LastPhaseIndex
-			if furthestProgRoleIndex >= len(roles.Roles) {
-				// If the phase index exceeds available prog roles, assign the highest prog role
-				furthestProgRoleIndex = len(roles.Roles) - 1
-			}
-			furthestProgRole = roles.Roles[furthestProgRoleIndex]
-			fmt.Printf("Report contains a KILL at phase %d - should assign prog role: %s\n", furthestFight.LastPhaseIndex, furthestProgRole.Name)
-		} else {
-			furthestProgRoleIndex = furthestFight.LastPhaseIndex
-			if furthestProgRoleIndex >= len(roles.Roles) {
-				furthestProgRoleIndex = len(roles.Roles) - 1
-			}
-			furthestProgRole = roles.Roles[furthestProgRoleIndex]
-			fmt.Printf("Report shows prog to phase %d - should assign prog role: %s\n", furthestProgRoleIndex, furthestProgRole.Name)
-		}
-		
-		// More debug logging
-		if existingProgRole != nil {
-			fmt.Printf("User has existing prog role: %s (index %d)\n", existingProgRole.Name, existingProgRoleIndex)
-			fmt.Printf("Report shows furthest: %s (index %d)\n", furthestProgRole.Name, furthestProgRoleIndex)
-		} else {
-			fmt.Printf("User has NO existing prog role\n")
-		}
-		
+		messageString.WriteString(fmt.Sprintf(
+			"⮕ `%s` pull %d (fight %d), %s long, on <t:%d:F> (%s)\n",
+			e.Name,
+			furthestFight.Pull,
+			furthestFight.ID,
+			furthestFight.Duration.Round(time.Second),
+			furthestFight.StartTime.Unix(),
+			furthestFight.ReportURL(),
+		))
+
+		// Prog roles are for the furthest prog point reached, even if the
+		// fight was a kill.
+		furthestProgRoleIndex, furthestProgRole := roles.FurthestProgRole(furthestFight)
+		if furthestProgRole == nil {
+			messageString.WriteString(fmt.Sprintf(
+				"The furthest prog in these reports (phase %d, %v%% boss HP) has not reached any prog points yet.",
+				furthestFight.LastPhaseIndex+1,
+				furthestFight.BossPercentage,
+			))
+			return false, messageString.String(), nil, nil
+		}
+
 		// Bail out if the furthest prog point in the fight is less than or equal to one
 		// the user already possesses
 		if existingProgRole != nil && furthestProgRoleIndex <= existingProgRoleIndex {
 			if furthestProgRoleIndex < existingProgRoleIndex {
 				messageString.WriteString(fmt.Sprintf(
-					"You already have a prog role further than the furthest prog in this report! Your existing prog point is `%s` (phase %d), and the furthest prog point seen by you in this report is `%s` (phase %d).",
+					"You already have a prog role further than the furthest prog in these reports! Your existing prog point is `%s` (%s), and the furthest prog point seen by you in these reports is `%s` (%s).",
 					existingProgRole.Name,
-					existingProgRoleIndex+1,
+					existingProgRole.ProgPoint,
 					furthestProgRole.Name,
-					furthestProgRoleIndex+1,
+					furthestProgRole.ProgPoint,
 				))
 			} else {
 				messageString.WriteString(fmt.Sprintf(
-					"Your furthest prog point, `%s` (phase %d), is the same as the furthest prog point in this report.",
+					"Your furthest prog point, `%s` (%s), is the same as the furthest prog point in these reports.",
 					existingProgRole.Name,
-					existingProgRoleIndex+1,
+					existingProgRole.ProgPoint,
 				))
 			}
-			fmt.Printf("BAILING: User has same or higher prog than report shows\n")
 			return false, messageString.String(), nil, nil
 		}
-		
+
 		// Looks like we have some real prog to give!
-		// Remove all lower prog roles
+		// Remove the existing prog role and all lower ones
 		var lowerRoles []*Role
 		if existingProgRoleIndex >= 0 {
-			// Remove the existing prog role and all lower ones
-			lowerRoles = roles.Roles[0:existingProgRoleIndex+1]
+			lowerRoles = roles.Roles[0 : existingProgRoleIndex+1]
 		}
-		
+
 		messageString.WriteString(fmt.Sprintf(
-			"Your furthest prog point is now `%s` (phase %d).\n",
+			"Your furthest prog point is now `%s` (%s).\n",
 			furthestProgRole.Name,
-			furthestProgRoleIndex+1,
+			furthestProgRole.ProgPoint,
 		))
-		
-		fmt.Printf("SUCCESS: Should apply role %s\n", furthestProgRole.Name)
+
+		fmt.Printf("Should apply prog role %s\n", furthestProgRole.Name)
 		return true, messageString.String(), []*Role{furthestProgRole}, lowerRoles
 	}
-	return roles
+	return roles, nil
+}
+
+// FurthestProgRole returns the furthest prog role reached by the fight and its
+// index, or nil if the fight did not reach any of them.
+func (rs *Roles) FurthestProgRole(f *fflogs.Fight) (int, *Role) {
+	var furthestProgRole *Role
+	furthestProgRoleIndex := -1
+	for i, role := range rs.Roles {
+		if role.ProgPoint != nil && role.ProgPoint.ReachedBy(f) {
+			furthestProgRole = role
+			furthestProgRoleIndex = i
+		}
+	}
+
+	return furthestProgRoleIndex, furthestProgRole
+}
+
+// ProgEvidence returns the furthest of the encounter's fights that has not
+// expired by now, or nil if there is none.
+func (e *Encounter) ProgEvidence(fights *fflogs.Fights, now time.Time) *fflogs.Fight {
+	recentFights := &fflogs.Fights{Fights: []*fflogs.Fight{}}
+	for _, fight := range e.Fights(fights) {
+		if e.ProgExpiry != 0 && now.Sub(fight.StartTime) > e.ProgExpiry {
+			continue
+		}
+		recentFights.Add(fight)
+	}
+
+	return recentFights.FurthestFight()
+}
+
+// ProgEmbed describes a prog role: its prog point, the mechanic it is at and
+// its image. With evidence, the embed links the fight that reached it.
+func (r *Role) ProgEmbed(evidence *fflogs.Fight) *discordgo.MessageEmbed {
+	embed := &discordgo.MessageEmbed{
+		Title:       r.Name,
+		Description: r.Description,
+		Color:       r.Color,
+	}
+	if r.Encounter != nil {
+		embed.Author = &discordgo.MessageEmbedAuthor{Name: r.Encounter.Name}
+	}
+	if r.ProgPoint == nil {
+		return embed
+	}
+
+	phase := fmt.Sprintf("%d", r.ProgPoint.Phase)
+	if len(r.ProgPoint.PhaseName) != 0 {
+		phase = fmt.Sprintf("%d: %s", r.ProgPoint.Phase, r.ProgPoint.PhaseName)
+	}
+	embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: "Phase", Value: phase, Inline: true})
+	if r.ProgPoint.BossPercentage < 100 {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:   "Boss HP",
+			Value:  fmt.Sprintf("%v%% or below", r.ProgPoint.BossPercentage),
+			Inline: true,
+		})
+	}
+	if len(r.ProgPoint.Mechanic) != 0 {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: "Mechanic", Value: r.ProgPoint.Mechanic})
+	}
+	if evidence != nil {
+		embed.URL = evidence.ReportURL()
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name: "Evidence",
+			Value: fmt.Sprintf(
+				"[Pull %d (fight %d)](%s), %s on <t:%d:F>",
+				evidence.Pull,
+				evidence.ID,
+				evidence.ReportURL(),
+				describeFight(evidence),
+				evidence.StartTime.Unix(),
+			),
+		})
+	}
+	if len(r.ProgPoint.Image) != 0 {
+		embed.Image = &discordgo.MessageEmbedImage{URL: r.ProgPoint.Image}
+	}
+
+	return embed
 }
@@ -0,0 +1,88 @@
+package clearingway
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Veraticus/clearingway/internal/fflogs"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// ProgSummaryBarLength is the length of the bar for the phase most pulls
+// wiped in.
+const ProgSummaryBarLength = 16
+
+// ProgSummaryEmbeds summarizes the fights against each of the guild's prog
+// encounters: pull count, time spent, the furthest pull and how many wipes
+// ended in each phase.
+func (g *Guild) ProgSummaryEmbeds(fights *fflogs.Fights) []*discordgo.MessageEmbed {
+	embeds := []*discordgo.MessageEmbed{}
+	for _, e := range g.ProgEncounters() {
+		encounterFights := &fflogs.Fights{Fights: e.Fights(fights)}
+		if len(encounterFights.Fights) == 0 {
+			continue
+		}
+		embeds = append(embeds, e.ProgSummaryEmbed(encounterFights.Summary()))
+	}
+	return embeds
+}
+
+func (e *Encounter) ProgSummaryEmbed(s *fflogs.Summary) *discordgo.MessageEmbed {
+	embed := &discordgo.MessageEmbed{
+		Title:       fmt.Sprintf("%s session summary", e.Name),
+		Description: fmt.Sprintf("<t:%d:f> to <t:%d:t>", s.First.Unix(), s.Last.Unix()),
+		Color:       0x11806a,
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Pulls", Value: fmt.Sprintf("%d", s.Pulls), Inline: true},
+			{Name: "Time in combat", Value: s.TimeSpent.Round(time.Second).String(), Inline: true},
+			{Name: "Session length", Value: s.Last.Sub(s.First).Round(time.Minute).String(), Inline: true},
+		},
+	}
+	if s.Kills > 0 {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: "Kills", Value: fmt.Sprintf("%d", s.Kills), Inline: true})
+	}
+	if s.Furthest != nil {
+		embed.URL = s.Furthest.ReportURL()
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name: "Furthest pull",
+			Value: fmt.Sprintf(
+				"%s on [pull %d (fight %d)](%s)",
+				describeFight(s.Furthest),
+				s.Furthest.Pull,
+				s.Furthest.ID,
+				s.Furthest.ReportURL(),
+			),
+		})
+	}
+
+	most := 0
+	for _, wipes := range s.WipesByPhase {
+		most = max(most, wipes)
+	}
+	distribution := strings.Builder{}
+	for _, phase := range s.Phases() {
+		wipes := s.WipesByPhase[phase]
+		bar := strings.Repeat("█", max(1, wipes*ProgSummaryBarLength/most))
+		distribution.WriteString(fmt.Sprintf("`P%d` %s %d — %s\n", phase, bar, wipes, e.PhaseName(phase)))
+	}
+	if distribution.Len() != 0 {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: "Wipes by phase", Value: distribution.String()})
+	}
+
+	return embed
+}
+
+// PhaseName returns the name the encounter's prog roles give a phase,
+// counted from 1, or just the phase number if none of them name it.
+func (e *Encounter) PhaseName(phase int) string {
+	if e.ProgRoles != nil {
+		for _, role := range e.ProgRoles.Roles {
+			if role.ProgPoint != nil && role.ProgPoint.Phase == phase && len(role.ProgPoint.PhaseName) != 0 {
+				return role.ProgPoint.PhaseName
+			}
+		}
+	}
+	return fmt.Sprintf("Phase %d", phase)
+}
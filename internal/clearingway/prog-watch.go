@@ -0,0 +1,176 @@
+package clearingway
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Veraticus/clearingway/internal/fflogs"
+	"github.com/Veraticus/clearingway/internal/ffxiv"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+var (
+	// ProgWatchInterval is how often a watched report is polled.
+	ProgWatchInterval = 3 * time.Minute
+	// ProgWatchIdleTimeout is how long a watched report can go without new
+	// pulls before it is considered finished.
+	ProgWatchIdleTimeout = 45 * time.Minute
+	// ProgWatchMaxDuration is the longest a single report is watched.
+	ProgWatchMaxDuration = 10 * time.Hour
+)
+
+// ProgWatch follows a live report, updating prog roles as new pulls appear
+// and keeping a status embed in a channel up to date.
+type ProgWatch struct {
+	ReportId  string
+	Character *ffxiv.Character
+	Guild     *Guild
+	Group     bool
+	ChannelId string
+
+	messageId string
+	started   time.Time
+	lastPull  time.Time
+	pulls     int
+}
+
+// StartProgWatch starts watching a report in the background. A report can
+// only be watched once per guild at a time.
+func (c *Clearingway) StartProgWatch(reportId string, char *ffxiv.Character, guild *Guild, group bool, channelId string) error {
+	if group && !guild.GroupProgEnabled {
+		return fmt.Errorf("Group prog is not enabled in this server!")
+	}
+
+	key := guild.Id + "-" + reportId
+	_, watching := c.progWatches.LoadOrStore(key, true)
+	if watching {
+		return fmt.Errorf("Report `%s` is already being watched!", reportId)
+	}
+
+	w := &ProgWatch{
+		ReportId:  reportId,
+		Character: char,
+		Guild:     guild,
+		Group:     group,
+		ChannelId: channelId,
+		started:   time.Now(),
+		lastPull:  time.Now(),
+	}
+	go func() {
+		defer c.progWatches.Delete(key)
+		c.runProgWatch(w)
+	}()
+
+	return nil
+}
+
+func (c *Clearingway) runProgWatch(w *ProgWatch) {
+	ticker := time.NewTicker(ProgWatchInterval)
+	defer ticker.Stop()
+
+	for {
+		finished := c.pollProgWatch(w)
+		if finished {
+			return
+		}
+		<-ticker.C
+	}
+}
+
+// pollProgWatch checks the report for new pulls once, returning whether the
+// watch is over.
+func (c *Clearingway) pollProgWatch(w *ProgWatch) bool {
+	now := time.Now()
+	finished := now.Sub(w.lastPull) > ProgWatchIdleTimeout || now.Sub(w.started) > ProgWatchMaxDuration
+
+	report, err := c.Fflogs.GetProgReport(w.ReportId)
+	if err != nil {
+		fmt.Printf("Error polling watched report %s: %v\n", w.ReportId, err)
+		if finished {
+			c.updateProgWatchEmbed(w, nil, true)
+		}
+		return finished
+	}
+	fights, err := report.FightsForCharacter(progRankingsToGet(w.Guild), w.Character)
+	if err != nil {
+		// A live report may not have any of the character's pulls yet.
+		fmt.Printf("No pulls yet for %s in watched report %s: %v\n", w.Character.Name(), w.ReportId, err)
+		fights = &fflogs.Fights{Fights: []*fflogs.Fight{}}
+	}
+
+	if len(fights.Fights) > w.pulls {
+		w.pulls = len(fights.Fights)
+		w.lastPull = now
+		finished = now.Sub(w.started) > ProgWatchMaxDuration
+
+		_, _, err := c.ApplyProgForMember(fights, w.Character, w.Character.DiscordId, w.Guild)
+		if err != nil {
+			fmt.Printf("Error applying prog from watched report %s: %v\n", w.ReportId, err)
+		}
+		if w.Group {
+			c.ApplyGroupProg([]*fflogs.ProgReport{report}, fights, w.Character, w.Guild)
+		}
+	}
+
+	c.updateProgWatchEmbed(w, fights, finished)
+	return finished
+}
+
+func (c *Clearingway) updateProgWatchEmbed(w *ProgWatch, fights *fflogs.Fights, finished bool) {
+	embed := &discordgo.MessageEmbed{
+		Title:     fmt.Sprintf("Watching report %s", w.ReportId),
+		URL:       fmt.Sprintf("https://www.fflogs.com/reports/%s", w.ReportId),
+		Color:     0x11806a,
+		Timestamp: time.Now().Format(time.RFC3339),
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("Submitted by %s (%s)", w.Character.Name(), w.Character.World),
+		},
+	}
+	if finished {
+		embed.Title = fmt.Sprintf("Finished watching report %s", w.ReportId)
+	}
+
+	if fights != nil {
+		for _, e := range w.Guild.ProgEncounters() {
+			encounterFights := &fflogs.Fights{Fights: e.Fights(fights)}
+			best := encounterFights.FurthestFight()
+			if best == nil {
+				continue
+			}
+			value := strings.Builder{}
+			value.WriteString(fmt.Sprintf("%s on pull %d ([fight %d](%s))", describeFight(best), best.Pull, best.ID, best.ReportURL()))
+			if _, role := e.ProgRoles.FurthestProgRole(best); role != nil {
+				value.WriteString(fmt.Sprintf("\nReached `%s`", role.Name))
+			}
+			value.WriteString(fmt.Sprintf("\n%d pulls so far", len(encounterFights.Fights)))
+			embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: e.Name, Value: value.String()})
+		}
+	}
+	if len(embed.Fields) == 0 {
+		embed.Description = "No pulls against this server's prog encounters yet."
+	}
+
+	if len(w.messageId) == 0 {
+		message, err := c.Discord.Session.ChannelMessageSendEmbed(w.ChannelId, embed)
+		if err != nil {
+			fmt.Printf("Error sending prog watch status: %v\n", err)
+			return
+		}
+		w.messageId = message.ID
+		return
+	}
+
+	_, err := c.Discord.Session.ChannelMessageEditEmbed(w.ChannelId, w.messageId, embed)
+	if err != nil {
+		fmt.Printf("Error updating prog watch status: %v\n", err)
+	}
+}
+
+func describeFight(f *fflogs.Fight) string {
+	if f.Kill {
+		return "**Kill**"
+	}
+	return fmt.Sprintf("**Phase %d**, %v%% boss HP", f.LastPhaseIndex+1, f.BossPercentage)
+}
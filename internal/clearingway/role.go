@@ -21,15 +21,20 @@ type ShouldApplyOpts struct {
 type RoleType string
 
 var (
-	PfRole       RoleType = "PF"
-	ReclearRole  RoleType = "Reclear"
-	ParseRole    RoleType = "Parse"
-	ClearedRole  RoleType = "Cleared"
-	ProgRole     RoleType = "Prog"
-	LimboRole    RoleType = "Limbo"
-	CompleteRole RoleType = "Complete"
-	ColorRole    RoleType = "Name Color"
-	C4XRole      RoleType = "C4X"
+	PfRole          RoleType = "PF"
+	ReclearRole     RoleType = "Reclear"
+	ParseRole       RoleType = "Parse"
+	ClearedRole     RoleType = "Cleared"
+	ProgRole        RoleType = "Prog"
+	LimboRole       RoleType = "Limbo"
+	CompleteRole    RoleType = "Complete"
+	ColorRole       RoleType = "Name Color"
+	C4XRole         RoleType = "C4X"
+	WindowRole      RoleType = "Window"
+	TierRole        RoleType = "Tier"
+	PartitionRole   RoleType = "Partition"
+	MilestoneRole   RoleType = "Milestone"
+	FreeCompanyRole RoleType = "Free Company"
 )
 
 type Roles struct {
@@ -53,6 +58,41 @@ type Role struct {
 	Encounter   *Encounter
 	ShouldApply func(*ShouldApplyOpts) (bool, string)
 	DiscordRole *discordgo.Role
+
+	// Requires holds the configured names (or, for encounter roles, role
+	// types) of the roles this role depends on; they are resolved into
+	// Prerequisites when the guild is initialized.
+	Requires      []string
+	Prerequisites []*Role
+
+	// ProgPoint is set on prog roles to how far into the encounter they are.
+	ProgPoint *ProgPoint
+}
+
+// applyConfigRole overrides the role's defaults with whatever the config
+// sets. A nil config changes nothing.
+func applyConfigRole(role *Role, c *ConfigRole) {
+	if c == nil {
+		return
+	}
+	if len(c.Name) != 0 {
+		role.Name = c.Name
+	}
+	if len(c.Description) != 0 {
+		role.Description = c.Description
+	}
+	if c.Color != 0 {
+		role.Color = c.Color
+	}
+	if c.Hoist {
+		role.Hoist = true
+	}
+	if c.Mention {
+		role.Mention = true
+	}
+	if len(c.Requires) != 0 {
+		role.Requires = c.Requires
+	}
 }
 
 func (r *Role) Ensure(guildId string, s *discordgo.Session, existingRoles []*discordgo.Role) error {
@@ -118,11 +158,60 @@ func (r *Role) PresentInRoles(existingRoleIds []string) bool {
 	return false
 }
 
+// AddPrerequisite makes p a prerequisite of r, ignoring duplicates.
+func (r *Role) AddPrerequisite(p *Role) {
+	if p == nil || p == r {
+		return
+	}
+	for _, existing := range r.Prerequisites {
+		if existing == p {
+			return
+		}
+	}
+	r.Prerequisites = append(r.Prerequisites, p)
+}
+
+// MissingPrerequisite returns the first prerequisite of r that is not held,
+// or nil if every prerequisite is. Skipped prerequisites are never managed
+// by Clearingway, so they are always considered held.
+func (r *Role) MissingPrerequisite(held map[*Role]bool) *Role {
+	for _, p := range r.Prerequisites {
+		if p.Skip {
+			continue
+		}
+		if !held[p] {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// MissingPrerequisitesInRoles returns the prerequisites of r that are not in
+// the given Discord role IDs.
+func (r *Role) MissingPrerequisitesInRoles(existingRoleIds []string) []*Role {
+	missing := []*Role{}
+	for _, p := range r.Prerequisites {
+		if p.Skip || p.DiscordRole == nil {
+			continue
+		}
+		if !p.PresentInRoles(existingRoleIds) {
+			missing = append(missing, p)
+		}
+	}
+
+	return missing
+}
+
 func (r *Role) Phase(i int) string {
 	if r.Type == ClearedRole {
 		return "**Cleared**"
 	}
 
+	if r.ProgPoint != nil && len(r.ProgPoint.PhaseName) != 0 {
+		return fmt.Sprintf("Phase **%d** (%s)", i, r.ProgPoint.PhaseName)
+	}
+
 	return fmt.Sprintf("Phase **%d**", i)
 }
 
@@ -0,0 +1,169 @@
+package clearingway
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/Veraticus/clearingway/internal/ffxiv"
+)
+
+// DefaultStateFile is where Clearingway keeps its state when the config does
+// not say otherwise.
+var DefaultStateFile = "state/clearingway.json"
+
+// Store keeps what Clearingway has to remember across restarts in a JSON
+// file, under a key for each part of each guild. A nil store remembers
+// nothing, which is what tests use.
+type Store struct {
+	path string
+
+	mu   sync.Mutex
+	data map[string]json.RawMessage
+}
+
+// OpenStore reads the state file, creating it if it does not exist yet so
+// that a state file that cannot be written fails at startup rather than
+// the first time something is saved.
+func OpenStore(path string) (*Store, error) {
+	s := &Store{path: path, data: map[string]json.RawMessage{}}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		err = os.MkdirAll(filepath.Dir(path), 0o700)
+		if err != nil {
+			return nil, fmt.Errorf("Could not create directory for state file %s: %w", path, err)
+		}
+		return s, s.write()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not read state file %s: %w", path, err)
+	}
+	err = json.Unmarshal(b, &s.data)
+	if err != nil {
+		return nil, fmt.Errorf("Could not unmarshal state file %s: %w", path, err)
+	}
+
+	return s, nil
+}
+
+// Load reads what was saved under the key into v, leaving v alone if nothing
+// was.
+func (s *Store) Load(key string, v any) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	raw, ok := s.data[key]
+	if !ok {
+		return nil
+	}
+	err := json.Unmarshal(raw, v)
+	if err != nil {
+		return fmt.Errorf("Could not unmarshal %s from state file: %w", key, err)
+	}
+	return nil
+}
+
+// Save stores v under the key and writes the state file. Errors are only
+// logged, since there is nothing better to do with them.
+func (s *Store) Save(key string, v any) {
+	if s == nil {
+		return
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		fmt.Printf("Could not marshal %s for state file: %v\n", key, err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data[key] = raw
+	err = s.write()
+	if err != nil {
+		fmt.Printf("Could not save %s: %v\n", key, err)
+	}
+}
+
+// write replaces the state file, through a temporary file so that a crash
+// never leaves half of one.
+func (s *Store) write() error {
+	b, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Could not marshal state file: %w", err)
+	}
+	tmp := s.path + ".tmp"
+	err = os.WriteFile(tmp, b, 0o600)
+	if err != nil {
+		return fmt.Errorf("Could not write state file %s: %w", tmp, err)
+	}
+	err = os.Rename(tmp, s.path)
+	if err != nil {
+		return fmt.Errorf("Could not replace state file %s: %w", s.path, err)
+	}
+	return nil
+}
+
+// stateKey is the key the guild's part of the state is saved under.
+func (g *Guild) stateKey(part string) string {
+	return g.Id + "/" + part
+}
+
+// RestoreState loads the guild's state from the store and saves it there
+// from now on.
+func (g *Guild) RestoreState(store *Store) error {
+	err := g.ProgGrants.Restore(store, g.stateKey("progGrants"), g.ProgEncounters())
+	if err != nil {
+		return err
+	}
+	err = g.GroupProgOptOuts.Restore(store, g.stateKey("groupProgOptOuts"))
+	if err != nil {
+		return err
+	}
+	err = g.Ownership.Restore(store, g.stateKey("ownership"))
+	if err != nil {
+		return err
+	}
+	err = g.FirstKills.Restore(store, g.stateKey("firstKills"))
+	if err != nil {
+		return err
+	}
+	err = g.restoreLinks(store)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// restoreLinks links the guild's characters to their members again, so that
+// /clears on an alt still counts the main after a restart.
+func (g *Guild) restoreLinks(store *Store) error {
+	key := g.stateKey("links")
+	links := []*ffxiv.LinkRecord{}
+	err := store.Load(key, &links)
+	if err != nil {
+		return err
+	}
+	err = g.Characters.RestoreLinks(links)
+	if err != nil {
+		return err
+	}
+
+	// Saves are serialized so that an older snapshot never replaces a newer
+	// one.
+	var mu sync.Mutex
+	g.Characters.OnLinksChange = func() {
+		mu.Lock()
+		defer mu.Unlock()
+		store.Save(key, g.Characters.Links())
+	}
+	return nil
+}
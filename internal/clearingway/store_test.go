@@ -0,0 +1,44 @@
+package clearingway
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/Veraticus/clearingway/internal/ffxiv"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRestoreLinksAltAfterRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	link := func(g *Guild, name string, id int) *ffxiv.Character {
+		char, err := g.Characters.Init("Gilgamesh", name, "Sunrise")
+		assert.NoError(t, err)
+		char.LodestoneID = id
+		char = g.Characters.Identify(char)
+		g.Characters.Link(char, "1")
+		return char
+	}
+
+	store, err := OpenStore(path)
+	assert.NoError(t, err)
+	g := &Guild{Id: "guild", Characters: &ffxiv.Characters{Characters: map[string]*ffxiv.Character{}}}
+	assert.NoError(t, g.restoreLinks(store))
+	link(g, "Main", 1)
+	link(g, "Alt", 2)
+
+	// After a restart, /clears on the alt still counts the main.
+	store, err = OpenStore(path)
+	assert.NoError(t, err)
+	restarted := &Guild{Id: "guild", Characters: &ffxiv.Characters{Characters: map[string]*ffxiv.Character{}}}
+	assert.NoError(t, restarted.restoreLinks(store))
+	alt, err := restarted.Characters.Init("Gilgamesh", "Alt", "Sunrise")
+	assert.NoError(t, err)
+	alt = restarted.Characters.Identify(alt)
+
+	chars := linkedCharacters(restarted, alt, "1")
+	if assert.Len(t, chars, 2) {
+		assert.Equal(t, "Main Sunrise", chars[0].Name())
+		assert.True(t, chars[0].Primary)
+		assert.Equal(t, alt, chars[1])
+	}
+}
@@ -0,0 +1,99 @@
+package clearingway
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Veraticus/clearingway/internal/fflogs"
+)
+
+// TierRoles builds a role for every configured tier. A tier references other
+// encounters in the guild by name and applies once enough of them (all of
+// them, unless required is set) have been cleared.
+func TierRoles(configTiers []*ConfigTier, es *Encounters) (*Roles, error) {
+	roles := &Roles{Roles: []*Role{}}
+
+	for _, configTier := range configTiers {
+		tierName := configTier.Name
+		if len(tierName) == 0 {
+			return nil, fmt.Errorf("Tiers must have a name")
+		}
+		if len(configTier.Encounters) == 0 {
+			return nil, fmt.Errorf("Tier %s does not list any encounters", tierName)
+		}
+
+		tierEncounters := []*Encounter{}
+		for _, encounterName := range configTier.Encounters {
+			encounter := es.ForName(encounterName)
+			if encounter == nil {
+				return nil, fmt.Errorf("Tier %s references encounter %s, which is not configured", tierName, encounterName)
+			}
+			tierEncounters = append(tierEncounters, encounter)
+		}
+
+		required := configTier.Required
+		if required == 0 {
+			required = len(tierEncounters)
+		}
+		if required < 0 || required > len(tierEncounters) {
+			return nil, fmt.Errorf("Tier %s requires %d clears but only has %d encounters", tierName, required, len(tierEncounters))
+		}
+
+		role := &Role{
+			Name:  tierName + "-Cleared",
+			Color: 0x11806a,
+			Type:  TierRole,
+		}
+		if required == len(tierEncounters) {
+			role.Description = fmt.Sprintf("Cleared every encounter in %s.", tierName)
+		} else {
+			role.Description = fmt.Sprintf("Cleared at least %d encounters in %s.", required, tierName)
+		}
+		applyConfigRole(role, configTier.ConfigRole)
+
+		role.ShouldApply = func(opts *ShouldApplyOpts) (bool, string) {
+			clearedEncounters := []*Encounter{}
+			firstClears := map[*Encounter]*fflogs.Rank{}
+			for _, encounter := range tierEncounters {
+				rank := encounter.FirstClear(opts.Rankings)
+				if rank == nil {
+					continue
+				}
+				clearedEncounters = append(clearedEncounters, encounter)
+				firstClears[encounter] = rank
+			}
+
+			if len(clearedEncounters) < required {
+				return false, fmt.Sprintf(
+					"Cleared **%v** of the **%v** required encounters in `%v`.",
+					len(clearedEncounters),
+					required,
+					tierName,
+				)
+			}
+
+			s := strings.Builder{}
+			s.WriteString(fmt.Sprintf(
+				"Cleared **%v** of **%v** encounters in `%v`:",
+				len(clearedEncounters),
+				len(tierEncounters),
+				tierName,
+			))
+			for _, encounter := range clearedEncounters {
+				rank := firstClears[encounter]
+				s.WriteString(fmt.Sprintf(
+					"\n     `%v` with `%v` on <t:%v:F> (%v).",
+					encounter.Name,
+					rank.Job.Abbreviation,
+					rank.UnixTime(),
+					rank.Report.Url(),
+				))
+			}
+			return true, s.String()
+		}
+
+		roles.Roles = append(roles.Roles, role)
+	}
+
+	return roles, nil
+}
@@ -0,0 +1,125 @@
+package clearingway
+
+import "fmt"
+
+// InitUltimateProg gives every ultimate the built-in prog roles, merged with
+// the guild's overrides. Ultimates the guild configured itself get them
+// unless they already have their own prog; the rest get a prog-only copy of
+// the ultimate in UltimateProgEncounters.
+func (g *Guild) InitUltimateProg(configUltimateProgs []*ConfigUltimateProg) error {
+	g.UltimateProgEncounters = &Encounters{Encounters: []*Encounter{}}
+
+	overrides := map[string][]*ConfigProgRole{}
+	expiries := map[string]*ConfigUltimateProg{}
+	for _, configUltimateProg := range configUltimateProgs {
+		if UltimateEncounters.ForName(configUltimateProg.Encounter) == nil {
+			return fmt.Errorf("Ultimate prog overrides %s, which is not an ultimate", configUltimateProg.Encounter)
+		}
+		for _, configProg := range configUltimateProg.ConfigProg {
+			if configProg.Phase == 0 {
+				return fmt.Errorf("Ultimate prog overrides for %s must set a phase", configUltimateProg.Encounter)
+			}
+		}
+		overrides[configUltimateProg.Encounter] = append(overrides[configUltimateProg.Encounter], configUltimateProg.ConfigProg...)
+		if len(configUltimateProg.ProgExpiry) != 0 {
+			expiries[configUltimateProg.Encounter] = configUltimateProg
+		}
+	}
+
+	if !g.UltimateProgEnabled {
+		return nil
+	}
+
+	for _, ult := range UltimateEncounters.Encounters {
+		e := g.Encounters.ForName(ult.Name)
+		if e == nil {
+			e = g.Encounters.ForIds(ult.Ids)
+		}
+		if e != nil && e.ProgRoles != nil {
+			continue
+		}
+		if e == nil {
+			e = &Encounter{
+				Name:                  ult.Name,
+				Ids:                   ult.Ids,
+				Difficulty:            ult.Difficulty,
+				TotalWeaponsAvailable: ult.TotalWeaponsAvailable,
+				The:                   ult.The,
+				RequiredKillsToClear:  1,
+				Roles:                 map[RoleType]*Role{},
+			}
+			g.UltimateProgEncounters.Encounters = append(g.UltimateProgEncounters.Encounters, e)
+		}
+		if configUltimateProg, ok := expiries[ult.Name]; ok {
+			progExpiry, err := parseProgExpiry(configUltimateProg.ProgExpiry)
+			if err != nil {
+				return fmt.Errorf("Invalid prog expiry for %s: %w", ult.Name, err)
+			}
+			e.ProgExpiry = progExpiry
+			e.ProgExpiryDowngrade = configUltimateProg.ProgExpiryDowngrade
+		}
+
+		progRoles, err := ProgRoles(mergeProg(ult.DefaultProg, overrides[ult.Name]), e)
+		if err != nil {
+			return fmt.Errorf("Invalid ultimate prog for %s: %w", ult.Name, err)
+		}
+		e.ProgRoles = progRoles
+	}
+
+	return nil
+}
+
+// mergeProg applies overrides to the default prog points. An override with
+// the same phase and boss percentage as a default replaces whatever it sets;
+// other overrides are extra prog points.
+func mergeProg(defaults, overrides []*ConfigProgRole) []*ConfigProgRole {
+	merged := []*ConfigProgRole{}
+	used := map[*ConfigProgRole]bool{}
+
+	for _, d := range defaults {
+		progRole := *d
+		progRole.Color = 0x11806a
+		for _, o := range overrides {
+			if o.Phase != d.Phase || o.BossPercentage != d.BossPercentage {
+				continue
+			}
+			used[o] = true
+			if len(o.Name) != 0 {
+				progRole.Name = o.Name
+			}
+			if len(o.Description) != 0 {
+				progRole.Description = o.Description
+			}
+			if len(o.PhaseName) != 0 {
+				progRole.PhaseName = o.PhaseName
+			}
+			if len(o.Mechanic) != 0 {
+				progRole.Mechanic = o.Mechanic
+			}
+			if len(o.Image) != 0 {
+				progRole.Image = o.Image
+			}
+			if o.Color != 0 {
+				progRole.Color = o.Color
+			}
+			if o.Hoist {
+				progRole.Hoist = true
+			}
+			if o.Mention {
+				progRole.Mention = true
+			}
+			if len(o.Requires) != 0 {
+				progRole.Requires = o.Requires
+			}
+		}
+		merged = append(merged, &progRole)
+	}
+
+	for _, o := range overrides {
+		if !used[o] {
+			merged = append(merged, o)
+		}
+	}
+
+	return merged
+}
@@ -41,3 +41,20 @@ func ContinueInteraction(s *discordgo.Session, i *discordgo.Interaction, message
 	})
 	return err
 }
+
+// MaxEmbedsPerMessage is the most embeds Discord shows on a single message.
+const MaxEmbedsPerMessage = 10
+
+func ContinueInteractionWithEmbeds(s *discordgo.Session, i *discordgo.Interaction, embeds []*discordgo.MessageEmbed) error {
+	for start := 0; start < len(embeds); start += MaxEmbedsPerMessage {
+		end := min(start+MaxEmbedsPerMessage, len(embeds))
+		_, err := s.FollowupMessageCreate(i, true, &discordgo.WebhookParams{
+			Embeds: embeds[start:end],
+			Flags:  discordgo.MessageFlagsEphemeral,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -9,6 +9,7 @@ import (
 	"mime/multipart"
 	"net/http"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -37,6 +38,11 @@ type fflogsAccessToken struct {
 type RankingToGet struct {
 	IDs        []int
 	Difficulty int
+
+	// Partitions lists the FF Logs partitions to query explicitly. If it is
+	// empty, the current partition is queried for both standard and
+	// nonstandard comps.
+	Partitions []int
 }
 
 func (f *Fflogs) SetCharacterLodestoneID(char *ffxiv.Character) error {
@@ -89,9 +95,57 @@ func (f *Fflogs) SetCharacterLodestoneID(char *ffxiv.Character) error {
 	return fmt.Errorf("Lodestone ID not found on fflogs!")
 }
 
-var returnedRankingsRegexp = regexp.MustCompile(`(\D+)Z(\d+)P(\D+)`)
+var returnedRankingsRegexp = regexp.MustCompile(`(\D+)Z(\d+)P(\D+)(\d*)`)
 
-func (f *Fflogs) GetRankingsForCharacter(rankingsToGet []*RankingToGet, char *ffxiv.Character) (*Rankings, error) {
+// partitionAlias names a partition in a GraphQL alias, which cannot contain a
+// minus sign: partition -2 is "partitionm2".
+func partitionAlias(partition int) string {
+	if partition < 0 {
+		return fmt.Sprintf("partitionm%d", -partition)
+	}
+	return fmt.Sprintf("partition%d", partition)
+}
+
+// rankingsToQuery is what is queried for one encounter ID, merged from every
+// request for it.
+type rankingsToQuery struct {
+	id         int
+	difficulty int
+	partitions []int
+	current    bool
+}
+
+// mergeRankingsToGet merges the requests for each encounter ID, in the order
+// they were first requested. The same encounter can be requested more than
+// once (for example an ultimate the guild also configured itself), maybe
+// for different partitions: every partition asked for is queried once, and
+// the current partition is queried once if any request wants it.
+func mergeRankingsToGet(rankingsToGet []*RankingToGet) []*rankingsToQuery {
+	merged := []*rankingsToQuery{}
+	byId := map[int]*rankingsToQuery{}
+	for _, rankingToGet := range rankingsToGet {
+		for _, id := range rankingToGet.IDs {
+			toQuery, ok := byId[id]
+			if !ok {
+				toQuery = &rankingsToQuery{id: id, difficulty: rankingToGet.Difficulty}
+				byId[id] = toQuery
+				merged = append(merged, toQuery)
+			}
+			if len(rankingToGet.Partitions) == 0 {
+				toQuery.current = true
+				continue
+			}
+			for _, partition := range rankingToGet.Partitions {
+				if !slices.Contains(toQuery.partitions, partition) {
+					toQuery.partitions = append(toQuery.partitions, partition)
+				}
+			}
+		}
+	}
+	return merged
+}
+
+func rankingsQuery(rankingsToGet []*RankingToGet, char *ffxiv.Character) string {
 	query := strings.Builder{}
 	query.WriteString(
 		fmt.Sprintf(
@@ -101,46 +155,69 @@ func (f *Fflogs) GetRankingsForCharacter(rankingsToGet []*RankingToGet, char *ff
 			char.PhysicalDatacenter().Abbreviation,
 		),
 	)
-	for _, rankingToGet := range rankingsToGet {
-		for _, id := range rankingToGet.IDs {
-			query.WriteString(
-				fmt.Sprintf(
-					"rdpsZ%dPstandard:encounterRankings(encounterID: %d, difficulty: %d, metric: rdps) ",
-					id,
-					id,
-					rankingToGet.Difficulty,
-				),
-			)
-			query.WriteString(
-				fmt.Sprintf(
-					"hpsZ%dPstandard:encounterRankings(encounterID: %d, difficulty: %d, metric: hps) ",
-					id,
-					id,
-					rankingToGet.Difficulty,
-				),
-			)
-			query.WriteString(
-				fmt.Sprintf(
-					"rdpsZ%dPnonstandard:encounterRankings(encounterID: %d, difficulty: %d, metric: rdps, partition: -2) ",
-					id,
-					id,
-					rankingToGet.Difficulty,
-				),
-			)
-			query.WriteString(
-				fmt.Sprintf(
-					"hpsZ%dPnonstandard:encounterRankings(encounterID: %d, difficulty: %d, metric: hps, partition: -2) ",
-					id,
-					id,
-					rankingToGet.Difficulty,
-				),
-			)
-
+	for _, toQuery := range mergeRankingsToGet(rankingsToGet) {
+		id := toQuery.id
+		for _, partition := range toQuery.partitions {
+			for _, metric := range []Metric{Dps, Hps} {
+				query.WriteString(
+					fmt.Sprintf(
+						"%sZ%dP%s:encounterRankings(encounterID: %d, difficulty: %d, metric: %s, partition: %d) ",
+						metric,
+						id,
+						partitionAlias(partition),
+						id,
+						toQuery.difficulty,
+						metric,
+						partition,
+					),
+				)
+			}
 		}
+		if !toQuery.current {
+			continue
+		}
+
+		query.WriteString(
+			fmt.Sprintf(
+				"rdpsZ%dPstandard:encounterRankings(encounterID: %d, difficulty: %d, metric: rdps) ",
+				id,
+				id,
+				toQuery.difficulty,
+			),
+		)
+		query.WriteString(
+			fmt.Sprintf(
+				"hpsZ%dPstandard:encounterRankings(encounterID: %d, difficulty: %d, metric: hps) ",
+				id,
+				id,
+				toQuery.difficulty,
+			),
+		)
+		query.WriteString(
+			fmt.Sprintf(
+				"rdpsZ%dPnonstandard:encounterRankings(encounterID: %d, difficulty: %d, metric: rdps, partition: -2) ",
+				id,
+				id,
+				toQuery.difficulty,
+			),
+		)
+		query.WriteString(
+			fmt.Sprintf(
+				"hpsZ%dPnonstandard:encounterRankings(encounterID: %d, difficulty: %d, metric: hps, partition: -2) ",
+				id,
+				id,
+				toQuery.difficulty,
+			),
+		)
 	}
 	query.WriteString("}}}")
+	return query.String()
+}
 
-	raw, err := f.graphqlClient.ExecRaw(context.Background(), query.String(), nil)
+func (f *Fflogs) GetRankingsForCharacter(rankingsToGet []*RankingToGet, char *ffxiv.Character) (*Rankings, error) {
+	query := rankingsQuery(rankingsToGet, char)
+
+	raw, err := f.graphqlClient.ExecRaw(context.Background(), query, nil)
 	if err != nil {
 		return nil, fmt.Errorf("Error executing query: %w", err)
 	}
@@ -182,8 +259,11 @@ func (f *Fflogs) GetRankingsForCharacter(rankingsToGet []*RankingToGet, char *ff
 		}
 
 		ranking := &Ranking{Metric: Metric(metric)}
-		if partition == "nonstandard" {
+		switch partition {
+		case "nonstandard":
 			ranking.Nonstandard = true
+		case "partition", "partitionm":
+			ranking.Explicit = true
 		}
 		err = json.Unmarshal(*rawRanking, ranking)
 		if err != nil {
@@ -0,0 +1,43 @@
+package fflogs
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/Veraticus/clearingway/internal/ffxiv"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartitionAlias(t *testing.T) {
+	validAlias := regexp.MustCompile(`^[_A-Za-z][_0-9A-Za-z]*$`)
+
+	for partition, expected := range map[int]string{1: "partition", -2: "partitionm"} {
+		alias := "rdpsZ1079P" + partitionAlias(partition)
+		assert.Regexp(t, validAlias, alias)
+
+		match := returnedRankingsRegexp.FindStringSubmatch(alias)
+		assert.Len(t, match, 5)
+		assert.Equal(t, "1079", match[2])
+		assert.Equal(t, expected, match[3])
+	}
+}
+
+func TestRankingsQueryMergesPartitions(t *testing.T) {
+	char := &ffxiv.Character{FirstName: "Tataru", LastName: "Taru", World: "Gilgamesh"}
+	query := rankingsQuery([]*RankingToGet{
+		{IDs: []int{1079}, Difficulty: 100, Partitions: []int{1, 3}},
+		{IDs: []int{1079}, Difficulty: 100, Partitions: []int{3, 5}},
+		{IDs: []int{1079}, Difficulty: 100},
+	}, char)
+
+	// Every partition asked for is queried once, and so is the current one.
+	for _, alias := range []string{"Z1079Ppartition1:", "Z1079Ppartition3:", "Z1079Ppartition5:", "Z1079Pstandard:", "Z1079Pnonstandard:"} {
+		assert.Equal(t, 1, strings.Count(query, "rdps"+alias), alias)
+		assert.Equal(t, 1, strings.Count(query, "hps"+alias), alias)
+	}
+
+	// The current partition is only queried when a request wants it.
+	query = rankingsQuery([]*RankingToGet{{IDs: []int{1079}, Difficulty: 100, Partitions: []int{1}}}, char)
+	assert.NotContains(t, query, "Pstandard:")
+}
@@ -5,22 +5,47 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/Veraticus/clearingway/internal/ffxiv"
 )
 
 type report struct {
-	Fights     []*fight    `json:"fights"`
-	MasterData *masterData `json:"masterData"`
+	StartTime        int                `json:"startTime"`
+	Fights           []*fight           `json:"fights"`
+	MasterData       *masterData        `json:"masterData"`
+	RankedCharacters []*rankedCharacter `json:"rankedCharacters"`
+}
+
+// rankedCharacter is a character with rankings in a report. Unlike actors,
+// FF Logs knows which Lodestone character these are.
+type rankedCharacter struct {
+	Name        string        `json:"name"`
+	LodestoneID int           `json:"lodestoneID"`
+	Server      *rankedServer `json:"server"`
+}
+
+type rankedServer struct {
+	Slug string `json:"slug"`
 }
 
 type fight struct {
-	ID                       int   `json:"id"`
-	Kill                     bool  `json:"kill"`
-	Difficulty               int   `json:"difficulty"`
-	EncounterID              int   `json:"encounterID"`
-	LastPhaseAsAbsoluteIndex int   `json:"lastPhaseAsAbsoluteIndex"`
-	FriendlyPlayers          []int `json:"friendlyPlayers"`
+	ID                       int                `json:"id"`
+	StartTime                int                `json:"startTime"`
+	EndTime                  int                `json:"endTime"`
+	Kill                     bool               `json:"kill"`
+	Difficulty               int                `json:"difficulty"`
+	EncounterID              int                `json:"encounterID"`
+	LastPhaseAsAbsoluteIndex int                `json:"lastPhaseAsAbsoluteIndex"`
+	BossPercentage           float64            `json:"bossPercentage"`
+	FightPercentage          float64            `json:"fightPercentage"`
+	PhaseTransitions         []*PhaseTransition `json:"phaseTransitions"`
+	FriendlyPlayers          []int              `json:"friendlyPlayers"`
+}
+
+type PhaseTransition struct {
+	ID        int `json:"id"`
+	StartTime int `json:"startTime"`
 }
 
 type masterData struct {
@@ -38,18 +63,43 @@ type Fights struct {
 }
 
 type Fight struct {
-	LastPhaseIndex int
-	Kill           bool
-	EncounterID    int
-	ReportID       string
-	ID             int
+	LastPhaseIndex   int
+	BossPercentage   float64
+	FightPercentage  float64
+	PhaseTransitions []*PhaseTransition
+	Kill             bool
+	EncounterID      int
+	ReportID         string
+	ID               int
+
+	// Pull is which pull of the encounter in the report this fight was,
+	// counting from 1.
+	Pull      int
+	StartTime time.Time
+	Duration  time.Duration
+}
+
+// ProgReport is a report's fights and players, from which prog can be found for
+// any character in it.
+type ProgReport struct {
+	Code string
+	data *report
 }
 
 func (f *Fflogs) GetProgForReport(r string, rankingsToGet []*RankingToGet, char *ffxiv.Character) (*Fights, error) {
+	report, err := f.GetProgReport(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return report.FightsForCharacter(rankingsToGet, char)
+}
+
+func (f *Fflogs) GetProgReport(r string) (*ProgReport, error) {
 	query := strings.Builder{}
 	query.WriteString(
 		fmt.Sprintf(
-			"query{reportData{report(code: \"%s\") {fights {kill difficulty id encounterID lastPhaseAsAbsoluteIndex friendlyPlayers} masterData(translate: false) {actors(type: \"Player\") {id name server}}}}}",
+			"query{reportData{report(code: \"%s\") {startTime fights {id startTime endTime kill difficulty encounterID lastPhaseAsAbsoluteIndex bossPercentage fightPercentage phaseTransitions {id startTime} friendlyPlayers} masterData(translate: false) {actors(type: \"Player\") {id name server}} rankedCharacters {name lodestoneID server {slug}}}}}",
 			r,
 		),
 	)
@@ -83,6 +133,15 @@ func (f *Fflogs) GetProgForReport(r string, rankingsToGet []*RankingToGet, char
 		return nil, fmt.Errorf("Master data not found correctly for %s!", r)
 	}
 
+	return &ProgReport{Code: r, data: report}, nil
+}
+
+// FightsForCharacter returns the fights against the given encounters that
+// the character took part in.
+func (rep *ProgReport) FightsForCharacter(rankingsToGet []*RankingToGet, char *ffxiv.Character) (*Fights, error) {
+	r := rep.Code
+	report := rep.data
+
 	characterActorIds := []int{}
 	characterFoundInMasterData := false
 	for _, a := range report.MasterData.Actors {
@@ -94,10 +153,22 @@ func (f *Fflogs) GetProgForReport(r string, rankingsToGet []*RankingToGet, char
 	if !characterFoundInMasterData {
 		return nil, fmt.Errorf("Could not find character %s (%s) in report %s.", char.Name(), char.World, r)
 	}
+	err := report.checkLodestoneID(char)
+	if err != nil {
+		return nil, fmt.Errorf("%w (report %s)", err, r)
+	}
 
 	fights := &Fights{Fights: []*Fight{}}
+	// Pulls are counted separately for each difficulty, so a report that
+	// mixes them cites the pull number of the difficulty that was asked for.
+	type pullKey struct{ encounterID, difficulty int }
+	pulls := map[pullKey]int{}
 
 	for _, f := range report.Fights {
+		pull := pullKey{f.EncounterID, f.Difficulty}
+		if f.EncounterID != 0 {
+			pulls[pull]++
+		}
 		for _, rankingToGet := range rankingsToGet {
 			for _, encounterID := range rankingToGet.IDs {
 				if rankingToGet.Difficulty != f.Difficulty {
@@ -121,11 +192,17 @@ func (f *Fflogs) GetProgForReport(r string, rankingsToGet []*RankingToGet, char
 				}
 
 				fights.Add(&Fight{
-					Kill:           f.Kill,
-					LastPhaseIndex: f.LastPhaseAsAbsoluteIndex,
-					EncounterID:    f.EncounterID,
-					ID:             f.ID,
-					ReportID:       r,
+					Kill:             f.Kill,
+					LastPhaseIndex:   f.LastPhaseAsAbsoluteIndex,
+					BossPercentage:   f.BossPercentage,
+					FightPercentage:  f.FightPercentage,
+					PhaseTransitions: f.PhaseTransitions,
+					EncounterID:      f.EncounterID,
+					ID:               f.ID,
+					ReportID:         r,
+					Pull:             pulls[pull],
+					StartTime:        time.UnixMilli(int64(report.StartTime + f.StartTime)),
+					Duration:         time.Duration(f.EndTime-f.StartTime) * time.Millisecond,
 				})
 			}
 		}
@@ -134,16 +211,79 @@ func (f *Fflogs) GetProgForReport(r string, rankingsToGet []*RankingToGet, char
 	return fights, nil
 }
 
+// checkLodestoneID makes sure the character in the report is the one with
+// the character's Lodestone ID, for reports where FF Logs knows it. Reports
+// without rankings cannot be checked this way and are matched by name and
+// world alone.
+func (r *report) checkLodestoneID(char *ffxiv.Character) error {
+	if char.LodestoneID == 0 {
+		return nil
+	}
+
+	for _, rc := range r.RankedCharacters {
+		if rc.LodestoneID == 0 || rc.Server == nil {
+			continue
+		}
+		if rc.Name != char.Name() || !strings.EqualFold(rc.Server.Slug, char.World) {
+			continue
+		}
+		if rc.LodestoneID != char.LodestoneID {
+			return fmt.Errorf(
+				"%s (%s) in this report is Lodestone character %d, not your verified character %d",
+				char.Name(),
+				char.World,
+				rc.LodestoneID,
+				char.LodestoneID,
+			)
+		}
+	}
+
+	return nil
+}
+
+// ChecksLodestoneID reports whether FF Logs knows the Lodestone ID of the
+// character in the report, so that FightsForCharacter could check it is the
+// verified character rather than matching by name and world alone. Reports
+// where the character has no rankings, like reports with only wipes, cannot
+// be checked.
+func (rep *ProgReport) ChecksLodestoneID(char *ffxiv.Character) bool {
+	return rep.data.ranks(char)
+}
+
+func (r *report) ranks(char *ffxiv.Character) bool {
+	for _, rc := range r.RankedCharacters {
+		if rc.LodestoneID == 0 || rc.Server == nil {
+			continue
+		}
+		if rc.Name == char.Name() && strings.EqualFold(rc.Server.Slug, char.World) {
+			return true
+		}
+	}
+	return false
+}
+
 func (f *Fights) Add(fight *Fight) {
+	if f.Contains(fight) {
+		return
+	}
+
+	f.Fights = append(f.Fights, fight)
+}
+
+// Contains reports whether the fight, matched by report and fight ID, is
+// one of the fights.
+func (f *Fights) Contains(fight *Fight) bool {
 	for _, existingFight := range f.Fights {
 		if existingFight.ID == fight.ID && existingFight.ReportID == fight.ReportID {
-			return
+			return true
 		}
 	}
 
-	f.Fights = append(f.Fights, fight)
+	return false
 }
 
+// FurthestFight returns the first kill, or otherwise the wipe that reached
+// the latest phase with the least boss HP left.
 func (f *Fights) FurthestFight() *Fight {
 	var fight *Fight
 
@@ -151,7 +291,7 @@ func (f *Fights) FurthestFight() *Fight {
 		if f.Kill {
 			return f
 		}
-		if fight == nil || f.LastPhaseIndex > fight.LastPhaseIndex {
+		if fight == nil || f.FurtherThan(fight) {
 			fight = f
 		}
 	}
@@ -159,6 +299,92 @@ func (f *Fights) FurthestFight() *Fight {
 	return fight
 }
 
+// FurtherThan orders fights by (phase, boss HP): a later phase is further,
+// and within the same phase less remaining boss HP is further.
+func (f *Fight) FurtherThan(o *Fight) bool {
+	if f.Kill != o.Kill {
+		return f.Kill
+	}
+	if f.LastPhaseIndex != o.LastPhaseIndex {
+		return f.LastPhaseIndex > o.LastPhaseIndex
+	}
+	return f.BossPercentage < o.BossPercentage
+}
+
 func (f *Fight) ReportURL() string {
 	return fmt.Sprintf("https://www.fflogs.com/reports/%s#fight=%d", f.ReportID, f.ID)
 }
+
+type recentReports struct {
+	Data []*recentReport `json:"data"`
+}
+
+type recentReport struct {
+	Code   string   `json:"code"`
+	Fights []*fight `json:"fights"`
+}
+
+// GetRecentReportsForCharacter returns the codes of the character's most
+// recent reports, newest first, that contain a fight against one of the
+// given encounters. Only reports visible to the client are returned, so
+// private logs are never found this way; encounterRankings cannot stand in
+// for it either, as it only lists kills.
+func (f *Fflogs) GetRecentReportsForCharacter(rankingsToGet []*RankingToGet, char *ffxiv.Character, limit int) ([]string, error) {
+	query := fmt.Sprintf(
+		"query{characterData{character(name: \"%s\", serverSlug: \"%s\", serverRegion: \"%s\"){recentReports(limit: %d){data{code fights{encounterID difficulty}}}}}}",
+		char.Name(),
+		char.World,
+		char.PhysicalDatacenter().Abbreviation,
+		limit,
+	)
+
+	raw, err := f.graphqlClient.ExecRaw(context.Background(), query, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Error executing query: %w", err)
+	}
+
+	var response struct {
+		CharacterData struct {
+			Character *struct {
+				RecentReports *recentReports `json:"recentReports"`
+			} `json:"character"`
+		} `json:"characterData"`
+	}
+	err = json.Unmarshal(raw, &response)
+	if err != nil {
+		return nil, fmt.Errorf("Could not unmarshal JSON: %w", err)
+	}
+	character := response.CharacterData.Character
+	if character == nil {
+		return nil, fmt.Errorf("Character %s (%s) not found in fflogs!", char.Name(), char.World)
+	}
+	if character.RecentReports == nil {
+		return []string{}, nil
+	}
+
+	codes := []string{}
+	for _, report := range character.RecentReports.Data {
+		if report.hasFightIn(rankingsToGet) {
+			codes = append(codes, report.Code)
+		}
+	}
+
+	return codes, nil
+}
+
+func (r *recentReport) hasFightIn(rankingsToGet []*RankingToGet) bool {
+	for _, f := range r.Fights {
+		for _, rankingToGet := range rankingsToGet {
+			if rankingToGet.Difficulty != f.Difficulty {
+				continue
+			}
+			for _, encounterID := range rankingToGet.IDs {
+				if f.EncounterID == encounterID {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,92 @@
+package fflogs
+
+import (
+	"testing"
+
+	"github.com/Veraticus/clearingway/internal/ffxiv"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFurthestFight(t *testing.T) {
+	tests := []struct {
+		name     string
+		fights   []*Fight
+		expected int
+	}{
+		{
+			name: "Later phase wins",
+			fights: []*Fight{
+				{ID: 1, LastPhaseIndex: 1, BossPercentage: 10},
+				{ID: 2, LastPhaseIndex: 2, BossPercentage: 90},
+			},
+			expected: 2,
+		},
+		{
+			name: "Lower boss HP wins within a phase",
+			fights: []*Fight{
+				{ID: 1, LastPhaseIndex: 3, BossPercentage: 40},
+				{ID: 2, LastPhaseIndex: 3, BossPercentage: 12.5},
+				{ID: 3, LastPhaseIndex: 3, BossPercentage: 60},
+			},
+			expected: 2,
+		},
+		{
+			name: "Kill wins",
+			fights: []*Fight{
+				{ID: 1, LastPhaseIndex: 4, BossPercentage: 1},
+				{ID: 2, LastPhaseIndex: 3, Kill: true},
+			},
+			expected: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fights := &Fights{Fights: tt.fights}
+			assert.Equal(t, tt.expected, fights.FurthestFight().ID)
+		})
+	}
+}
+
+func TestCheckLodestoneID(t *testing.T) {
+	char := &ffxiv.Character{World: "Gilgamesh", FirstName: "Lyna", LastName: "Ashfall", LodestoneID: 123}
+	newRankedCharacter := func(name, slug string, lodestoneID int) *rankedCharacter {
+		return &rankedCharacter{Name: name, LodestoneID: lodestoneID, Server: &rankedServer{Slug: slug}}
+	}
+
+	r := &report{RankedCharacters: []*rankedCharacter{
+		newRankedCharacter("Someone Else", "gilgamesh", 456),
+		newRankedCharacter("Lyna Ashfall", "gilgamesh", 123),
+	}}
+	assert.NoError(t, r.checkLodestoneID(char))
+	assert.True(t, r.ranks(char))
+
+	r = &report{RankedCharacters: []*rankedCharacter{
+		newRankedCharacter("Lyna Ashfall", "gilgamesh", 789),
+	}}
+	assert.EqualError(t, r.checkLodestoneID(char), "Lyna Ashfall (Gilgamesh) in this report is Lodestone character 789, not your verified character 123")
+
+	// Reports without rankings cannot be checked.
+	assert.NoError(t, (&report{}).checkLodestoneID(char))
+	assert.False(t, (&report{}).ranks(char))
+}
+
+func TestFightsForCharacterPulls(t *testing.T) {
+	char := &ffxiv.Character{World: "Gilgamesh", FirstName: "Lyna", LastName: "Ashfall"}
+	rep := &ProgReport{Code: "abc", data: &report{
+		MasterData: &masterData{Actors: []*actor{{Id: 1, Name: "Lyna Ashfall", Server: "Gilgamesh"}}},
+		Fights: []*fight{
+			{ID: 1, EncounterID: 93, Difficulty: 100, FriendlyPlayers: []int{1}},
+			{ID: 2, EncounterID: 93, Difficulty: 101, FriendlyPlayers: []int{1}},
+			{ID: 3, EncounterID: 93, Difficulty: 101, FriendlyPlayers: []int{1}},
+			{ID: 4, EncounterID: 93, Difficulty: 100, FriendlyPlayers: []int{1}},
+		},
+	}}
+
+	// Pulls of another difficulty in the same report are not counted.
+	fights, err := rep.FightsForCharacter([]*RankingToGet{{IDs: []int{93}, Difficulty: 101}}, char)
+	assert.NoError(t, err)
+	assert.Len(t, fights.Fights, 2)
+	assert.Equal(t, 1, fights.Fights[0].Pull)
+	assert.Equal(t, 2, fights.Fights[1].Pull)
+}
@@ -3,6 +3,7 @@ package fflogs
 import (
 	"fmt"
 	"sort"
+	"time"
 
 	"github.com/Veraticus/clearingway/internal/ffxiv"
 )
@@ -11,6 +12,10 @@ import (
 // indexed by the encounter ID of the fight.
 type Rankings struct {
 	Rankings map[int]*Ranking
+
+	// counted holds the partitions whose kills were already counted for each
+	// encounter, since the current partition can also be queried explicitly.
+	counted map[int]map[int]bool
 }
 
 type Metric string
@@ -31,14 +36,20 @@ type Ranking struct {
 	// it's a copy of the standard counterpart
 	Partition   int `json:"partition"`
 	Nonstandard bool
+
+	// Explicit rankings were queried for a specific partition, so they are
+	// never copies of another response.
+	Explicit bool
 }
 
 type Rank struct {
 	RankPercent float64 `json:"rankPercent"`
 	Spec        string  `json:"spec"`
 	StartTime   int     `json:"startTime"`
+	Duration    int     `json:"duration"`
 	Report      Report  `json:"report"`
 	Job         *ffxiv.Job
+	Partition   int `json:"-"`
 
 	DPSParseFound bool
 	HPSParseFound bool
@@ -55,9 +66,12 @@ type Report struct {
 
 func (rs *Rankings) Add(id int, r *Ranking) error {
 	// skip any nonstandard responses that is a copy of standard
-	if (r.Partition%2 == 0) != r.Nonstandard {
+	if !r.Explicit && (r.Partition%2 == 0) != r.Nonstandard {
 		return nil
 	}
+	for _, rank := range r.Ranks {
+		rank.Partition = r.Partition
+	}
 	existingRankings, ok := rs.Rankings[id]
 
 	if !ok {
@@ -76,15 +90,14 @@ func (rs *Rankings) Add(id int, r *Ranking) error {
 			rank.Job = j
 		}
 
-		// only count a single metric's kill count so we don't double it
-		if r.Metric == "rdps" {
+		if !rs.countKills(id, r) {
 			r.TotalKills = 0
 		}
 		rs.Rankings[id] = r
 		return nil
 	}
 
-	if r.TotalKills != 0 && r.Metric == "hps" {
+	if r.TotalKills != 0 && rs.countKills(id, r) {
 		rs.Rankings[id].TotalKills += r.TotalKills
 	}
 
@@ -123,6 +136,46 @@ func (rs *Rankings) Add(id int, r *Ranking) error {
 	return nil
 }
 
+// countKills reports whether the kills in the response should be counted:
+// only a single metric's are, so they are not doubled, and only once for
+// each partition.
+func (rs *Rankings) countKills(id int, r *Ranking) bool {
+	if r.Metric != Hps {
+		return false
+	}
+	if rs.counted == nil {
+		rs.counted = map[int]map[int]bool{}
+	}
+	if rs.counted[id] == nil {
+		rs.counted[id] = map[int]bool{}
+	}
+	if rs.counted[id][r.Partition] {
+		return false
+	}
+	rs.counted[id][r.Partition] = true
+	return true
+}
+
+// Merge adds another character's rankings to these, so a player with several
+// characters is judged on all of them: clears are summed and every rank is
+// kept, so the best parse of any character counts.
+func (rs *Rankings) Merge(o *Rankings) {
+	for id, r := range o.Rankings {
+		existing, ok := rs.Rankings[id]
+		if !ok {
+			rs.Rankings[id] = r
+			continue
+		}
+		merged := *existing
+		if len(merged.Error) != 0 && len(r.Error) == 0 {
+			merged.Error = ""
+		}
+		merged.TotalKills += r.TotalKills
+		merged.Ranks = append(append([]*Rank{}, existing.Ranks...), r.Ranks...)
+		rs.Rankings[id] = &merged
+	}
+}
+
 func (r *Rank) SameFight(o *Rank) bool {
 	return r.StartTime == o.StartTime
 }
@@ -185,6 +238,21 @@ func (r *Ranking) WorstHPSRank() *Rank {
 	return sortedRanks[len(sortedRanks)-1]
 }
 
+// RanksInPartitions returns the ranks that were set in any of the given
+// partitions, earliest first.
+func (r *Ranking) RanksInPartitions(partitions []int) []*Rank {
+	ranks := []*Rank{}
+	for _, rank := range r.RanksByTime() {
+		for _, partition := range partitions {
+			if rank.Partition == partition {
+				ranks = append(ranks, rank)
+				break
+			}
+		}
+	}
+	return ranks
+}
+
 func (r *Ranking) RanksByTime() []*Rank {
 	ranks := make([]*Rank, len(r.Ranks))
 	copy(ranks, r.Ranks)
@@ -207,6 +275,10 @@ func (r *Rank) UnixTime() int {
 	return r.StartTime / 1000
 }
 
+func (r *Rank) Time() time.Time {
+	return time.UnixMilli(int64(r.StartTime))
+}
+
 func (r *Rank) DPSPercentString() string {
 	return fmt.Sprintf("%.2f", r.DPSPercent)
 }
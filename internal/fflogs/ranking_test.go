@@ -0,0 +1,86 @@
+package fflogs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddExplicitPartitions(t *testing.T) {
+	rankings := &Rankings{Rankings: map[int]*Ranking{}}
+
+	responses := []*Ranking{
+		{Metric: Dps, Partition: 1, Explicit: true, TotalKills: 2, Ranks: []*Rank{
+			{Spec: "Dancer", StartTime: 1000, RankPercent: 50},
+			{Spec: "Dancer", StartTime: 2000, RankPercent: 60},
+		}},
+		{Metric: Hps, Partition: 1, Explicit: true, TotalKills: 2, Ranks: []*Rank{
+			{Spec: "Dancer", StartTime: 1000, RankPercent: 10},
+		}},
+		// An even partition is not a nonstandard copy when asked for explicitly.
+		{Metric: Dps, Partition: 2, Explicit: true, TotalKills: 1, Ranks: []*Rank{
+			{Spec: "Dancer", StartTime: 3000, RankPercent: 70},
+		}},
+		{Metric: Hps, Partition: 2, Explicit: true, TotalKills: 1},
+	}
+	for _, r := range responses {
+		assert.NoError(t, rankings.Add(1079, r))
+	}
+
+	ranking := rankings.Rankings[1079]
+	assert.Equal(t, 3, ranking.TotalKills)
+	assert.Len(t, ranking.Ranks, 3)
+
+	launch := ranking.RanksInPartitions([]int{1})
+	assert.Len(t, launch, 2)
+	assert.Equal(t, 1000, launch[0].StartTime)
+	assert.Equal(t, 10.0, launch[0].HPSPercent)
+
+	later := ranking.RanksInPartitions([]int{2})
+	assert.Len(t, later, 1)
+	assert.Equal(t, 3000, later[0].StartTime)
+}
+
+func TestAddCountsPartitionsOnce(t *testing.T) {
+	rankings := &Rankings{Rankings: map[int]*Ranking{}}
+
+	// The current partition queried explicitly and by default.
+	for _, explicit := range []bool{true, false} {
+		assert.NoError(t, rankings.Add(1079, &Ranking{Metric: Hps, Partition: 1, Explicit: explicit, TotalKills: 2, Ranks: []*Rank{
+			{Spec: "Sage", StartTime: 1000},
+			{Spec: "Sage", StartTime: 2000},
+		}}))
+	}
+	assert.Equal(t, 2, rankings.Rankings[1079].TotalKills)
+	assert.Len(t, rankings.Rankings[1079].Ranks, 2)
+}
+
+func TestAddSkipsNonstandardCopies(t *testing.T) {
+	rankings := &Rankings{Rankings: map[int]*Ranking{}}
+
+	assert.NoError(t, rankings.Add(1079, &Ranking{Metric: Dps, Partition: 1, Nonstandard: true, TotalKills: 1, Ranks: []*Rank{
+		{Spec: "Dancer", StartTime: 1000},
+	}}))
+	assert.NotContains(t, rankings.Rankings, 1079)
+}
+
+func TestMergeRankings(t *testing.T) {
+	main := &Rankings{Rankings: map[int]*Ranking{}}
+	assert.NoError(t, main.Add(1079, &Ranking{Metric: Hps, Partition: 1, TotalKills: 2, Ranks: []*Rank{
+		{Spec: "Sage", StartTime: 1000, RankPercent: 40},
+	}}))
+	alt := &Rankings{Rankings: map[int]*Ranking{}}
+	assert.NoError(t, alt.Add(1079, &Ranking{Metric: Dps, Partition: 1, Ranks: []*Rank{
+		{Spec: "Dancer", StartTime: 2000, RankPercent: 90},
+	}}))
+	assert.NoError(t, alt.Add(1079, &Ranking{Metric: Hps, Partition: 1, TotalKills: 1}))
+	assert.NoError(t, alt.Add(1080, &Ranking{Metric: Hps, Partition: 1, TotalKills: 3}))
+
+	main.Merge(alt)
+
+	ranking := main.Rankings[1079]
+	assert.Equal(t, 3, ranking.TotalKills)
+	assert.Len(t, ranking.Ranks, 2)
+	assert.Equal(t, 2000, ranking.BestDPSRank().StartTime)
+	assert.Equal(t, 3, main.Rankings[1080].TotalKills)
+}
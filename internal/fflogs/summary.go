@@ -0,0 +1,53 @@
+package fflogs
+
+import (
+	"sort"
+	"time"
+)
+
+// Summary is an overview of a set of pulls against one encounter: how many
+// there were, how long they took, the furthest one and where the rest ended.
+type Summary struct {
+	Pulls     int
+	Kills     int
+	TimeSpent time.Duration
+	Furthest  *Fight
+	First     time.Time
+	Last      time.Time
+
+	// WipesByPhase counts wipes by the phase they ended in, counted from 1.
+	WipesByPhase map[int]int
+}
+
+func (f *Fights) Summary() *Summary {
+	s := &Summary{WipesByPhase: map[int]int{}}
+
+	for _, fight := range f.Fights {
+		s.Pulls++
+		s.TimeSpent += fight.Duration
+		if fight.Kill {
+			s.Kills++
+		} else {
+			s.WipesByPhase[fight.LastPhaseIndex+1]++
+		}
+		if s.First.IsZero() || fight.StartTime.Before(s.First) {
+			s.First = fight.StartTime
+		}
+		if end := fight.StartTime.Add(fight.Duration); end.After(s.Last) {
+			s.Last = end
+		}
+	}
+	s.Furthest = f.FurthestFight()
+
+	return s
+}
+
+// Phases returns the phases any wipe ended in, in order.
+func (s *Summary) Phases() []int {
+	phases := []int{}
+	for phase := range s.WipesByPhase {
+		phases = append(phases, phase)
+	}
+	sort.Ints(phases)
+	return phases
+}
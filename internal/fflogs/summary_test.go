@@ -0,0 +1,28 @@
+package fflogs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSummary(t *testing.T) {
+	start := time.Date(2025, 1, 1, 20, 0, 0, 0, time.UTC)
+	fights := &Fights{Fights: []*Fight{
+		{ID: 1, LastPhaseIndex: 0, StartTime: start, Duration: 2 * time.Minute},
+		{ID: 2, LastPhaseIndex: 1, BossPercentage: 70, StartTime: start.Add(5 * time.Minute), Duration: 6 * time.Minute},
+		{ID: 3, LastPhaseIndex: 1, BossPercentage: 20, StartTime: start.Add(15 * time.Minute), Duration: 7 * time.Minute},
+		{ID: 4, LastPhaseIndex: 0, StartTime: start.Add(25 * time.Minute), Duration: time.Minute},
+	}}
+
+	s := fights.Summary()
+	assert.Equal(t, 4, s.Pulls)
+	assert.Equal(t, 0, s.Kills)
+	assert.Equal(t, 16*time.Minute, s.TimeSpent)
+	assert.Equal(t, 3, s.Furthest.ID)
+	assert.Equal(t, start, s.First)
+	assert.Equal(t, start.Add(26*time.Minute), s.Last)
+	assert.Equal(t, map[int]int{1: 2, 2: 2}, s.WipesByPhase)
+	assert.Equal(t, []int{1, 2}, s.Phases())
+}
@@ -0,0 +1,78 @@
+package fflogs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type fightRankings struct {
+	Rankings []*fightRanking `json:"rankings"`
+}
+
+type fightRanking struct {
+	StartTime int `json:"startTime"`
+	Duration  int `json:"duration"`
+}
+
+// GetFirstKillTime returns when the earliest kill of any of the given
+// encounters was recorded on FF Logs, according to its progress rankings.
+func (f *Fflogs) GetFirstKillTime(ids []int, difficulty int) (time.Time, error) {
+	query := strings.Builder{}
+	query.WriteString("query{worldData{")
+	for _, id := range ids {
+		query.WriteString(
+			fmt.Sprintf(
+				"e%d:encounter(id: %d){fightRankings(difficulty: %d, metric: progress)} ",
+				id,
+				id,
+				difficulty,
+			),
+		)
+	}
+	query.WriteString("}}")
+
+	raw, err := f.graphqlClient.ExecRaw(context.Background(), query.String(), nil)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Error executing query: %w", err)
+	}
+
+	var response map[string]*json.RawMessage
+	err = json.Unmarshal(raw, &response)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Could not unmarshal JSON: %w", err)
+	}
+
+	if response["worldData"] == nil {
+		return time.Time{}, fmt.Errorf("No world data returned by FF Logs for encounters %v!", ids)
+	}
+
+	var encounters map[string]*struct {
+		FightRankings *fightRankings `json:"fightRankings"`
+	}
+	err = json.Unmarshal(*response["worldData"], &encounters)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Could not unmarshal JSON: %w", err)
+	}
+
+	firstKill := 0
+	for _, encounter := range encounters {
+		if encounter == nil || encounter.FightRankings == nil {
+			continue
+		}
+		for _, ranking := range encounter.FightRankings.Rankings {
+			killTime := ranking.StartTime + ranking.Duration
+			if firstKill == 0 || killTime < firstKill {
+				firstKill = killTime
+			}
+		}
+	}
+
+	if firstKill == 0 {
+		return time.Time{}, fmt.Errorf("No kills recorded on FF Logs for encounters %v!", ids)
+	}
+
+	return time.UnixMilli(int64(firstKill)), nil
+}
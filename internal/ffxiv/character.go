@@ -3,15 +3,35 @@ package ffxiv
 import (
 	"fmt"
 	"hash/adler32"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
 )
 
+// Characters are keyed by name and world, and also by Lodestone ID once it is
+// known, since that survives renames and world transfers.
 type Characters struct {
 	Characters map[string]*Character
+	// OnLinksChange is called whenever a character is linked, unlinked,
+	// made primary or renamed, so the links can be saved.
+	OnLinksChange func()
+
+	mu            sync.Mutex
+	byLodestoneID map[int]*Character
+}
+
+// LinkRecord is a character linked to a Discord user, as it is saved.
+type LinkRecord struct {
+	LodestoneID int    `json:"lodestoneId"`
+	FirstName   string `json:"firstName"`
+	LastName    string `json:"lastName"`
+	World       string `json:"world"`
+	DiscordId   string `json:"discordId"`
+	Primary     bool   `json:"primary"`
 }
 
 type Character struct {
@@ -20,6 +40,12 @@ type Character struct {
 	LastName       string
 	LodestoneID    int
 	LastUpdateTime time.Time
+
+	// DiscordId is the Discord user who verified they own the character.
+	DiscordId string
+	// Primary is the character the Discord user is known by, when they own
+	// more than one.
+	Primary bool
 }
 
 func (cs *Characters) Init(world, firstName, lastName string) (*Character, error) {
@@ -31,13 +57,17 @@ func (cs *Characters) Init(world, firstName, lastName string) (*Character, error
 	}
 	name := firstName + " " + lastName
 
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
 	title := cases.Title(language.AmericanEnglish)
+	world = title.String(world)
 	char, ok := cs.Characters[name+"-"+world]
 	if !ok {
 		char = &Character{
 			FirstName: firstName,
 			LastName:  lastName,
-			World:     title.String(world),
+			World:     world,
 		}
 
 		cs.Characters[name+"-"+world] = char
@@ -46,6 +76,213 @@ func (cs *Characters) Init(world, firstName, lastName string) (*Character, error
 	return char, nil
 }
 
+// Identify keys the character by its Lodestone ID. If a character with the
+// same Lodestone ID is already known under another name or world, that
+// character is returned instead and the new entry is dropped, so the member
+// keeps everything recorded against it.
+func (cs *Characters) Identify(c *Character) *Character {
+	if c.LodestoneID == 0 {
+		return c
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if cs.byLodestoneID == nil {
+		cs.byLodestoneID = map[int]*Character{}
+	}
+	known, ok := cs.byLodestoneID[c.LodestoneID]
+	if !ok || known == c {
+		cs.byLodestoneID[c.LodestoneID] = c
+		return c
+	}
+
+	if cs.Characters[c.key()] == c {
+		delete(cs.Characters, c.key())
+	}
+	return known
+}
+
+// ByLodestoneID returns the character with the Lodestone ID, or nil if it is
+// not known.
+func (cs *Characters) ByLodestoneID(id int) *Character {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	return cs.byLodestoneID[id]
+}
+
+// Rename moves the character to its new name and world.
+func (cs *Characters) Rename(c *Character, firstName, lastName, world string) {
+	defer cs.linksChanged()
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if cs.Characters[c.key()] == c {
+		delete(cs.Characters, c.key())
+	}
+	c.FirstName = firstName
+	c.LastName = lastName
+	c.World = world
+	cs.Characters[c.key()] = c
+}
+
+func (c *Character) key() string {
+	return c.FirstName + " " + c.LastName + "-" + c.World
+}
+
+// ForDiscordId returns the characters the Discord user has verified they own,
+// their primary character first.
+func (cs *Characters) ForDiscordId(discordId string) []*Character {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	return cs.forDiscordId(discordId)
+}
+
+func (cs *Characters) forDiscordId(discordId string) []*Character {
+	chars := []*Character{}
+	for _, char := range cs.Characters {
+		if len(discordId) != 0 && char.DiscordId == discordId {
+			chars = append(chars, char)
+		}
+	}
+	sort.Slice(chars, func(i, j int) bool {
+		if chars[i].Primary != chars[j].Primary {
+			return chars[i].Primary
+		}
+		return chars[i].key() < chars[j].key()
+	})
+	return chars
+}
+
+// Linked returns every character some Discord user has verified they own.
+// The slice is a snapshot, so it can be ranged over while characters change.
+func (cs *Characters) Linked() []*Character {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	chars := []*Character{}
+	for _, char := range cs.Characters {
+		if len(char.DiscordId) != 0 {
+			chars = append(chars, char)
+		}
+	}
+	return chars
+}
+
+// Link records that the Discord user owns the character. A user's first
+// character becomes their primary one. It returns who the character was
+// linked to before, if that was someone else.
+func (cs *Characters) Link(c *Character, discordId string) string {
+	defer cs.linksChanged()
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if c.DiscordId == discordId {
+		return ""
+	}
+	previous := c.DiscordId
+	c.DiscordId = discordId
+	c.Primary = false
+	cs.ensurePrimary(previous)
+	cs.ensurePrimary(discordId)
+	return previous
+}
+
+// Unlink forgets who owns the character. If it was its owner's primary
+// character, another of theirs takes its place.
+func (cs *Characters) Unlink(c *Character) {
+	defer cs.linksChanged()
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	previous := c.DiscordId
+	c.DiscordId = ""
+	c.Primary = false
+	cs.ensurePrimary(previous)
+}
+
+// SetPrimary makes the character its owner's primary character.
+func (cs *Characters) SetPrimary(c *Character) {
+	defer cs.linksChanged()
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	for _, char := range cs.forDiscordId(c.DiscordId) {
+		char.Primary = char == c
+	}
+}
+
+func (cs *Characters) linksChanged() {
+	if cs.OnLinksChange != nil {
+		cs.OnLinksChange()
+	}
+}
+
+// Links returns every linked character as it is saved.
+func (cs *Characters) Links() []*LinkRecord {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	links := []*LinkRecord{}
+	for _, char := range cs.Characters {
+		if len(char.DiscordId) == 0 {
+			continue
+		}
+		links = append(links, &LinkRecord{
+			LodestoneID: char.LodestoneID,
+			FirstName:   char.FirstName,
+			LastName:    char.LastName,
+			World:       char.World,
+			DiscordId:   char.DiscordId,
+			Primary:     char.Primary,
+		})
+	}
+	sort.Slice(links, func(i, j int) bool {
+		if links[i].DiscordId != links[j].DiscordId {
+			return links[i].DiscordId < links[j].DiscordId
+		}
+		return links[i].LodestoneID < links[j].LodestoneID
+	})
+	return links
+}
+
+// RestoreLinks links the saved characters again.
+func (cs *Characters) RestoreLinks(links []*LinkRecord) error {
+	for _, link := range links {
+		char, err := cs.Init(link.World, link.FirstName, link.LastName)
+		if err != nil {
+			return fmt.Errorf("Could not restore %s %s (%s): %w", link.FirstName, link.LastName, link.World, err)
+		}
+		char.LodestoneID = link.LodestoneID
+		char = cs.Identify(char)
+
+		cs.mu.Lock()
+		char.DiscordId = link.DiscordId
+		char.Primary = link.Primary
+		cs.mu.Unlock()
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	for _, link := range links {
+		cs.ensurePrimary(link.DiscordId)
+	}
+	return nil
+}
+
+// ensurePrimary makes sure a Discord user with characters has exactly one
+// primary character.
+func (cs *Characters) ensurePrimary(discordId string) {
+	chars := cs.forDiscordId(discordId)
+	if len(chars) == 0 || chars[0].Primary {
+		return
+	}
+	chars[0].Primary = true
+}
+
 func (c *Character) UpdatedRecently() bool {
 	duration := time.Since(c.LastUpdateTime)
 	return duration.Minutes() <= 5.0
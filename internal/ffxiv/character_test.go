@@ -0,0 +1,60 @@
+package ffxiv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCharactersRename(t *testing.T) {
+	cs := &Characters{Characters: map[string]*Character{}}
+
+	old, err := cs.Init("gilgamesh", "Tataru", "Taru")
+	assert.NoError(t, err)
+	old.LodestoneID = 1
+	cs.Link(old, "123")
+	assert.Same(t, old, cs.Identify(old))
+
+	// After a rename the new name is a new entry until its Lodestone ID
+	// shows it is the same character.
+	renamed, err := cs.Init("Gilgamesh", "Tataru", "Tarutaru")
+	assert.NoError(t, err)
+	assert.NotSame(t, old, renamed)
+	renamed.LodestoneID = 1
+	assert.Same(t, old, cs.Identify(renamed))
+	assert.NotContains(t, cs.Characters, "Tataru Tarutaru-Gilgamesh")
+
+	cs.Rename(old, "Tataru", "Tarutaru", "Sargatanas")
+	assert.Equal(t, "Tataru Tarutaru", old.Name())
+	assert.Equal(t, "Sargatanas", old.World)
+	assert.Same(t, old, cs.Characters["Tataru Tarutaru-Sargatanas"])
+	assert.NotContains(t, cs.Characters, "Tataru Taru-Gilgamesh")
+	assert.Equal(t, []*Character{old}, cs.ForDiscordId("123"))
+}
+
+func TestCharactersPrimary(t *testing.T) {
+	cs := &Characters{Characters: map[string]*Character{}}
+	main, _ := cs.Init("Gilgamesh", "Tataru", "Taru")
+	alt, _ := cs.Init("Sargatanas", "Krile", "Baldesion")
+
+	cs.Link(main, "123")
+	cs.Link(alt, "123")
+	assert.True(t, main.Primary)
+	assert.False(t, alt.Primary)
+	assert.Equal(t, []*Character{main, alt}, cs.ForDiscordId("123"))
+
+	cs.SetPrimary(alt)
+	assert.Equal(t, []*Character{alt, main}, cs.ForDiscordId("123"))
+
+	// Someone else proving they own the primary character moves it to
+	// them, and the original owner's other character takes its place.
+	cs.Link(alt, "456")
+	assert.True(t, alt.Primary)
+	assert.True(t, main.Primary)
+	assert.Equal(t, []*Character{main}, cs.ForDiscordId("123"))
+
+	cs.Unlink(main)
+	assert.False(t, main.Primary)
+	assert.Empty(t, cs.ForDiscordId("123"))
+	assert.Empty(t, cs.ForDiscordId(""))
+}
@@ -1,75 +1,235 @@
 package lodestone
 
 import (
+	"errors"
 	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Veraticus/clearingway/internal/ffxiv"
+
+	"github.com/PuerkitoBio/goquery"
 	"github.com/gocolly/colly"
 )
 
-var characterLodestoneUrl = "https://na.finalfantasyxiv.com/lodestone/character/"
+var (
+	// AchievementPageConcurrency is how many achievement pages are asked for
+	// at once. The client still spaces the requests out.
+	AchievementPageConcurrency = 3
+	// AchievementCacheTTL is how long a character's achievements are used
+	// without checking the Lodestone for new ones.
+	AchievementCacheTTL = time.Hour
+)
+
+// achievementRegexp finds the achievement's name in an entry, within the
+// quotes each region uses: "", “”, „“, « » or 「」.
+var achievementRegexp = regexp.MustCompile(`["“„«「]\s*(.*?)\s*["”“»」]`)
+
+var achievementCache = NewAchievementCache()
+
+// AchievementCache holds the achievements already scraped for each Lodestone
+// ID, newest first, so later lookups only need the pages with new ones.
+type AchievementCache struct {
+	mu      sync.Mutex
+	entries map[int]*achievementCacheEntry
+}
+
+type achievementCacheEntry struct {
+	achievements []string
+	fetched      time.Time
+}
+
+func NewAchievementCache() *AchievementCache {
+	return &AchievementCache{entries: map[int]*achievementCacheEntry{}}
+}
+
+// GetAchievements returns the names of every achievement the character has
+// earned, newest first. Achievement names are always in English, from the
+// English Lodestone site closest to the region.
+func GetAchievements(c *ffxiv.Character, region Region) ([]string, error) {
+	if c.LodestoneID == 0 {
+		return nil, fmt.Errorf("Lodestone ID not set for %s (%s)!", c.Name(), c.World)
+	}
+
+	return achievementCache.Get(c.LodestoneID, region.English())
+}
+
+// Get returns the achievements for a Lodestone ID. Cached achievements newer
+// than AchievementCacheTTL are returned as they are; otherwise the Lodestone
+// is only walked until the first achievement already in the cache.
+func (ac *AchievementCache) Get(lodestoneID int, region Region) ([]string, error) {
+	ac.mu.Lock()
+	entry := ac.entries[lodestoneID]
+	ac.mu.Unlock()
+
+	if entry != nil && time.Since(entry.fetched) < AchievementCacheTTL {
+		return entry.achievements, nil
+	}
+
+	known := map[string]bool{}
+	cached := []string{}
+	if entry != nil {
+		cached = entry.achievements
+		for _, achievement := range cached {
+			known[achievement] = true
+		}
+	}
+
+	fresh, err := fetchAchievements(lodestoneID, region, known)
+	if err != nil {
+		return nil, err
+	}
+
+	achievements := make([]string, 0, len(fresh)+len(cached))
+	achievements = append(achievements, fresh...)
+	achievements = append(achievements, cached...)
+
+	ac.mu.Lock()
+	ac.entries[lodestoneID] = &achievementCacheEntry{achievements: achievements, fetched: time.Now()}
+	ac.mu.Unlock()
+
+	return achievements, nil
+}
+
+type achievementPage struct {
+	achievements []string
+	maxPages     int
+}
+
+// fetchAchievements walks a character's achievement pages, newest first, and
+// returns the achievements until the first known one. Pages after the first
+// are fetched AchievementPageConcurrency at a time.
+func fetchAchievements(lodestoneID int, region Region, known map[string]bool) ([]string, error) {
+	firstPages, err := fetchAchievementPages(lodestoneID, region, []int{1})
+	if err != nil {
+		return nil, err
+	}
+	firstPage := firstPages[1]
+
+	fresh := []string{}
+	done := firstPage.appendUntilKnown(&fresh, known)
+	for next := 2; !done && next <= firstPage.maxPages; next += AchievementPageConcurrency {
+		batch := []int{}
+		for page := next; page < next+AchievementPageConcurrency && page <= firstPage.maxPages; page++ {
+			batch = append(batch, page)
+		}
 
-func isInList(found_link string, link_list []string) bool {
-	for _, stored_link := range link_list {
-		if stored_link == found_link {
+		pages, err := fetchAchievementPages(lodestoneID, region, batch)
+		if err != nil {
+			return nil, err
+		}
+		for _, page := range batch {
+			done = pages[page].appendUntilKnown(&fresh, known)
+			if done {
+				break
+			}
+		}
+	}
+
+	return fresh, nil
+}
+
+// appendUntilKnown appends the page's achievements to fresh up to the first
+// known one, returning whether it found one.
+func (p *achievementPage) appendUntilKnown(fresh *[]string, known map[string]bool) bool {
+	for _, achievement := range p.achievements {
+		if known[achievement] {
 			return true
 		}
+		*fresh = append(*fresh, achievement)
 	}
 	return false
 }
 
-func GetAchievements(c *ffxiv.Character) ([]string, error) {
-	achievements := []string{}
-	visited := []string{}
-	links := []string{}
-	errors := []error{}
+func fetchAchievementPages(lodestoneID int, region Region, pageNumbers []int) (map[int]*achievementPage, error) {
+	mu := sync.Mutex{}
+	pages := map[int]*achievementPage{}
+	errs := []error{}
 
-	searchUrl := fmt.Sprintf((characterLodestoneUrl + "%s/achievement"), strconv.Itoa(c.LodestoneID))
-	links = append(links, searchUrl)
-	scraper := colly.NewCollector()
+	collector := DefaultClient.newCollector()
 
-	for len(links) != 0 {
-		scraper.OnError(func(_ *colly.Response, err error) {
-			errors = append(errors, err)
-		})
+	collector.OnHTML("html", func(e *colly.HTMLElement) {
+		page, err := parseAchievementPage(e.DOM)
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		n, _ := strconv.Atoi(e.Request.URL.Query().Get("page"))
+		pages[n] = page
+	})
 
-		err := scraper.Limit(&colly.LimitRule{
-			Delay: 3 * time.Second,
-		})
+	collector.OnError(func(resp *colly.Response, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		err = responseError(resp, err)
+		if errors.Is(err, ErrPrivate) {
+			err = newError(ErrPrivate, "Achievements are private on the Lodestone.")
+		}
+		errs = append(errs, err)
+	})
+
+	for _, n := range pageNumbers {
+		err := collector.Visit(fmt.Sprintf("%sachievement/?page=%d", region.CharacterURL(lodestoneID), n))
 		if err != nil {
-			errors = append(errors, err)
+			return nil, fmt.Errorf("Could not visit Lodestone: %w", err)
 		}
+	}
+	collector.Wait()
 
-		scraper.OnHTML("li.entry", func(e *colly.HTMLElement) {
+	if len(errs) != 0 {
+		return nil, buildError(errs)
+	}
+	for _, n := range pageNumbers {
+		if pages[n] == nil {
+			return nil, markupChanged("achievements", "page")
+		}
+	}
+
+	return pages, nil
+}
 
-			r, _ := regexp.Compile("\"(.*?)\"")
+// parseAchievementPage returns the achievements on a page of a character's
+// achievements, newest first, and how many pages there are.
+func parseAchievementPage(doc *goquery.Selection) (*achievementPage, error) {
+	err := checkMaintenance(doc)
+	if err != nil {
+		return nil, err
+	}
 
-			name := r.FindString(string(e.ChildText(".entry__activity__txt")))
-			achievement := name
+	// The same block is shown for characters without any achievements.
+	zero := doc.Find(".parts__zero")
+	if strings.Contains(strings.ToLower(zero.Text()), "private") {
+		return nil, newError(ErrPrivate, "Achievements are private on the Lodestone.")
+	}
 
-			achievements = append(achievements, achievement)
-		})
+	entries := doc.Find("li.entry")
+	if entries.Length() == 0 && zero.Length() == 0 {
+		return nil, markupChanged("achievements", "li.entry")
+	}
 
-		scraper.OnHTML("li", func(e *colly.HTMLElement) {
-			link := e.ChildAttr("a", "href")
-			if !isInList(link, links) && !isInList(link, visited) && strings.Contains(link, characterLodestoneUrl) {
-				links = append(links, link)
-			}
-		})
+	page := &achievementPage{achievements: []string{}, maxPages: 1}
+	entries.Each(func(_ int, entry *goquery.Selection) {
+		match := achievementRegexp.FindStringSubmatch(entry.Find(".entry__activity__txt").Text())
+		if match != nil {
+			page.achievements = append(page.achievements, match[1])
+		}
+	})
+	if entries.Length() != 0 && len(page.achievements) == 0 {
+		return nil, markupChanged("achievements", ".entry__activity__txt")
+	}
 
-		visit_link := links[0]
-		err = scraper.Visit(visit_link)
+	pager := doc.Find("ul.btn__pager .btn__pager__current").First()
+	if pager.Length() != 0 {
+		_, page.maxPages, err = parsePager(strings.TrimSpace(pager.Text()))
 		if err != nil {
-			errors = append(errors, err)
-			return nil, buildError(errors)
+			return nil, markupChanged("achievements", "page numbers")
 		}
-		_, links = links[0], links[1:]
-		visited = append(visited, visit_link)
 	}
 
-	return achievements, nil
+	return page, nil
 }
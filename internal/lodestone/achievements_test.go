@@ -0,0 +1,84 @@
+package lodestone
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func achievementPageHTML(page, maxPages int, achievements ...string) string {
+	html := strings.Builder{}
+	html.WriteString("<html><body><ul>")
+	for _, achievement := range achievements {
+		html.WriteString(fmt.Sprintf(
+			`<li class="entry"><p class="entry__activity__txt">Tataru Taru earned the achievement "%s"!</p></li>`,
+			achievement,
+		))
+	}
+	html.WriteString(fmt.Sprintf(`</ul><ul class="btn__pager"><li class="btn__pager__current">Page %d of %d</li></ul>`, page, maxPages))
+	html.WriteString("</body></html>")
+	return html.String()
+}
+
+func TestAchievementCache(t *testing.T) {
+	pages := map[string]string{
+		"1": achievementPageHTML(1, 3, "Newest", "Lone Hero"),
+		"2": achievementPageHTML(2, 3, "The Necromancer", "Pal-less Palace III"),
+		"3": achievementPageHTML(3, 3, "Oldest"),
+	}
+	requests := atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if strings.HasPrefix(r.URL.Path, "/eu/character/2/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, pages[r.URL.Query().Get("page")])
+	}))
+	defer server.Close()
+
+	oldUrl, oldClient, oldTTL := lodestoneUrlFormat, DefaultClient, AchievementCacheTTL
+	lodestoneUrlFormat, DefaultClient, AchievementCacheTTL = server.URL+"/%s", testClient(), 0
+	defer func() {
+		lodestoneUrlFormat, DefaultClient, AchievementCacheTTL = oldUrl, oldClient, oldTTL
+	}()
+
+	cache := NewAchievementCache()
+	achievements, err := cache.Get(1, RegionEU)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Newest", "Lone Hero", "The Necromancer", "Pal-less Palace III", "Oldest"}, achievements)
+	assert.Equal(t, int32(3), requests.Load())
+
+	// Only the first page is needed once its achievements are known.
+	pages["1"] = achievementPageHTML(1, 3, "Brand New", "Newest", "Lone Hero")
+	achievements, err = cache.Get(1, RegionEU)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Brand New", "Newest", "Lone Hero", "The Necromancer", "Pal-less Palace III", "Oldest"}, achievements)
+	assert.Equal(t, int32(4), requests.Load())
+
+	_, err = cache.Get(2, RegionEU)
+	assert.ErrorIs(t, err, ErrPrivate)
+}
+
+func TestGetCharacterProfileWithoutHTML(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, "{}")
+	}))
+	defer server.Close()
+
+	oldUrl, oldClient := lodestoneUrlFormat, DefaultClient
+	lodestoneUrlFormat, DefaultClient = server.URL+"/%s", testClient()
+	defer func() {
+		lodestoneUrlFormat, DefaultClient = oldUrl, oldClient
+	}()
+
+	// A page without HTML is an error, not a profile.
+	_, err := GetCharacterProfileByID(1, RegionEU)
+	assert.ErrorIs(t, err, ErrMarkupChanged)
+}
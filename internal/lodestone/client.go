@@ -0,0 +1,367 @@
+package lodestone
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gocolly/colly"
+	"github.com/temoto/robotstxt"
+)
+
+// DefaultClient makes every request to the Lodestone, so that all of
+// Clearingway shares one rate limit however many commands are running.
+var DefaultClient = NewClient()
+
+// Client is an http.RoundTripper that is polite to the Lodestone: it spaces
+// requests out, caps how many are in flight, identifies itself, honors
+// robots.txt, and backs off and retries when the Lodestone is throttling or
+// struggling.
+type Client struct {
+	// UserAgent identifies Clearingway to the Lodestone.
+	UserAgent string
+	// Interval is the least time between the start of two requests.
+	Interval time.Duration
+	// MaxConcurrent is the most requests in flight at once.
+	MaxConcurrent int
+	// MaxRetries is how many times a throttled or failed request is retried.
+	MaxRetries int
+	// Backoff is how long to wait before the first retry. It doubles for
+	// each retry after that, up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// AttemptTimeout is how long each attempt at a request may take, from
+	// sending it to reading the whole response. Time spent waiting for a
+	// turn or backing off does not count.
+	AttemptTimeout time.Duration
+	// Timeout is how long a request may take altogether, waits and retries
+	// included.
+	Timeout time.Duration
+	// RespectRobotsTxt refuses requests the site's robots.txt disallows.
+	RespectRobotsTxt bool
+	// RobotsTxtTTL is how long a site's robots.txt is used before it is
+	// fetched again. If it could not be fetched, it is tried again after
+	// RobotsTxtRetry instead.
+	RobotsTxtTTL   time.Duration
+	RobotsTxtRetry time.Duration
+	// Transport makes the requests, or http.DefaultTransport if it is nil.
+	Transport http.RoundTripper
+
+	initOnce sync.Once
+	slots    chan struct{}
+
+	mu     sync.Mutex
+	next   time.Time
+	robots map[string]*robotsEntry
+
+	requests     atomic.Int64
+	retries      atomic.Int64
+	throttled    atomic.Int64
+	serverErrors atomic.Int64
+	failures     atomic.Int64
+	disallowed   atomic.Int64
+	inFlight     atomic.Int64
+}
+
+type robotsEntry struct {
+	group   *robotstxt.Group
+	expires time.Time
+}
+
+func NewClient() *Client {
+	return &Client{
+		UserAgent:        "Clearingway (+https://github.com/Veraticus/clearingway)",
+		Interval:         500 * time.Millisecond,
+		MaxConcurrent:    4,
+		MaxRetries:       3,
+		Backoff:          2 * time.Second,
+		MaxBackoff:       30 * time.Second,
+		AttemptTimeout:   30 * time.Second,
+		Timeout:          10 * time.Minute,
+		RespectRobotsTxt: true,
+		RobotsTxtTTL:     24 * time.Hour,
+		RobotsTxtRetry:   5 * time.Minute,
+	}
+}
+
+// Metrics counts what the client has done since it was created.
+type Metrics struct {
+	// Requests is every request sent, including retries and robots.txt.
+	Requests int64
+	// Retries is how many requests were sent again after failing.
+	Retries int64
+	// Throttled is how many responses were 429 Too Many Requests.
+	Throttled int64
+	// ServerErrors is how many responses were 5xx.
+	ServerErrors int64
+	// Failures is how many requests got no response at all.
+	Failures int64
+	// Disallowed is how many requests robots.txt refused.
+	Disallowed int64
+	// InFlight is how many requests are being made right now.
+	InFlight int64
+}
+
+func (m Metrics) String() string {
+	return fmt.Sprintf(
+		"%d requests (%d in flight), %d retries, %d throttled, %d server errors, %d failures, %d disallowed by robots.txt",
+		m.Requests,
+		m.InFlight,
+		m.Retries,
+		m.Throttled,
+		m.ServerErrors,
+		m.Failures,
+		m.Disallowed,
+	)
+}
+
+func (c *Client) Metrics() Metrics {
+	return Metrics{
+		Requests:     c.requests.Load(),
+		Retries:      c.retries.Load(),
+		Throttled:    c.throttled.Load(),
+		ServerErrors: c.serverErrors.Load(),
+		Failures:     c.failures.Load(),
+		Disallowed:   c.disallowed.Load(),
+		InFlight:     c.inFlight.Load(),
+	}
+}
+
+// newCollector returns a collector whose requests all go through the client.
+func (c *Client) newCollector() *colly.Collector {
+	collector := colly.NewCollector(colly.Async(true), colly.UserAgent(c.UserAgent))
+	// Each attempt has its own timeout in RoundTrip. This one only stops a
+	// request that has been queued and retried for far too long.
+	collector.SetRequestTimeout(c.Timeout)
+	collector.WithTransport(c)
+	// The client checks robots.txt itself, once per site rather than once
+	// per collector.
+	collector.IgnoreRobotsTxt = true
+	return collector
+}
+
+func (c *Client) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.initOnce.Do(func() {
+		c.slots = make(chan struct{}, max(c.MaxConcurrent, 1))
+		c.robots = map[string]*robotsEntry{}
+	})
+
+	if c.RespectRobotsTxt && req.URL.Path != "/robots.txt" && !c.allowed(req) {
+		c.disallowed.Add(1)
+		return nil, newError(ErrDisallowed, "The Lodestone's robots.txt does not allow %s.", req.URL.Path)
+	}
+
+	select {
+	case c.slots <- struct{}{}:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+	defer func() { <-c.slots }()
+	c.inFlight.Add(1)
+	defer c.inFlight.Add(-1)
+
+	if len(req.Header.Get("User-Agent")) == 0 {
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := c.wait(req)
+		if err != nil {
+			return nil, err
+		}
+
+		c.requests.Add(1)
+		resp, err := c.attempt(req)
+		if err != nil {
+			c.failures.Add(1)
+			// An attempt that timed out is worth another try, as long as
+			// whoever asked is still waiting.
+			if !errors.Is(err, context.DeadlineExceeded) || req.Context().Err() != nil || attempt >= c.MaxRetries {
+				return nil, err
+			}
+			if req.Body != nil && req.GetBody == nil {
+				return nil, err
+			}
+			c.retries.Add(1)
+			req, err = c.rewind(req)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		throttled := resp.StatusCode == http.StatusTooManyRequests
+		if throttled {
+			c.throttled.Add(1)
+		} else if resp.StatusCode >= 500 {
+			c.serverErrors.Add(1)
+		} else {
+			return resp, nil
+		}
+		// Requests without a body can always be sent again.
+		if attempt >= c.MaxRetries || (req.Body != nil && req.GetBody == nil) {
+			return resp, nil
+		}
+
+		delay := c.backoff(attempt, resp)
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if throttled {
+			// Everyone waits, not just this request.
+			c.delayAll(delay)
+		}
+		c.retries.Add(1)
+
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		req, err = c.rewind(req)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// attempt sends the request once, giving up after AttemptTimeout. The
+// timeout keeps running while the response body is read, and stops once it
+// is closed.
+func (c *Client) attempt(req *http.Request) (*http.Response, error) {
+	if c.AttemptTimeout == 0 {
+		return c.transport().RoundTrip(req)
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), c.AttemptTimeout)
+	resp, err := c.transport().RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// rewind gets the request ready to be sent again.
+func (c *Client) rewind(req *http.Request) (*http.Request, error) {
+	if req.GetBody == nil {
+		return req, nil
+	}
+	req = req.Clone(req.Context())
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = body
+	return req, nil
+}
+
+func (c *Client) transport() http.RoundTripper {
+	if c.Transport != nil {
+		return c.Transport
+	}
+	return http.DefaultTransport
+}
+
+// wait blocks until the request's turn under the client's request rate.
+func (c *Client) wait(req *http.Request) error {
+	c.mu.Lock()
+	now := time.Now()
+	start := c.next
+	if start.Before(now) {
+		start = now
+	}
+	c.next = start.Add(c.Interval)
+	c.mu.Unlock()
+
+	select {
+	case <-time.After(time.Until(start)):
+		return nil
+	case <-req.Context().Done():
+		return req.Context().Err()
+	}
+}
+
+// delayAll holds every request back for at least delay.
+func (c *Client) delayAll(delay time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if until := time.Now().Add(delay); c.next.Before(until) {
+		c.next = until
+	}
+}
+
+// backoff is how long to wait before retrying: what the Lodestone asked for
+// with Retry-After if it did, or the exponential backoff otherwise.
+func (c *Client) backoff(attempt int, resp *http.Response) time.Duration {
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	delay := c.Backoff << attempt
+	if c.MaxBackoff != 0 && (delay > c.MaxBackoff || delay <= 0) {
+		delay = c.MaxBackoff
+	}
+	return delay
+}
+
+// allowed checks the request against its site's robots.txt, fetching it if
+// it is not cached. A robots.txt that cannot be fetched allows everything,
+// since the Lodestone being down is no reason to refuse requests once it is
+// back up.
+func (c *Client) allowed(req *http.Request) bool {
+	host := req.URL.Scheme + "://" + req.URL.Host
+	c.mu.Lock()
+	entry := c.robots[host]
+	c.mu.Unlock()
+
+	if entry == nil || time.Now().After(entry.expires) {
+		entry = c.fetchRobots(req, host)
+		c.mu.Lock()
+		c.robots[host] = entry
+		c.mu.Unlock()
+	}
+
+	return entry.group == nil || entry.group.Test(req.URL.RequestURI())
+}
+
+func (c *Client) fetchRobots(req *http.Request, host string) *robotsEntry {
+	failed := &robotsEntry{expires: time.Now().Add(c.RobotsTxtRetry)}
+
+	robotsReq, err := http.NewRequestWithContext(req.Context(), http.MethodGet, host+"/robots.txt", nil)
+	if err != nil {
+		return failed
+	}
+	robotsReq.Header.Set("User-Agent", c.UserAgent)
+	resp, err := c.RoundTrip(robotsReq)
+	if err != nil {
+		return failed
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return failed
+	}
+	robots, err := robotstxt.FromResponse(resp)
+	if err != nil {
+		return failed
+	}
+
+	return &robotsEntry{group: robots.FindGroup(c.UserAgent), expires: time.Now().Add(c.RobotsTxtTTL)}
+}
@@ -0,0 +1,148 @@
+package lodestone
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testClient does not wait between requests or check robots.txt.
+func testClient() *Client {
+	c := NewClient()
+	c.Interval = 0
+	c.Backoff = time.Millisecond
+	c.RespectRobotsTxt = false
+	return c
+}
+
+func get(t *testing.T, c *Client, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, url, nil)
+	assert.NoError(t, err)
+	return c.RoundTrip(req)
+}
+
+func TestClientRetries(t *testing.T) {
+	requests := atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, NewClient().UserAgent, r.Header.Get("User-Agent"))
+		switch requests.Add(1) {
+		case 1:
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer server.Close()
+
+	c := testClient()
+	resp, err := get(t, c, server.URL+"/lodestone/character/1/")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp.Body.Close()
+
+	metrics := c.Metrics()
+	assert.Equal(t, int64(3), metrics.Requests)
+	assert.Equal(t, int64(2), metrics.Retries)
+	assert.Equal(t, int64(1), metrics.Throttled)
+	assert.Equal(t, int64(1), metrics.ServerErrors)
+
+	// Once the retries run out the last response is returned.
+	c.MaxRetries = 0
+	requests.Store(0)
+	resp, err = get(t, c, server.URL+"/lodestone/character/1/")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	resp.Body.Close()
+}
+
+func TestClientConcurrency(t *testing.T) {
+	inFlight, most := atomic.Int32{}, atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			m := most.Load()
+			if n <= m || most.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	c := testClient()
+	c.MaxConcurrent = 2
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := get(t, c, server.URL+"/lodestone/character/1/")
+			if assert.NoError(t, err) {
+				resp.Body.Close()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.LessOrEqual(t, most.Load(), int32(2))
+	assert.Equal(t, int64(8), c.Metrics().Requests)
+}
+
+func TestClientRobotsTxt(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			w.Write([]byte("User-agent: *\nDisallow: /lodestone/my/\n"))
+		}
+	}))
+	defer server.Close()
+
+	c := testClient()
+	c.RespectRobotsTxt = true
+	resp, err := get(t, c, server.URL+"/lodestone/character/1/")
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	_, err = get(t, c, server.URL+"/lodestone/my/setting/profile/")
+	assert.ErrorIs(t, err, ErrDisallowed)
+
+	// robots.txt is only fetched once.
+	metrics := c.Metrics()
+	assert.Equal(t, int64(2), metrics.Requests)
+	assert.Equal(t, int64(1), metrics.Disallowed)
+}
+
+func TestClientAttemptTimeout(t *testing.T) {
+	requests := atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch requests.Add(1) {
+		case 1:
+			// Too slow for one attempt.
+			time.Sleep(100 * time.Millisecond)
+		case 2:
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer server.Close()
+
+	// Backing off takes longer than an attempt may, which only counts
+	// against the overall timeout.
+	c := testClient()
+	c.AttemptTimeout = 20 * time.Millisecond
+	c.Backoff = 40 * time.Millisecond
+	resp, err := get(t, c, server.URL+"/lodestone/character/1/")
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		resp.Body.Close()
+	}
+	assert.Equal(t, int64(3), c.Metrics().Requests)
+	assert.Equal(t, int64(2), c.Metrics().Retries)
+}
@@ -0,0 +1,99 @@
+package lodestone
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/gocolly/colly"
+)
+
+// The kinds of Lodestone failures. Errors returned by this package can be
+// matched against them with errors.Is.
+var (
+	ErrNotFound      = errors.New("Not found on the Lodestone")
+	ErrPrivate       = errors.New("Private on the Lodestone")
+	ErrMaintenance   = errors.New("The Lodestone is under maintenance")
+	ErrMarkupChanged = errors.New("The Lodestone's markup has changed")
+	ErrAmbiguous     = errors.New("More than one character matches on the Lodestone")
+	ErrDisallowed    = errors.New("The Lodestone's robots.txt disallows the request")
+)
+
+// Error is a Lodestone failure of one of the kinds above, with a message for
+// whoever asked.
+type Error struct {
+	Kind    error
+	Message string
+}
+
+func newError(kind error, format string, args ...any) *Error {
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, args...)}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+// AmbiguousError is returned when a search finds more than one character with
+// the name, so whoever asked can pick one of the candidates.
+type AmbiguousError struct {
+	Name       string
+	World      string
+	Candidates []*SearchResult
+}
+
+func (e *AmbiguousError) Error() string {
+	return fmt.Sprintf(
+		"Too many characters found for name %s (%s)! Pick your character, or give your Lodestone profile URL instead.",
+		e.Name,
+		e.World,
+	)
+}
+
+func (e *AmbiguousError) Unwrap() error {
+	return ErrAmbiguous
+}
+
+// checkMaintenance returns an error if the page is the Lodestone's
+// maintenance page. It is recognized by its maintenance notice rather than
+// its title, since character and free company pages are titled with names
+// that could contain any word. A maintenance page served with a 503 is caught
+// by responseError instead.
+func checkMaintenance(doc *goquery.Selection) error {
+	if doc.Find("div.maintenance").Length() != 0 {
+		return newError(ErrMaintenance, "The Lodestone is down for maintenance; please try again once it is back up.")
+	}
+	return nil
+}
+
+// markupChanged is returned when a page is missing something every page of
+// its kind has, which most likely means Square Enix changed the Lodestone.
+func markupChanged(page, missing string) error {
+	return newError(
+		ErrMarkupChanged,
+		"Could not read the Lodestone's %s page (%s not found). The Lodestone may have changed; please let the Clearingway maintainers know.",
+		page,
+		missing,
+	)
+}
+
+// responseError describes a failed Lodestone request.
+func responseError(resp *colly.Response, err error) error {
+	if resp == nil {
+		return fmt.Errorf("Could not reach the Lodestone: %w", err)
+	}
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return newError(ErrNotFound, "%s was not found on the Lodestone.", resp.Request.URL)
+	case http.StatusForbidden:
+		return newError(ErrPrivate, "%s is private on the Lodestone.", resp.Request.URL)
+	case http.StatusServiceUnavailable:
+		return newError(ErrMaintenance, "The Lodestone is down for maintenance; please try again once it is back up.")
+	}
+	return fmt.Errorf("Could not reach the Lodestone: %w", err)
+}
@@ -0,0 +1,162 @@
+package lodestone
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/gocolly/colly"
+)
+
+// FreeCompanyCacheTTL is how long a free company's member list is reused, so
+// that /clears does not walk it for every member.
+var FreeCompanyCacheTTL = 15 * time.Minute
+
+var freeCompanyCache = &struct {
+	mu      sync.Mutex
+	entries map[string]*freeCompanyCacheEntry
+}{entries: map[string]*freeCompanyCacheEntry{}}
+
+type freeCompanyCacheEntry struct {
+	members map[int]bool
+	fetched time.Time
+}
+
+// GetFreeCompanyMembers returns the Lodestone IDs of every member of a free
+// company, reusing a list fetched in the last FreeCompanyCacheTTL.
+func GetFreeCompanyMembers(freeCompanyID string, region Region) (map[int]bool, error) {
+	key := string(region) + "-" + freeCompanyID
+	freeCompanyCache.mu.Lock()
+	entry := freeCompanyCache.entries[key]
+	freeCompanyCache.mu.Unlock()
+	if entry != nil && time.Since(entry.fetched) < FreeCompanyCacheTTL {
+		return entry.members, nil
+	}
+
+	firstPages, err := fetchFreeCompanyMemberPages(freeCompanyID, region, []int{1})
+	if err != nil {
+		return nil, err
+	}
+	members := map[int]bool{}
+	for _, id := range firstPages[1].memberIDs {
+		members[id] = true
+	}
+
+	rest := []int{}
+	for n := 2; n <= firstPages[1].maxPages; n++ {
+		rest = append(rest, n)
+	}
+	if len(rest) != 0 {
+		pages, err := fetchFreeCompanyMemberPages(freeCompanyID, region, rest)
+		if err != nil {
+			return nil, err
+		}
+		for _, page := range pages {
+			for _, id := range page.memberIDs {
+				members[id] = true
+			}
+		}
+	}
+
+	freeCompanyCache.mu.Lock()
+	freeCompanyCache.entries[key] = &freeCompanyCacheEntry{members: members, fetched: time.Now()}
+	freeCompanyCache.mu.Unlock()
+
+	return members, nil
+}
+
+type freeCompanyMemberPage struct {
+	memberIDs []int
+	maxPages  int
+}
+
+func fetchFreeCompanyMemberPages(freeCompanyID string, region Region, pageNumbers []int) (map[int]*freeCompanyMemberPage, error) {
+	mu := sync.Mutex{}
+	pages := map[int]*freeCompanyMemberPage{}
+	errs := []error{}
+
+	collector := DefaultClient.newCollector()
+
+	collector.OnHTML("html", func(e *colly.HTMLElement) {
+		page, err := parseFreeCompanyMemberPage(e.DOM)
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		n, _ := strconv.Atoi(e.Request.URL.Query().Get("page"))
+		pages[n] = page
+	})
+
+	collector.OnError(func(resp *colly.Response, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		errs = append(errs, responseError(resp, err))
+	})
+
+	for _, n := range pageNumbers {
+		err := collector.Visit(fmt.Sprintf("%s/freecompany/%s/member/?page=%d", region.URL(), freeCompanyID, n))
+		if err != nil {
+			return nil, fmt.Errorf("Could not visit Lodestone: %w", err)
+		}
+	}
+	collector.Wait()
+
+	if len(errs) != 0 {
+		return nil, buildError(errs)
+	}
+	for _, n := range pageNumbers {
+		if pages[n] == nil {
+			return nil, markupChanged("free company members", "page")
+		}
+	}
+
+	return pages, nil
+}
+
+// parseFreeCompanyMemberPage returns the Lodestone IDs of the members on a
+// page of a free company's member list, and how many pages there are.
+func parseFreeCompanyMemberPage(doc *goquery.Selection) (*freeCompanyMemberPage, error) {
+	err := checkMaintenance(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	window := doc.Find(".ldst__window")
+	if window.Length() == 0 {
+		return nil, markupChanged("free company members", ".ldst__window")
+	}
+	entries := window.Find("li.entry")
+	if entries.Length() == 0 {
+		return nil, markupChanged("free company members", "li.entry")
+	}
+
+	page := &freeCompanyMemberPage{memberIDs: []int{}, maxPages: 1}
+	entries.EachWithBreak(func(_ int, entry *goquery.Selection) bool {
+		href, _ := entry.Find("a.entry__bg").Attr("href")
+		var charID int
+		_, scanErr := fmt.Sscanf(href, "/lodestone/character/%d/", &charID)
+		if scanErr != nil {
+			err = markupChanged("free company members", "character link")
+			return false
+		}
+		page.memberIDs = append(page.memberIDs, charID)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	pager := window.Find("ul.btn__pager .btn__pager__current").First()
+	if pager.Length() != 0 {
+		_, page.maxPages, err = parsePager(pager.Text())
+		if err != nil {
+			return nil, markupChanged("free company members", "page numbers")
+		}
+	}
+
+	return page, nil
+}
@@ -1,66 +1,83 @@
 package lodestone
 
 import (
+	"errors"
 	"fmt"
 	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
-	"time"
+	"sync"
 
 	"github.com/Veraticus/clearingway/internal/ffxiv"
 
+	"github.com/PuerkitoBio/goquery"
 	"github.com/gocolly/colly"
 )
 
-var lodestoneUrl = "https://na.finalfantasyxiv.com/lodestone"
-
-func SetCharacterLodestoneID(c *ffxiv.Character) error {
+func SetCharacterLodestoneID(c *ffxiv.Character, region Region) error {
 	if c.LodestoneID != 0 {
 		return nil
 	}
 	fmt.Printf("Lodestone ID not set for %s (%s), checking the Lodestone...\n", c.Name(), c.World)
 
-	collector := colly.NewCollector(colly.Async(true))
-	collector.SetRequestTimeout(30 * time.Second)
-	charIDs := []int{}
+	results, err := SearchCharacters(c.Name(), c.World, region)
+	if err != nil {
+		return err
+	}
+
+	if len(results) == 0 {
+		return newError(
+			ErrNotFound,
+			"No character found on the Lodestone for `%v (%v)`! If you recently renamed yourself or server transferred it can take up to a day for this to be reflected on the Lodestone; please try again later.",
+			c.Name(),
+			c.World,
+		)
+	}
+	if len(results) > 1 {
+		return &AmbiguousError{Name: c.Name(), World: c.World, Candidates: results}
+	}
+
+	c.LodestoneID = results[0].LodestoneID
+
+	return nil
+}
+
+// SearchResult is a character found by a Lodestone search.
+type SearchResult struct {
+	LodestoneID int
+	Name        string
+	World       string
+	Avatar      string
+}
+
+// SearchCharacters returns every character on the world whose name is
+// exactly name, from every page of the Lodestone's search results.
+func SearchCharacters(name, world string, region Region) ([]*SearchResult, error) {
+	collector := DefaultClient.newCollector()
+	mu := sync.Mutex{}
+	results := []*SearchResult{}
 	errors := []error{}
 	spawnedChildren := false
 	searchUrl := fmt.Sprintf(
 		"/character/?q=%v&worldname=%v",
-		url.QueryEscape(c.Name()),
-		c.World,
+		url.QueryEscape(name),
+		world,
 	)
 
-	collector.OnHTML(".ldst__window .entry", func(e *colly.HTMLElement) {
-		name := e.ChildText(".entry__name")
-		if strings.EqualFold(name, c.Name()) {
-			linkText := e.ChildAttr(".entry__link", "href")
-			var charID int
-			n, err := fmt.Sscanf(linkText, "/lodestone/character/%d/", &charID)
-			if n == 0 {
-				errors = append(errors, fmt.Errorf("Could not find character ID!"))
-			}
-			if err != nil {
-				errors = append(errors, fmt.Errorf("Could not parse lodestone URL: %w", err))
-			}
-			charIDs = append(charIDs, charID)
-		}
-	})
-
-	collector.OnHTML(".ldst__window ul.btn__pager", func(e *colly.HTMLElement) {
-		var currentPage int
-		var maxPages int
-		pages := e.ChildText(".btn__pager__current")
-		n, err := fmt.Sscanf(pages, "Page %d of %d", &currentPage, &maxPages)
-		if n == 0 {
-			errors = append(errors, fmt.Errorf("Could not find pager!"))
-		}
+	collector.OnHTML("html", func(e *colly.HTMLElement) {
+		page, err := parseSearchPage(e.DOM, name)
+		mu.Lock()
+		defer mu.Unlock()
 		if err != nil {
-			errors = append(errors, fmt.Errorf("Could not parse pager: %w", err))
+			errors = append(errors, err)
+			return
 		}
-		if !spawnedChildren && currentPage == 1 && maxPages != 1 {
+		results = append(results, page.results...)
+		if !spawnedChildren && page.currentPage == 1 && page.maxPages > 1 {
 			spawnedChildren = true
-			for i := 2; i <= maxPages; i++ {
-				err = e.Request.Visit(lodestoneUrl + searchUrl + fmt.Sprintf("&page=%d", i))
+			for i := 2; i <= page.maxPages; i++ {
+				err = e.Request.Visit(region.URL() + searchUrl + fmt.Sprintf("&page=%d", i))
 				if err != nil {
 					errors = append(errors, fmt.Errorf("Could not spawn child page: %w", err))
 				}
@@ -69,74 +86,129 @@ func SetCharacterLodestoneID(c *ffxiv.Character) error {
 	})
 
 	collector.OnError(func(resp *colly.Response, err error) {
-		errors = append(errors, err)
+		mu.Lock()
+		defer mu.Unlock()
+		errors = append(errors, responseError(resp, err))
 	})
 
-	err := collector.Visit(lodestoneUrl + searchUrl)
+	err := collector.Visit(region.URL() + searchUrl)
 	if err != nil {
-		return fmt.Errorf("Could not visit Lodestone: %w", err)
+		return nil, fmt.Errorf("Could not visit Lodestone: %w", err)
 	}
 	collector.Wait()
 
 	if len(errors) != 0 {
-		return buildError(errors)
+		return nil, buildError(errors)
 	}
 
-	if len(charIDs) == 0 {
-		return fmt.Errorf(
-			"No character found on the Lodestone for `%v (%v)`! If you recently renamed yourself or server transferred it can take up to a day for this to be reflected on the Lodestone; please try again later.",
-			c.Name(),
-			c.World,
-		)
+	return results, nil
+}
+
+// characterIDRegexp finds the Lodestone ID in a character's profile URL on
+// any of the Lodestone's sites.
+var characterIDRegexp = regexp.MustCompile(`^(?:https?://)?[a-z]{2}\.finalfantasyxiv\.com/lodestone/character/(\d+)/?`)
+
+// ParseCharacterID reads a Lodestone ID given either as the number or as a
+// link to the character's profile.
+func ParseCharacterID(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if match := characterIDRegexp.FindStringSubmatch(s); match != nil {
+		s = match[1]
 	}
-	if len(charIDs) > 1 {
-		return fmt.Errorf(
-			"Too many characters found for name %s (%s)! Ensure it is exactly your character name.\nAlternatively, import your character to FFlogs at https://www.fflogs.com/lodestone/import to circumvent a Lodestone search.",
-			c.Name(),
-			c.World,
-		)
+	id, err := strconv.Atoi(s)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("`%s` is not a Lodestone profile URL or ID!", s)
 	}
+	return id, nil
+}
 
-	c.LodestoneID = charIDs[0]
-
-	return nil
+type searchPage struct {
+	results     []*SearchResult
+	currentPage int
+	maxPages    int
 }
 
-func CharacterIsOwnedByDiscordUser(c *ffxiv.Character, discordId string) (bool, error) {
-	collector := colly.NewCollector(colly.Async(true))
-	collector.SetRequestTimeout(30 * time.Second)
-	errors := []error{}
-	bio := ""
+// parseSearchPage returns the characters named name on a page of Lodestone
+// character search results.
+func parseSearchPage(doc *goquery.Selection, name string) (*searchPage, error) {
+	err := checkMaintenance(doc)
+	if err != nil {
+		return nil, err
+	}
 
-	collector.OnHTML(".character__content.selected", func(e *colly.HTMLElement) {
-		bio = e.ChildText(".character__selfintroduction")
-	})
+	window := doc.Find(".ldst__window")
+	if window.Length() == 0 {
+		return nil, markupChanged("character search", ".ldst__window")
+	}
+	entries := window.Find(".entry")
+	if entries.Length() == 0 && window.Find(".parts__zero").Length() == 0 {
+		return nil, markupChanged("character search", "search results")
+	}
 
-	collector.OnError(func(resp *colly.Response, err error) {
-		errors = append(errors, err)
+	page := &searchPage{results: []*SearchResult{}, currentPage: 1, maxPages: 1}
+	entries.EachWithBreak(func(_ int, entry *goquery.Selection) bool {
+		entryName := entry.Find(".entry__name")
+		if entryName.Length() == 0 {
+			err = markupChanged("character search", ".entry__name")
+			return false
+		}
+		if !strings.EqualFold(strings.TrimSpace(entryName.Text()), name) {
+			return true
+		}
+		result := &SearchResult{Name: strings.TrimSpace(entryName.Text())}
+		linkText, _ := entry.Find(".entry__link").Attr("href")
+		_, scanErr := fmt.Sscanf(linkText, "/lodestone/character/%d/", &result.LodestoneID)
+		if scanErr != nil {
+			err = markupChanged("character search", "character link")
+			return false
+		}
+		// Worlds are shown with their datacenter, like "Gilgamesh [Aether]".
+		result.World, _, _ = strings.Cut(strings.TrimSpace(entry.Find(".entry__world").Text()), " ")
+		result.Avatar, _ = entry.Find(".entry__chara__face img").Attr("src")
+		page.results = append(page.results, result)
+		return true
 	})
-
-	err := collector.Visit(lodestoneUrl + fmt.Sprintf("/character/%d/", c.LodestoneID))
 	if err != nil {
-		return false, fmt.Errorf("Could not visit Lodestone: %w", err)
+		return nil, err
 	}
-	collector.Wait()
 
-	if len(errors) != 0 {
-		return false, buildError(errors)
+	pager := window.Find("ul.btn__pager .btn__pager__current").First()
+	if pager.Length() != 0 {
+		page.currentPage, page.maxPages, err = parsePager(strings.TrimSpace(pager.Text()))
+		if err != nil {
+			return nil, markupChanged("character search", "page numbers")
+		}
 	}
 
-	if !strings.Contains(bio, c.LodestoneSlug(discordId)) {
-		return false, nil
-	}
+	return page, nil
+}
+
+// pagerRegexp finds the page numbers in a pager, which every region words
+// differently: "Page 1 of 3", "Page 1 sur 3", "Seite 1 von 3", "1ページ / 3ページ".
+var pagerRegexp = regexp.MustCompile(`\d+`)
 
-	return true, nil
+// parsePager returns the current page and the number of pages from a pager.
+func parsePager(pager string) (int, int, error) {
+	numbers := pagerRegexp.FindAllString(pager, -1)
+	if len(numbers) < 2 {
+		return 0, 0, fmt.Errorf("Could not find pager!")
+	}
+	currentPage, err := strconv.Atoi(numbers[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("Could not parse pager: %w", err)
+	}
+	maxPages, err := strconv.Atoi(numbers[len(numbers)-1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("Could not parse pager: %w", err)
+	}
+	return currentPage, maxPages, nil
 }
 
-func buildError(errors []error) error {
-	errorText := strings.Builder{}
-	for _, e := range errors {
-		errorText.WriteString(e.Error() + "\n")
+// buildError combines the errors from a scrape. The result still matches
+// each of them with errors.Is.
+func buildError(errs []error) error {
+	if len(errs) == 1 {
+		return errs[0]
 	}
-	return fmt.Errorf("Encountered search errors:\n%v", errorText.String())
+	return fmt.Errorf("Encountered search errors:\n%w", errors.Join(errs...))
 }
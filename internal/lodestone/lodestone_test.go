@@ -0,0 +1,189 @@
+package lodestone
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/Veraticus/clearingway/internal/ffxiv"
+	"github.com/stretchr/testify/assert"
+)
+
+func fixture(t *testing.T, name string) *goquery.Selection {
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	doc, err := goquery.NewDocumentFromReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc.Selection
+}
+
+func TestParseSearchPage(t *testing.T) {
+	page, err := parseSearchPage(fixture(t, "search.html"), "Tataru Taru")
+	assert.NoError(t, err)
+	assert.Equal(t, []*SearchResult{{
+		LodestoneID: 12345678,
+		Name:        "Tataru Taru",
+		World:       "Gilgamesh",
+		Avatar:      "https://img2.finalfantasyxiv.com/f/face.jpg",
+	}}, page.results)
+	assert.Equal(t, 1, page.currentPage)
+	assert.Equal(t, 2, page.maxPages)
+
+	page, err = parseSearchPage(fixture(t, "search_none.html"), "Tataru Taru")
+	assert.NoError(t, err)
+	assert.Empty(t, page.results)
+
+	_, err = parseSearchPage(fixture(t, "maintenance.html"), "Tataru Taru")
+	assert.ErrorIs(t, err, ErrMaintenance)
+
+	_, err = parseSearchPage(fixture(t, "changed.html"), "Tataru Taru")
+	assert.ErrorIs(t, err, ErrMarkupChanged)
+}
+
+func TestParseCharacterID(t *testing.T) {
+	for _, s := range []string{
+		"12345678",
+		" 12345678 ",
+		"https://na.finalfantasyxiv.com/lodestone/character/12345678/",
+		"eu.finalfantasyxiv.com/lodestone/character/12345678/achievement/",
+		"https://jp.finalfantasyxiv.com/lodestone/character/12345678",
+	} {
+		id, err := ParseCharacterID(s)
+		assert.NoError(t, err, s)
+		assert.Equal(t, 12345678, id, s)
+	}
+
+	_, err := ParseCharacterID("https://example.com/lodestone/character/12345678/")
+	assert.Error(t, err)
+	_, err = ParseCharacterID("Tataru Taru")
+	assert.Error(t, err)
+}
+
+func TestParseCharacterPage(t *testing.T) {
+	profile, err := parseCharacterPage(fixture(t, "character.html"))
+	assert.NoError(t, err)
+	assert.Contains(t, profile.Bio, "clearingway-12345")
+	assert.Equal(t, "Scion of the Seventh Dawn", profile.Title)
+	assert.Equal(t, "9231253336202687179", profile.FreeCompanyID)
+	assert.Equal(t, "Scions of the Seventh Dawn", profile.FreeCompanyName)
+	assert.Equal(t, "Tataru Taru", profile.Name)
+	assert.Equal(t, "Gilgamesh", profile.World)
+	assert.Equal(t, "https://img2.finalfantasyxiv.com/f/abc_96x96.jpg", profile.Avatar)
+	assert.Equal(t, "https://img2.finalfantasyxiv.com/f/abc_640x873.jpg", profile.Portrait)
+	assert.Equal(t, 100, profile.ActiveJobLevel)
+	assert.Equal(t, "Immortal Flames", profile.GrandCompany)
+	assert.Equal(t, "Flame Captain", profile.GrandCompanyRank)
+	assert.Len(t, profile.Jobs, 4)
+	assert.Equal(t, 100, profile.JobLevel("white mage"))
+	assert.Equal(t, 100, profile.JobLevel("Conjurer"))
+	assert.Equal(t, 0, profile.JobLevel("Monk"))
+	assert.Equal(t, 92, profile.JobLevel("Carpenter"))
+	assert.Equal(t, 0, profile.MinJobLevel())
+
+	// Block titles are translated on the other sites.
+	profile, err = parseCharacterPage(fixture(t, "character_de.html"))
+	assert.NoError(t, err)
+	assert.Equal(t, "Legion der Unsterblichen", profile.GrandCompany)
+	assert.Equal(t, "Flammen-Hauptmann", profile.GrandCompanyRank)
+
+	_, err = parseCharacterPage(fixture(t, "maintenance.html"))
+	assert.ErrorIs(t, err, ErrMaintenance)
+
+	_, err = parseCharacterPage(fixture(t, "changed.html"))
+	assert.ErrorIs(t, err, ErrMarkupChanged)
+}
+
+func TestParseAchievementPage(t *testing.T) {
+	page, err := parseAchievementPage(fixture(t, "achievements.html"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Lone Hero", "Pal-less Palace III"}, page.achievements)
+	assert.Equal(t, 3, page.maxPages)
+
+	page, err = parseAchievementPage(fixture(t, "achievements_de.html"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Einsamer Held"}, page.achievements)
+	assert.Equal(t, 3, page.maxPages)
+
+	_, err = parseAchievementPage(fixture(t, "achievements_private.html"))
+	assert.ErrorIs(t, err, ErrPrivate)
+
+	_, err = parseAchievementPage(fixture(t, "maintenance.html"))
+	assert.ErrorIs(t, err, ErrMaintenance)
+
+	_, err = parseAchievementPage(fixture(t, "changed.html"))
+	assert.ErrorIs(t, err, ErrMarkupChanged)
+}
+
+func TestParseFreeCompanyMemberPage(t *testing.T) {
+	page, err := parseFreeCompanyMemberPage(fixture(t, "freecompany_members.html"))
+	assert.NoError(t, err)
+	assert.Equal(t, []int{12345678, 23456789}, page.memberIDs)
+	assert.Equal(t, 3, page.maxPages)
+
+	_, err = parseFreeCompanyMemberPage(fixture(t, "maintenance.html"))
+	assert.ErrorIs(t, err, ErrMaintenance)
+
+	_, err = parseFreeCompanyMemberPage(fixture(t, "changed.html"))
+	assert.ErrorIs(t, err, ErrMarkupChanged)
+}
+
+func TestParsePager(t *testing.T) {
+	for region, pager := range map[Region]string{
+		RegionNA: "Page 2 of 14",
+		RegionFR: "Page 2 sur 14",
+		RegionDE: "Seite 2 von 14",
+		RegionJP: "2ページ / 14ページ",
+	} {
+		currentPage, maxPages, err := parsePager(pager)
+		assert.NoError(t, err, region)
+		assert.Equal(t, 2, currentPage, region)
+		assert.Equal(t, 14, maxPages, region)
+	}
+
+	_, _, err := parsePager("")
+	assert.Error(t, err)
+}
+
+func TestAchievementRegexp(t *testing.T) {
+	for region, text := range map[Region]string{
+		RegionNA: `Tataru Taru earned the achievement "Lone Hero"!`,
+		RegionEU: `Tataru Taru earned the achievement “Lone Hero”!`,
+		RegionFR: `Tataru Taru a obtenu le haut fait « Lone Hero » !`,
+		RegionDE: `Tataru Taru hat die Errungenschaft „Lone Hero“ erhalten!`,
+		RegionJP: `Tataru Taruはアチーブメント「Lone Hero」を達成した！`,
+	} {
+		match := achievementRegexp.FindStringSubmatch(text)
+		if assert.NotNil(t, match, region) {
+			assert.Equal(t, "Lone Hero", match[1], region)
+		}
+	}
+}
+
+func TestRegion(t *testing.T) {
+	assert.Equal(t, RegionEU, RegionForCharacter(&ffxiv.Character{World: "Twintania"}))
+	assert.Equal(t, RegionJP, RegionForCharacter(&ffxiv.Character{World: "Tonberry"}))
+	assert.Equal(t, RegionNA, RegionForCharacter(&ffxiv.Character{World: "Ravana"}))
+	assert.Equal(t, "https://de.finalfantasyxiv.com/lodestone/my/setting/profile/", RegionDE.ProfileSettingsURL())
+
+	_, err := ParseRegion("oc")
+	assert.Error(t, err)
+}
+
+func TestCheckMaintenance(t *testing.T) {
+	assert.ErrorIs(t, checkMaintenance(fixture(t, "maintenance.html")), ErrMaintenance)
+
+	// A free company named after maintenance is not maintenance.
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(
+		"<html><head><title>Maintenance Crew | FINAL FANTASY XIV, The Lodestone</title></head><body></body></html>",
+	))
+	assert.NoError(t, err)
+	assert.NoError(t, checkMaintenance(doc.Selection))
+}
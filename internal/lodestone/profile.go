@@ -0,0 +1,239 @@
+package lodestone
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Veraticus/clearingway/internal/ffxiv"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/gocolly/colly"
+)
+
+// ProfileCacheTTL is how long a character's profile is reused, so that a
+// single command does not fetch the same page more than once.
+var ProfileCacheTTL = 5 * time.Minute
+
+// levelRegexp finds the level in the active job's "LEVEL 100".
+var levelRegexp = regexp.MustCompile(`\d+`)
+
+var profileCache = &struct {
+	mu      sync.Mutex
+	entries map[string]*profileCacheEntry
+}{entries: map[string]*profileCacheEntry{}}
+
+type profileCacheEntry struct {
+	profile *CharacterProfile
+	fetched time.Time
+}
+
+// CharacterProfile is what a character's Lodestone page says about them.
+type CharacterProfile struct {
+	LodestoneID      int
+	Name             string
+	World            string
+	Bio              string
+	Title            string
+	Avatar           string
+	Portrait         string
+	ActiveJobIcon    string
+	ActiveJobLevel   int
+	GrandCompany     string
+	GrandCompanyRank string
+	FreeCompanyID    string
+	FreeCompanyName  string
+	// Jobs are every class and job on the character page, in the order the
+	// Lodestone lists them. Jobs that are not unlocked have level 0.
+	Jobs []*JobLevel
+}
+
+// JobLevel is a character's level in one class or job. Combat jobs are named
+// like "Paladin / Gladiator", so both names match.
+type JobLevel struct {
+	Name  string
+	Level int
+}
+
+// JobLevel returns the character's level in a class or job, by either name.
+func (cp *CharacterProfile) JobLevel(name string) int {
+	for _, job := range cp.Jobs {
+		for _, n := range strings.Split(job.Name, "/") {
+			if strings.EqualFold(strings.TrimSpace(n), name) {
+				return job.Level
+			}
+		}
+	}
+	return 0
+}
+
+// MinJobLevel returns the lowest level among all of the character's classes
+// and jobs, which is 0 unless every one is unlocked.
+func (cp *CharacterProfile) MinJobLevel() int {
+	if len(cp.Jobs) == 0 {
+		return 0
+	}
+	min := cp.Jobs[0].Level
+	for _, job := range cp.Jobs[1:] {
+		if job.Level < min {
+			min = job.Level
+		}
+	}
+	return min
+}
+
+// GetCharacterProfile fetches the character's profile from the Lodestone.
+func GetCharacterProfile(c *ffxiv.Character, region Region) (*CharacterProfile, error) {
+	if c.LodestoneID == 0 {
+		return nil, fmt.Errorf("Lodestone ID not set for %s (%s)!", c.Name(), c.World)
+	}
+
+	return GetCharacterProfileByID(c.LodestoneID, region)
+}
+
+// CachedCharacterProfile returns the character's profile, reusing one fetched
+// in the last ProfileCacheTTL. Anything checking for a change the member just
+// made, like a code in their bio, should use GetCharacterProfile instead.
+func CachedCharacterProfile(c *ffxiv.Character, region Region) (*CharacterProfile, error) {
+	profileCache.mu.Lock()
+	entry := profileCache.entries[profileCacheKey(c.LodestoneID, region)]
+	profileCache.mu.Unlock()
+	if entry != nil && time.Since(entry.fetched) < ProfileCacheTTL {
+		return entry.profile, nil
+	}
+
+	return GetCharacterProfile(c, region)
+}
+
+func profileCacheKey(lodestoneID int, region Region) string {
+	return fmt.Sprintf("%s-%d", region, lodestoneID)
+}
+
+// GetCharacterProfileByID fetches the profile of the character with a
+// Lodestone ID.
+func GetCharacterProfileByID(lodestoneID int, region Region) (*CharacterProfile, error) {
+	collector := DefaultClient.newCollector()
+	errors := []error{}
+	var profile *CharacterProfile
+
+	collector.OnHTML("html", func(e *colly.HTMLElement) {
+		var err error
+		profile, err = parseCharacterPage(e.DOM)
+		if err != nil {
+			errors = append(errors, err)
+		}
+	})
+
+	collector.OnError(func(resp *colly.Response, err error) {
+		errors = append(errors, responseError(resp, err))
+	})
+
+	err := collector.Visit(region.CharacterURL(lodestoneID))
+	if err != nil {
+		return nil, fmt.Errorf("Could not visit Lodestone: %w", err)
+	}
+	collector.Wait()
+
+	if len(errors) != 0 {
+		return nil, buildError(errors)
+	}
+	if profile == nil {
+		return nil, markupChanged("character", "page")
+	}
+	profile.LodestoneID = lodestoneID
+
+	profileCache.mu.Lock()
+	profileCache.entries[profileCacheKey(lodestoneID, region)] = &profileCacheEntry{profile: profile, fetched: time.Now()}
+	profileCache.mu.Unlock()
+
+	return profile, nil
+}
+
+// parseCharacterPage reads a character's Lodestone page.
+func parseCharacterPage(doc *goquery.Selection) (*CharacterProfile, error) {
+	err := checkMaintenance(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	content := doc.Find(".character__content.selected")
+	if content.Length() == 0 {
+		return nil, markupChanged("character", ".character__content")
+	}
+	bio := content.Find(".character__selfintroduction")
+	if bio.Length() == 0 {
+		return nil, markupChanged("character", ".character__selfintroduction")
+	}
+
+	profile := &CharacterProfile{
+		Name:  strings.TrimSpace(doc.Find(".frame__chara__name").First().Text()),
+		Bio:   strings.TrimSpace(bio.Text()),
+		Title: strings.TrimSpace(doc.Find(".frame__chara__title").First().Text()),
+		Jobs:  []*JobLevel{},
+	}
+	// Worlds are shown with their datacenter, like "Gilgamesh [Aether]".
+	world := strings.TrimSpace(doc.Find(".frame__chara__world").First().Text())
+	profile.World, _, _ = strings.Cut(world, " ")
+	profile.Avatar, _ = doc.Find(".frame__chara__face img").First().Attr("src")
+	profile.Portrait, _ = content.Find(".character__detail__image img").First().Attr("src")
+	profile.ActiveJobIcon, _ = content.Find(".character__class_icon img").First().Attr("src")
+
+	activeJobLevel := content.Find(".character__class__data").First()
+	if activeJobLevel.Length() != 0 {
+		level := levelRegexp.FindString(activeJobLevel.Text())
+		profile.ActiveJobLevel, err = strconv.Atoi(level)
+		if err != nil {
+			return nil, markupChanged("character", "active job level")
+		}
+	}
+
+	// The blocks are race, nameday, city-state and, for characters in one,
+	// Grand Company. Their titles are translated, so they are told apart by
+	// position.
+	gcBlock := content.Find(".character-block").Eq(3)
+	if gcBlock.Length() != 0 {
+		name := strings.TrimSpace(gcBlock.Find(".character-block__name").Text())
+		gc, rank, _ := strings.Cut(name, "/")
+		profile.GrandCompany = strings.TrimSpace(gc)
+		profile.GrandCompanyRank = strings.TrimSpace(rank)
+	}
+
+	doc.Find(".character__level__list li").EachWithBreak(func(_ int, li *goquery.Selection) bool {
+		name, ok := li.Find("img").Attr("data-tooltip")
+		if !ok {
+			err = markupChanged("character", "job names")
+			return false
+		}
+		job := &JobLevel{Name: strings.TrimSpace(name)}
+		// Jobs that are not unlocked show "-".
+		level := strings.TrimSpace(li.Text())
+		if level != "-" {
+			job.Level, err = strconv.Atoi(level)
+			if err != nil {
+				err = markupChanged("character", "job levels")
+				return false
+			}
+		}
+		profile.Jobs = append(profile.Jobs, job)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Characters outside a free company have no link to one.
+	freeCompany := doc.Find(".character__freecompany__name a").First()
+	if href, ok := freeCompany.Attr("href"); ok {
+		_, err := fmt.Sscanf(href, "/lodestone/freecompany/%s", &profile.FreeCompanyID)
+		if err != nil {
+			return nil, markupChanged("character", "free company link")
+		}
+		profile.FreeCompanyID = strings.TrimSuffix(profile.FreeCompanyID, "/")
+		profile.FreeCompanyName = strings.TrimSpace(freeCompany.Text())
+	}
+
+	return profile, nil
+}
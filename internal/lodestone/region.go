@@ -0,0 +1,79 @@
+package lodestone
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Veraticus/clearingway/internal/ffxiv"
+)
+
+// Region is one of the Lodestone's regional sites, by its subdomain. They
+// all serve the same characters with the same markup, in different
+// languages.
+type Region string
+
+var (
+	RegionNA Region = "na"
+	RegionEU Region = "eu"
+	RegionJP Region = "jp"
+	RegionFR Region = "fr"
+	RegionDE Region = "de"
+)
+
+// lodestoneUrlFormat builds a region's Lodestone base URL from its subdomain.
+var lodestoneUrlFormat = "https://%s.finalfantasyxiv.com/lodestone"
+
+func ParseRegion(s string) (Region, error) {
+	region := Region(strings.ToLower(s))
+	switch region {
+	case RegionNA, RegionEU, RegionJP, RegionFR, RegionDE:
+		return region, nil
+	}
+	return "", fmt.Errorf("Unknown Lodestone region %s, expected one of na, eu, jp, fr or de", s)
+}
+
+// RegionForCharacter returns the Lodestone site for the character's physical
+// datacenter. Oceanian characters, and characters on unknown worlds, use the
+// North American site.
+func RegionForCharacter(c *ffxiv.Character) Region {
+	switch c.PhysicalDatacenter() {
+	case ffxiv.EU:
+		return RegionEU
+	case ffxiv.JP:
+		return RegionJP
+	}
+	return RegionNA
+}
+
+func (r Region) URL() string {
+	if len(r) == 0 {
+		r = RegionNA
+	}
+	return fmt.Sprintf(lodestoneUrlFormat, string(r))
+}
+
+func (r Region) CharacterURL(lodestoneID int) string {
+	return fmt.Sprintf("%s/character/%d/", r.URL(), lodestoneID)
+}
+
+// ProfileSettingsURL is where characters edit the profile ownership is
+// verified against.
+func (r Region) ProfileSettingsURL() string {
+	return r.URL() + "/my/setting/profile/"
+}
+
+// AccountSettingsURL is where characters choose whether their achievements
+// are public.
+func (r Region) AccountSettingsURL() string {
+	return r.URL() + "/my/setting/account/"
+}
+
+// English returns the English-language site serving the same characters as
+// the region.
+func (r Region) English() Region {
+	switch r {
+	case RegionEU, RegionFR, RegionDE:
+		return RegionEU
+	}
+	return RegionNA
+}
@@ -52,7 +52,10 @@ func main() {
 		panic(fmt.Errorf("Could not unmarshal config.yaml: %w", err))
 	}
 
-	c.Init()
+	err = c.Init()
+	if err != nil {
+		panic(fmt.Errorf("Could not initialize Clearingway: %w", err))
+	}
 
 	fmt.Printf("Clearingway is: %+v\n", c)
 	for _, guild := range c.Guilds.Guilds {
@@ -66,6 +69,10 @@ func main() {
 			fmt.Printf("Achievement roles: %+v\n", guild.AchievementRoles.Roles)
 		}
 
+		if guild.TierRoles != nil {
+			fmt.Printf("Tier roles: %+v\n", guild.TierRoles.Roles)
+		}
+
 		if guild.RelevantParsingRoles != nil {
 			fmt.Printf("Relevant parsing roles: %+v\n", guild.RelevantParsingRoles.Roles)
 		}
@@ -82,6 +89,10 @@ func main() {
 			fmt.Printf("Ultimate flexing roles: %+v\n", guild.UltimateFlexingRoles.Roles)
 		}
 
+		if guild.UltimateProgRoles != nil {
+			fmt.Printf("Ultimate prog roles: %+v\n", guild.UltimateProgRoles.Roles)
+		}
+
 		if guild.DatacenterRoles != nil {
 			fmt.Printf("Datacenter roles: %+v\n", guild.DatacenterRoles.Roles)
 		}
@@ -122,6 +133,11 @@ func start(c *clearingway.Clearingway) {
 		time.Sleep(2 * time.Second)
 	}
 	c.Discord.Session.AddHandler(c.InteractionCreate)
+	err = c.CheckProgExpiry()
+	if err != nil {
+		panic(err)
+	}
+	go c.StartSweeps(time.Hour)
 
 	sc := make(chan os.Signal, 1)
 	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
@@ -162,19 +178,25 @@ func clears(c *clearingway.Clearingway) {
 	err = c.Fflogs.SetCharacterLodestoneID(char)
 	if err != nil {
 		fmt.Printf("Could not find character in FF Logs: %+v\n", err)
-		err = lodestone.SetCharacterLodestoneID(char)
+		err = lodestone.SetCharacterLodestoneID(char, guild.LodestoneRegionFor(char))
 		if err != nil {
 			panic(fmt.Errorf("Could not find character in the Lodestone: %+v", err))
 		}
 	}
 
-	isOwner, err := lodestone.CharacterIsOwnedByDiscordUser(char, discordId)
+	char, err = c.SyncCharacter(guild, char)
+	if err != nil {
+		fmt.Printf("Could not sync %s (%s) with the Lodestone: %v\n", char.Name(), char.World, err)
+	}
+
+	isOwner, instructions, err := c.VerifyOwnership(guild, char, discordId)
 	if err != nil {
 		panic(err)
 	}
 	if !isOwner {
-		panic("That character is not owned by that Discord ID!")
+		panic("That character is not owned by that Discord ID!\n" + instructions)
 	}
+	guild.Link(char, discordId)
 
 	roleTexts, err := c.UpdateClearsForCharacterInGuild(char, discordId, guild)
 	if err != nil {
@@ -189,17 +211,21 @@ func clears(c *clearingway.Clearingway) {
 }
 
 func prog(c *clearingway.Clearingway) {
-	if len(os.Args) != 8 {
-		panic("Provide a world, firstName, lastName, guildId, discordId, and a report ID or url!")
+	if len(os.Args) < 8 {
+		panic("Provide a world, firstName, lastName, guildId, discordId, and report IDs or urls (or \"recent\")!")
 	}
 	world := os.Args[2]
 	firstName := os.Args[3]
 	lastName := os.Args[4]
 	guildId := os.Args[5]
 	discordId := os.Args[6]
-	reportId := os.Args[7]
+	reports := strings.Join(os.Args[7:], " ")
 
-	reportId = clearingway.CleanReportId(reportId)
+	recent := reports == "recent"
+	reportIds := []string{}
+	if !recent {
+		reportIds = clearingway.CleanReportIds(reports)
+	}
 
 	guild, ok := c.Guilds.Guilds[guildId]
 	if !ok {
@@ -225,21 +251,34 @@ func prog(c *clearingway.Clearingway) {
 	err = c.Fflogs.SetCharacterLodestoneID(char)
 	if err != nil {
 		fmt.Printf("Could not find character in FF Logs: %+v\n", err)
-		err = lodestone.SetCharacterLodestoneID(char)
+		err = lodestone.SetCharacterLodestoneID(char, guild.LodestoneRegionFor(char))
 		if err != nil {
 			panic(fmt.Errorf("Could not find character in the Lodestone: %+v", err))
 		}
 	}
 
-	isOwner, err := lodestone.CharacterIsOwnedByDiscordUser(char, discordId)
+	char, err = c.SyncCharacter(guild, char)
+	if err != nil {
+		fmt.Printf("Could not sync %s (%s) with the Lodestone: %v\n", char.Name(), char.World, err)
+	}
+
+	isOwner, instructions, err := c.VerifyOwnership(guild, char, discordId)
 	if err != nil {
 		panic(err)
 	}
 	if !isOwner {
-		panic("That character is not owned by that Discord ID!")
+		panic("That character is not owned by that Discord ID!\n" + instructions)
 	}
+	guild.Link(char, discordId)
 
-	progTexts, err := c.UpdateProgForCharacterInGuild(reportId, char, discordId, guild)
+	if recent {
+		reportIds, err = c.AddRecentReportIds(reportIds, char, guild)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	progTexts, progEmbeds, err := c.UpdateProgForCharacterInGuild(reportIds, char, discordId, guild, false, false)
 	if err != nil {
 		panic(err)
 	}
@@ -249,4 +288,7 @@ func prog(c *clearingway.Clearingway) {
 	for _, progText := range progTexts {
 		fmt.Printf(progText + "\n")
 	}
+	for _, progEmbed := range progEmbeds {
+		fmt.Printf("%s: %s\n", progEmbed.Title, progEmbed.URL)
+	}
 }